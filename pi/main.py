@@ -1,4 +1,8 @@
 import asyncio
+import hashlib
+import hmac
+import json
+import secrets
 import time
 from functools import wraps
 from quart import Quart, request, jsonify
@@ -32,11 +36,13 @@ TIME_DEFECATION: str = "time_defecation"
 TIMER: str = "timer_1"
 TIMER_2: str = "timer_2"
 SECRET_HEADER: str = "secret_header"
+API_KEY: str = "api_key"
+SIGNING_SECRET: str = "signing_secret"
 SERVER_ADDR: str = "server_addr"
-HEADER_CONFIG: str = "header_config"
 TIMER_1_THRESHOLD: str = "timer_1_threshold"
 TIMER_3_THRESHOLD: str = "timer_3_threshold"
 PHASE: str = "phase"
+SESSION_ID: str = "session_id"
 
 # Special constants
 HEADER_NAME: str = "X-PS-Header"
@@ -81,6 +87,7 @@ def reset_config(config: dict) -> None:
     config[TIME_URINATION] = -1
     config[TIME_DEFECATION] = -1
     config[PHASE] = -1
+    config[SESSION_ID] = -1
 
     config[TIMER] = None
     config[TIMER_2] = None
@@ -94,6 +101,21 @@ def create_app() -> Quart:
         print("Required env variable SECRET_HEADER not set. Exiting.")
         exit()
 
+    # Issued under the API keys tab of the dashboard, with the
+    # send-notification and report-session scopes
+    api_key = os.getenv("PI_API_KEY")
+
+    if api_key is None or api_key == "":
+        print("Required env variable PI_API_KEY not set. Exiting.")
+        exit()
+
+    # Shown along with the API key when it is issued
+    signing_secret = os.getenv("PI_SIGNING_SECRET")
+
+    if signing_secret is None or signing_secret == "":
+        print("Required env variable PI_SIGNING_SECRET not set. Exiting.")
+        exit()
+
     server_addr = os.getenv("SERVER_ADDR")
 
     if server_addr is None or server_addr == "":
@@ -134,11 +156,9 @@ def create_app() -> Quart:
 
     # Constants
     app.config[SECRET_HEADER] = secret_header
+    app.config[API_KEY] = api_key
+    app.config[SIGNING_SECRET] = signing_secret
     app.config[SERVER_ADDR] = "https://" + server_addr
-    app.config[HEADER_CONFIG] = {
-        "Content-Type": "application/json",
-        "X-PS-Header": secret_header,
-    }
     app.config[TIMER_1_THRESHOLD] = timer_1_threshold
     app.config[TIMER_3_THRESHOLD] = timer_3_threshold
     reset_config(app.config)
@@ -148,6 +168,43 @@ def create_app() -> Quart:
 app: Quart = create_app()
 
 
+# Headers signing the request to the web server with
+# HMAC-SHA256 over the method, path, timestamp, nonce and
+# body, keyed with the signing secret of the API key. Neither
+# is ever sent, see the signing package of the server.
+def signed_headers(method: str, path: str, body: bytes) -> dict:
+    timestamp = str(int(time.time()))
+    nonce = secrets.token_hex(16)
+    string_to_sign = "\n".join(
+        [method, path, timestamp, nonce, hashlib.sha256(body).hexdigest()]
+    )
+    signature = hmac.new(
+        app.config[SIGNING_SECRET].encode(), string_to_sign.encode(), hashlib.sha256
+    ).hexdigest()
+
+    return {
+        "Content-Type": "application/json",
+        "X-PS-Key-Id": app.config[API_KEY].split("_")[1],
+        "X-PS-Timestamp": timestamp,
+        "X-PS-Nonce": nonce,
+        "X-PS-Signature": signature,
+    }
+
+
+# Sends the data as json to the path of the web server,
+# signing the request
+async def send_signed(method: str, path: str, data: dict = None) -> httpx.Response:
+    body = b"" if data is None else json.dumps(data).encode()
+
+    async with httpx.AsyncClient() as client:
+        return await client.request(
+            method,
+            app.config[SERVER_ADDR] + path,
+            content=body,
+            headers=signed_headers(method, path, body),
+        )
+
+
 # Returns the appropriate http status code
 async def send_tele_message(
     message: str,
@@ -162,15 +219,43 @@ async def send_tele_message(
         # "silentMessage": silent_message,
     }
 
-    async with httpx.AsyncClient() as client:
-        response = await client.post(
-            app.config[SERVER_ADDR] + "/ext/api",
-            json=data,
-            headers=app.config[HEADER_CONFIG],
-        )
-        # print response status code
-        print(response.read(), response.status_code)
-        return response.status_code
+    response = await send_signed("POST", "/ext/api", data)
+    # print response status code
+    print(response.read(), response.status_code)
+    return response.status_code
+
+
+# Reports the completed session to the web server so that
+# it can be recorded. Returns the appropriate http status code
+async def send_session_result(outcome: str) -> int:
+    data = {
+        "sessionId": app.config.get(SESSION_ID),
+        "clientId": app.config.get(CLIENT_ID),
+        "businessType": app.config.get(BUSINESS_TYPE),
+        "outcome": outcome,
+        "startTime": app.config.get(TIMESTAMP_1),
+        "enterTime": app.config.get(TIMESTAMP_2),
+        "finishTime": app.config.get(TIMESTAMP_3),
+        "exitTime": time.time(),
+    }
+
+    response = await send_signed("POST", "/ext/session-result", data)
+    print(response.read(), response.status_code)
+    return response.status_code
+
+
+# Informs the web server of the current phase of the
+# session. Returns the appropriate http status code
+async def send_session_phase(phase: int) -> int:
+    data = {
+        "sessionId": app.config.get(SESSION_ID),
+        "clientId": app.config.get(CLIENT_ID),
+        "phase": phase,
+    }
+
+    response = await send_signed("PUT", "/ext/session", data)
+    print(response.read(), response.status_code)
+    return response.status_code
 
 
 # Returns True if timer and message sends successfully,
@@ -267,30 +352,21 @@ def auth_wrapper(func):
 
 
 # Returns the http status code from the web server
-async def test_server_connection(url: str, secret_header: str) -> int:
-    async with httpx.AsyncClient() as client:
-        response = await client.get(
-            url,
-            headers={
-                HEADER_NAME: secret_header,
-            },
-        )
-        message: str = ""
-        if response.status_code == HTTP_STATUS_OK:
-            message = "Test connection with server successful."
-        else:
-            message = "WARNING: Error during test connection with server. Please check for connection issues!"
+async def test_server_connection() -> int:
+    response = await send_signed("GET", "/ext")
+    message: str = ""
+    if response.status_code == HTTP_STATUS_OK:
+        message = "Test connection with server successful."
+    else:
+        message = "WARNING: Error during test connection with server. Please check for connection issues!"
 
-        print(message)
-        return response.status_code
+    print(message)
+    return response.status_code
 
 
 @app.route("/")
 async def index_handler():
-    web_server_status_code: int = await test_server_connection(
-        app.config[SERVER_ADDR] + "/ext",
-        app.config[SECRET_HEADER],
-    )
+    web_server_status_code: int = await test_server_connection()
 
     return (
         jsonify(
@@ -346,6 +422,7 @@ async def api_handler_post():
 
     try:
         data = await request.get_json()
+        json_session_id = data.get("sessionId")
         json_client_id = data.get("clientId")
         json_business_type = data.get("businessType")
         json_urination = data.get("urination")
@@ -360,6 +437,9 @@ async def api_handler_post():
                 HTTP_STATUS_BAD_REQUEST,
             )
 
+        app.config[SESSION_ID] = (
+            int(json_session_id) if json_session_id is not None else -1
+        )
         app.config[CLIENT_ID] = int(json_client_id)
         app.config[BUSINESS_TYPE] = json_business_type
         app.config[TIME_URINATION] = int(json_urination)
@@ -448,6 +528,7 @@ async def int_handler_get():
     )
     app.config[TIMESTAMP_2] = time.time()
     app.config[PHASE] = 2
+    await send_session_phase(2)
     return (
         jsonify(
             {
@@ -475,6 +556,7 @@ async def int_handler_post():
     )
     app.config[TIMESTAMP_3] = time.time()
     app.config[PHASE] = 3
+    await send_session_phase(3)
     return (
         jsonify(
             {
@@ -566,9 +648,10 @@ async def int_handler():
     if app.config[TIMER] is not None and not app.config[TIMER].done():
         app.config[TIMER].cancel()
 
-    # TODO: Update server with the new durations
+    status_code: int = await send_session_result(MESSAGE_TYPE_COMPLETE)
+    print(status_code)
 
-    status_code: int = await send_tele_message(
+    status_code = await send_tele_message(
         "Client has completed their toileting and has left the toilet.",
         MESSAGE_TYPE_COMPLETE,
     )
@@ -3,6 +3,8 @@ module github.com/genekkion/PottySenseServer
 go 1.21.6
 
 require (
+	PottySenseTelebot v0.0.0
+	github.com/genekkion/PottySenseShared v0.0.0
 	github.com/gorilla/csrf v1.7.2
 	github.com/gorilla/mux v1.8.1
 	github.com/joho/godotenv v1.5.1
@@ -17,6 +19,7 @@ require (
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/garyburd/redigo v1.6.4 // indirect
+	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1 // indirect
 	github.com/gorilla/securecookie v1.1.2 // indirect
 	github.com/gorilla/sessions v1.2.2 // indirect
 	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826 // indirect
@@ -27,3 +30,7 @@ require (
 	golang.org/x/net v0.14.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 )
+
+replace github.com/genekkion/PottySenseShared => ../shared
+
+replace PottySenseTelebot => ../telebot
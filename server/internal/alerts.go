@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/genekkion/PottySenseServer/internal/globals"
+	"github.com/genekkion/PottySenseShared/store"
+)
+
+// Records the alert of the client and queues it to the TOs
+// tracking the client, returning the number of TOs notified
+// and the number of channels which failed
+func (server *Server) createAlert(clientId int,
+	message string) (int, int, error) {
+	alertId, err := server.store.Alerts.Create(clientId, message)
+	if err != nil {
+		return 0, 0, err
+	}
+	notified, failed := server.notifyTracking(clientId, Notification{
+		Type:    "alert",
+		Title:   "ALERT!",
+		Message: message,
+		AlertId: alertId,
+	})
+	return notified, failed, nil
+}
+
+// Checks the unacknowledged alerts every tick,
+// until the server stops
+func (server *Server) runAlertEscalations() {
+	ticker := time.NewTicker(globals.ALERT_TICK * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		server.escalateAlerts(now)
+	}
+}
+
+// Escalates the alerts which have not been acknowledged
+// since they were last sent: first to the tracking TOs
+// again, then to all admins, then to the fallback chat
+func (server *Server) escalateAlerts(now time.Time) {
+	alerts, err := server.store.Alerts.ListUnacknowledged()
+	if err != nil {
+		log.Println("escalateAlerts() - db query")
+		log.Println(err)
+		return
+	}
+
+	for _, alert := range alerts {
+		if now.Sub(alert.EscalatedAt) < server.alertEscalation {
+			continue
+		}
+		level := alert.EscalationLevel + 1
+		if level > store.AlertLevelFallback ||
+			(level == store.AlertLevelFallback && server.alertFallbackChatId == "") {
+			continue
+		}
+
+		// Marked first so that the alert is not sent
+		// again if it is acknowledged in the meantime
+		err := server.store.Alerts.Escalate(alert.Id, level, now.UTC())
+		if err != nil {
+			continue
+		}
+		server.sendEscalatedAlert(alert, level)
+	}
+}
+
+// Sends the alert to the recipients of the escalation level
+func (server *Server) sendEscalatedAlert(alert store.Alert, level int) {
+	clientName := fmt.Sprintf("client %d", alert.ClientId)
+	client, err := server.store.Clients.Get(alert.ClientId)
+	if err == nil {
+		clientName = client.FirstName + " " + client.LastName
+	}
+	unacknowledged := fmt.Sprintf("Not acknowledged for %d min.",
+		int(time.Since(alert.CreatedAt).Minutes()))
+
+	notification := Notification{
+		ClientId: alert.ClientId,
+		Type:     "escalated",
+		Title:    "ESCALATED ALERT!",
+		Message:  clientName + ": " + alert.Message + "\n" + unacknowledged,
+		AlertId:  alert.Id,
+	}
+	notified := 0
+	switch level {
+	case store.AlertLevelRenotified:
+		notification.Type = "alert"
+		notification.Title = "ALERT REMINDER!"
+		notified, _ = server.notifyTracking(alert.ClientId, notification)
+	case store.AlertLevelAdmins:
+		admins, _, err := server.store.TOfficers.Find(store.TOFilter{
+			UserType: store.RoleAdmin,
+		})
+		if err != nil {
+			log.Println("sendEscalatedAlert() - db query admins")
+			log.Println(err)
+		}
+		notified, _ = server.notifyTOs(admins, notification)
+	case store.AlertLevelFallback:
+		// The group chat is always sent through telegram
+		err := server.enqueueNotification(0, store.ChannelTelegram,
+			server.alertFallbackChatId, notification)
+		if err != nil {
+			log.Println("sendEscalatedAlert() - queue fallback chat")
+			log.Println(err)
+		} else {
+			notified = 1
+		}
+	}
+
+	if notified == 0 {
+		log.Printf("sendEscalatedAlert() - no one notified of alert %d at level %d\n",
+			alert.Id, level)
+		return
+	}
+	go server.publishClientAlert(alert.ClientId, "alert",
+		"Escalated: "+alert.Message+" ("+unacknowledged+")")
+}
@@ -0,0 +1,379 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/genekkion/PottySenseShared/store"
+)
+
+// Number of days which can be shown on the Analytics tab
+var analyticsDayOptions = []int{7, 14, 30, 90}
+
+// Toilet usage of a client over a number of days,
+// as shown on the Analytics tab
+type ClientAnalytics struct {
+	Client Client
+	Days   int
+	From   time.Time
+	To     time.Time
+
+	TotalEntries int
+	// Averages of the completed entries, MM:SS
+	AverageUrination  string
+	AverageDefecation string
+	// Entries which timed out or took longer than the target
+	OverdueCount int
+
+	Frequency          svgBarChart
+	UrinationDuration  svgBarChart
+	DefecationDuration svgBarChart
+	Overdue            svgBarChart
+	Heatmap            svgHeatmap
+
+	Thresholds ClientThresholds
+}
+
+// Usage of a single day
+type analyticsDay struct {
+	Date            time.Time
+	UrinationCount  int
+	DefecationCount int
+	UrinationTotal  int // in seconds, of completed entries
+	UrinationDone   int
+	DefecationTotal int // in seconds, of completed entries
+	DefecationDone  int
+	OverdueCount    int
+}
+
+// Buckets the entries of the client by day and by
+// hour of the week, in the local time of the server.
+// Cancelled entries are left out.
+func newClientAnalytics(client store.Client, entries []store.ToiletEntry,
+	from time.Time, days int) ClientAnalytics {
+	analytics := ClientAnalytics{
+		Client: newClient(client),
+		Days:   days,
+		From:   from,
+		To:     from.AddDate(0, 0, days),
+	}
+
+	dailyUsage := make([]analyticsDay, days)
+	for i := range dailyUsage {
+		dailyUsage[i].Date = from.AddDate(0, 0, i)
+	}
+	// Weekday (Monday first) -> hour -> count
+	var heatmap [7][24]int
+
+	var urinationTotal, urinationDone int
+	var defecationTotal, defecationDone int
+	for _, entry := range entries {
+		if entry.Outcome == "cancelled" {
+			continue
+		}
+		startedAt := entry.StartedAt().In(time.Local)
+		// Rounded since days are not always 24 hours long
+		date := time.Date(startedAt.Year(), startedAt.Month(), startedAt.Day(),
+			0, 0, 0, 0, time.Local)
+		dayIndex := int(math.Round(date.Sub(from).Hours() / 24))
+		if dayIndex < 0 || dayIndex >= days {
+			continue
+		}
+		day := &dailyUsage[dayIndex]
+		analytics.TotalEntries++
+
+		target := client.Urination
+		if entry.BusinessType == "defecation" {
+			target = client.Defecation
+			day.DefecationCount++
+		} else {
+			day.UrinationCount++
+		}
+
+		if entry.Outcome == "timeout" || entry.Duration > target {
+			day.OverdueCount++
+			analytics.OverdueCount++
+		}
+
+		if entry.Outcome == "complete" {
+			if entry.BusinessType == "defecation" {
+				day.DefecationTotal += entry.Duration
+				day.DefecationDone++
+				defecationTotal += entry.Duration
+				defecationDone++
+			} else {
+				day.UrinationTotal += entry.Duration
+				day.UrinationDone++
+				urinationTotal += entry.Duration
+				urinationDone++
+			}
+		}
+
+		weekday := (int(startedAt.Weekday()) + 6) % 7
+		heatmap[weekday][startedAt.Hour()]++
+	}
+
+	analytics.AverageUrination = formatAverageDuration(urinationTotal, urinationDone)
+	analytics.AverageDefecation = formatAverageDuration(defecationTotal, defecationDone)
+
+	labels := make([]string, days)
+	urinationCounts := make([]float64, days)
+	defecationCounts := make([]float64, days)
+	urinationAverages := make([]float64, days)
+	defecationAverages := make([]float64, days)
+	overdueCounts := make([]float64, days)
+	for i, day := range dailyUsage {
+		labels[i] = day.Date.Format("02/01")
+		urinationCounts[i] = float64(day.UrinationCount)
+		defecationCounts[i] = float64(day.DefecationCount)
+		overdueCounts[i] = float64(day.OverdueCount)
+		if day.UrinationDone > 0 {
+			urinationAverages[i] = float64(day.UrinationTotal) / float64(day.UrinationDone)
+		}
+		if day.DefecationDone > 0 {
+			defecationAverages[i] = float64(day.DefecationTotal) / float64(day.DefecationDone)
+		}
+	}
+
+	analytics.Frequency = newBarChart(labels, []svgSeries{
+		{Name: "Urination", Class: "chart-urination", Values: urinationCounts},
+		{Name: "Defecation", Class: "chart-defecation", Values: defecationCounts},
+	}, 0)
+	analytics.UrinationDuration = newBarChart(labels, []svgSeries{
+		{Name: "Average urination (s)", Class: "chart-urination", Values: urinationAverages},
+	}, float64(client.Urination))
+	analytics.DefecationDuration = newBarChart(labels, []svgSeries{
+		{Name: "Average defecation (s)", Class: "chart-defecation", Values: defecationAverages},
+	}, float64(client.Defecation))
+	analytics.Overdue = newBarChart(labels, []svgSeries{
+		{Name: "Overdue", Class: "chart-overdue", Values: overdueCounts},
+	}, 0)
+	analytics.Heatmap = newHeatmap(heatmap)
+
+	return analytics
+}
+
+// Formats the average of the durations as MM:SS, "-" if none
+func formatAverageDuration(total int, count int) string {
+	if count == 0 {
+		return "-"
+	}
+	average := int(math.Round(float64(total) / float64(count)))
+	return fmt.Sprintf("%02d:%02d", average/60, average%60)
+}
+
+// Dimensions of the charts, in svg units
+const (
+	chartWidth        = 720
+	chartHeight       = 200
+	chartPaddingLeft  = 40
+	chartPaddingRight = 8
+	chartPaddingTop   = 8
+	chartPaddingBelow = 24
+	// Most labels shown on the x axis
+	chartMaxLabels = 15
+
+	heatmapCellWidth   = 26
+	heatmapCellHeight  = 20
+	heatmapPaddingLeft = 40
+	heatmapPaddingTop  = 16
+)
+
+// A series of values, one for each label of the chart
+type svgSeries struct {
+	Name   string
+	Class  string
+	Values []float64
+}
+
+type svgBar struct {
+	X      float64
+	Y      float64
+	Width  float64
+	Height float64
+	Class  string
+	Title  string
+}
+
+type svgLabel struct {
+	X    float64
+	Y    float64
+	Text string
+}
+
+// A bar chart, with the bars of each series grouped by label.
+// Everything is positioned here so that the template only
+// has to draw the elements.
+type svgBarChart struct {
+	Width   int
+	Height  int
+	Bars    []svgBar
+	XLabels []svgLabel
+	// Grid lines along with their labels
+	YLabels []svgLabel
+	Legend  []svgSeries
+	// Dashed line for the target, if any
+	HasTarget   bool
+	TargetY     float64
+	TargetLabel string
+	// Left and right of the plot area
+	Left  float64
+	Right float64
+}
+
+func newBarChart(labels []string, series []svgSeries, target float64) svgBarChart {
+	chart := svgBarChart{
+		Width:  chartWidth,
+		Height: chartHeight,
+		Legend: series,
+		Left:   chartPaddingLeft,
+		Right:  chartWidth - chartPaddingRight,
+	}
+
+	maxValue := target
+	for _, oneSeries := range series {
+		for _, value := range oneSeries.Values {
+			maxValue = math.Max(maxValue, value)
+		}
+	}
+	maxValue = niceCeiling(maxValue)
+
+	plotHeight := float64(chartHeight - chartPaddingTop - chartPaddingBelow)
+	bottom := float64(chartHeight - chartPaddingBelow)
+	toY := func(value float64) float64 {
+		return bottom - value/maxValue*plotHeight
+	}
+
+	for _, value := range []float64{0, maxValue / 2, maxValue} {
+		chart.YLabels = append(chart.YLabels, svgLabel{
+			Y:    toY(value),
+			Text: formatChartValue(value),
+		})
+	}
+	if target > 0 {
+		chart.HasTarget = true
+		chart.TargetY = toY(target)
+		chart.TargetLabel = "Target " + formatChartValue(target)
+	}
+
+	if len(labels) == 0 {
+		return chart
+	}
+	groupWidth := (chart.Right - chart.Left) / float64(len(labels))
+	barWidth := groupWidth * 0.8 / float64(len(series))
+	labelStep := int(math.Ceil(float64(len(labels)) / chartMaxLabels))
+
+	for i, label := range labels {
+		groupX := chart.Left + float64(i)*groupWidth
+		for j, oneSeries := range series {
+			value := oneSeries.Values[i]
+			if value <= 0 {
+				continue
+			}
+			chart.Bars = append(chart.Bars, svgBar{
+				X:      groupX + groupWidth*0.1 + float64(j)*barWidth,
+				Y:      toY(value),
+				Width:  barWidth,
+				Height: bottom - toY(value),
+				Class:  oneSeries.Class,
+				Title:  label + " " + oneSeries.Name + ": " + formatChartValue(value),
+			})
+		}
+		if i%labelStep == 0 {
+			chart.XLabels = append(chart.XLabels, svgLabel{
+				X:    groupX + groupWidth/2,
+				Y:    float64(chartHeight - 6),
+				Text: label,
+			})
+		}
+	}
+	return chart
+}
+
+// Rounds the value up to 1, 2 or 5 times a power of
+// 10 so that the axis labels are round numbers
+func niceCeiling(value float64) float64 {
+	if value <= 1 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(value)))
+	for _, step := range []float64{1, 2, 5, 10} {
+		if value <= step*magnitude {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+func formatChartValue(value float64) string {
+	if value == math.Trunc(value) {
+		return fmt.Sprint(int(value))
+	}
+	return fmt.Sprintf("%.1f", value)
+}
+
+type svgCell struct {
+	X       float64
+	Y       float64
+	Opacity string
+	Title   string
+}
+
+// Toilet usage by weekday and hour of the day
+type svgHeatmap struct {
+	Width      int
+	Height     int
+	CellWidth  int
+	CellHeight int
+	Cells      []svgCell
+	DayLabels  []svgLabel
+	HourLabels []svgLabel
+	MaxCount   int
+}
+
+func newHeatmap(counts [7][24]int) svgHeatmap {
+	heatmap := svgHeatmap{
+		Width:      heatmapPaddingLeft + 24*heatmapCellWidth,
+		Height:     heatmapPaddingTop + 7*heatmapCellHeight,
+		CellWidth:  heatmapCellWidth - 2,
+		CellHeight: heatmapCellHeight - 2,
+	}
+	for _, hours := range counts {
+		for _, count := range hours {
+			heatmap.MaxCount = max(heatmap.MaxCount, count)
+		}
+	}
+
+	weekdays := []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+	for day, hours := range counts {
+		y := float64(heatmapPaddingTop + day*heatmapCellHeight)
+		heatmap.DayLabels = append(heatmap.DayLabels, svgLabel{
+			X:    0,
+			Y:    y + heatmapCellHeight*0.7,
+			Text: weekdays[day],
+		})
+		for hour, count := range hours {
+			// Empty cells are still drawn faintly
+			opacity := 0.05
+			if heatmap.MaxCount > 0 && count > 0 {
+				opacity = 0.15 + 0.85*float64(count)/float64(heatmap.MaxCount)
+			}
+			heatmap.Cells = append(heatmap.Cells, svgCell{
+				X:       float64(heatmapPaddingLeft + hour*heatmapCellWidth),
+				Y:       y,
+				Opacity: fmt.Sprintf("%.2f", opacity),
+				Title: fmt.Sprintf("%s %02d:00 - %02d:59: %d",
+					weekdays[day], hour, hour, count),
+			})
+		}
+	}
+	for hour := 0; hour < 24; hour += 3 {
+		heatmap.HourLabels = append(heatmap.HourLabels, svgLabel{
+			X:    float64(heatmapPaddingLeft + hour*heatmapCellWidth),
+			Y:    heatmapPaddingTop - 4,
+			Text: fmt.Sprintf("%02d", hour),
+		})
+	}
+	return heatmap
+}
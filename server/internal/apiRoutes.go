@@ -0,0 +1,593 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/genekkion/PottySenseServer/internal/globals"
+	"github.com/genekkion/PottySenseShared/store"
+	"github.com/gorilla/mux"
+)
+
+// Adds the routes of the JSON API. All responses are json,
+// with errors replied as { "error": "<message>" }.
+func (server *Server) addApiRoutes() {
+	router := server.router
+
+	router.HandleFunc("/api/v1/clients", server.apiKeyHeaderWrapper(
+		server.extWrapper(store.ScopeApi, server.apiClientsHandler)))
+	router.HandleFunc("/api/v1/clients/{id:[0-9]+}", server.apiKeyHeaderWrapper(
+		server.extWrapper(store.ScopeApi, server.apiClientHandler)))
+	router.HandleFunc("/api/v1/clients/{id:[0-9]+}/officers", server.apiKeyHeaderWrapper(
+		server.extWrapper(store.ScopeApi, server.apiClientOfficersHandler)))
+
+	router.HandleFunc("/api/v1/entries", server.apiKeyHeaderWrapper(
+		server.extWrapper(store.ScopeApi, server.apiEntriesHandler)))
+
+	router.HandleFunc("/api/v1/officers", server.apiKeyHeaderWrapper(
+		server.extWrapper(store.ScopeApi, server.apiOfficersHandler)))
+	router.HandleFunc("/api/v1/officers/{id:[0-9]+}", server.apiKeyHeaderWrapper(
+		server.extWrapper(store.ScopeApi, server.apiOfficerHandler)))
+	router.HandleFunc("/api/v1/officers/{id:[0-9]+}/tracking", server.apiKeyHeaderWrapper(
+		server.extWrapper(store.ScopeApi, server.apiTrackingHandler)))
+	router.HandleFunc("/api/v1/officers/{id:[0-9]+}/tracking/{clientId:[0-9]+}", server.apiKeyHeaderWrapper(
+		server.extWrapper(store.ScopeApi, server.apiTrackingClientHandler)))
+}
+
+// /api/v1/clients
+func (server *Server) apiClientsHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		server.apiClientsList(writer, request)
+	case http.MethodPost:
+		server.apiClientCreate(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /api/v1/clients "GET"
+// Query parameters: search, gender, limit, offset
+func (server *Server) apiClientsList(writer http.ResponseWriter,
+	request *http.Request) {
+	page, ok := parsePage(writer, request)
+	if !ok {
+		return
+	}
+
+	query := request.URL.Query()
+	clients, total, err := server.store.Clients.Find(store.ClientFilter{
+		Search: query.Get("search"),
+		Gender: strings.ToLower(query.Get("gender")),
+		Page:   page,
+	})
+	if err != nil {
+		log.Println("apiClientsList(), db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	writePage(writer, "clients", clients, total, page)
+}
+
+// Client details as accepted by the api
+type apiClientBody struct {
+	FirstName  string `json:"firstName"`
+	LastName   string `json:"lastName"`
+	Gender     string `json:"gender"`
+	Urination  int    `json:"urination"`
+	Defecation int    `json:"defecation"`
+}
+
+// Decodes and validates the client in the request body,
+// replying with the error if invalid
+func decodeApiClient(writer http.ResponseWriter,
+	request *http.Request) (store.Client, bool) {
+	var body apiClientBody
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Invalid json body.",
+		})
+		return store.Client{}, false
+	}
+
+	client := store.Client{
+		FirstName:  strings.TrimSpace(body.FirstName),
+		LastName:   strings.TrimSpace(body.LastName),
+		Gender:     strings.ToLower(body.Gender),
+		Urination:  body.Urination,
+		Defecation: body.Defecation,
+	}
+
+	var message string
+	if client.FirstName == "" || client.LastName == "" {
+		message = "firstName and lastName are required."
+	} else if client.Gender != "male" && client.Gender != "female" {
+		message = "gender should be either male or female."
+	} else if client.Urination <= 0 || client.Defecation <= 0 {
+		message = "urination and defecation should be positive durations in seconds."
+	}
+	if message != "" {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": message,
+		})
+		return store.Client{}, false
+	}
+	return client, true
+}
+
+// /api/v1/clients "POST"
+func (server *Server) apiClientCreate(writer http.ResponseWriter,
+	request *http.Request) {
+	client, ok := decodeApiClient(writer, request)
+	if !ok {
+		return
+	}
+
+	clientId, err := server.store.Clients.Create(client)
+	if err != nil {
+		log.Println("apiClientCreate(), db insert")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	client, err = server.store.Clients.Get(clientId)
+	if err != nil {
+		log.Println("apiClientCreate(), db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	server.recordAudit(apiActor(request), store.AuditActionCreate,
+		store.AuditTargetClient, clientId, nil, client)
+
+	writeJson(writer, http.StatusCreated, map[string]interface{}{
+		"client": client,
+	})
+}
+
+// /api/v1/clients/{id}
+func (server *Server) apiClientHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		server.apiClientGet(writer, request)
+	case http.MethodPut:
+		server.apiClientUpdate(writer, request)
+	case http.MethodDelete:
+		server.apiClientDelete(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /api/v1/clients/{id} "GET"
+func (server *Server) apiClientGet(writer http.ResponseWriter,
+	request *http.Request) {
+	clientId := pathId(request, "id")
+
+	client, err := server.store.Clients.Get(clientId)
+	if err == sql.ErrNoRows {
+		genericNotFoundReply(writer)
+		return
+	} else if err != nil {
+		log.Println("apiClientGet(), db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	writeJson(writer, http.StatusOK, map[string]interface{}{
+		"client": client,
+	})
+}
+
+// /api/v1/clients/{id} "PUT"
+// Replaces the details of the client
+func (server *Server) apiClientUpdate(writer http.ResponseWriter,
+	request *http.Request) {
+	client, ok := decodeApiClient(writer, request)
+	if !ok {
+		return
+	}
+	client.Id = pathId(request, "id")
+
+	before, err := server.store.Clients.Get(client.Id)
+	if err == sql.ErrNoRows {
+		genericNotFoundReply(writer)
+		return
+	} else if err != nil {
+		log.Println("apiClientUpdate(), db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	err = server.store.Clients.Update(client)
+	if err == sql.ErrNoRows {
+		genericNotFoundReply(writer)
+		return
+	} else if err != nil {
+		log.Println("apiClientUpdate(), db update")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	client, err = server.store.Clients.Get(client.Id)
+	if err != nil {
+		log.Println("apiClientUpdate(), db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	server.recordAudit(apiActor(request), store.AuditActionUpdate,
+		store.AuditTargetClient, client.Id, before, client)
+
+	go server.publishClientUpdate(client.Id)
+	writeJson(writer, http.StatusOK, map[string]interface{}{
+		"client": client,
+	})
+}
+
+// /api/v1/clients/{id} "DELETE"
+// Removes the client along with their records
+func (server *Server) apiClientDelete(writer http.ResponseWriter,
+	request *http.Request) {
+	clientId := pathId(request, "id")
+
+	tx, err := server.db.Begin()
+	if err != nil {
+		log.Println("apiClientDelete(), begin transaction")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	defer tx.Rollback()
+	txStore := store.New(tx)
+
+	before, err := txStore.Clients.Get(clientId)
+	if err == nil {
+		err = txStore.Clients.Delete(clientId)
+	}
+	if err == sql.ErrNoRows {
+		genericNotFoundReply(writer)
+		return
+	} else if err == store.ErrClientInSession {
+		writeJson(writer, http.StatusConflict, map[string]string{
+			"error": "Client is currently in a toilet session.",
+		})
+		return
+	} else if err != nil {
+		log.Println("apiClientDelete(), db delete")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("apiClientDelete(), commit transaction")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	server.recordAudit(apiActor(request), store.AuditActionDelete,
+		store.AuditTargetClient, clientId, before, nil)
+
+	writeJson(writer, http.StatusOK, map[string]string{
+		"message": "Client deleted.",
+	})
+}
+
+// /api/v1/clients/{id}/officers "GET"
+// Lists the TOs tracking the client
+func (server *Server) apiClientOfficersHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+
+	clientId := pathId(request, "id")
+	_, err := server.store.Clients.Get(clientId)
+	if err == sql.ErrNoRows {
+		genericNotFoundReply(writer)
+		return
+	} else if err != nil {
+		log.Println("apiClientOfficersHandler(), db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	tos, err := server.store.Track.ListTOs(clientId)
+	if err != nil {
+		log.Println("apiClientOfficersHandler(), db query tracking")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	writeJson(writer, http.StatusOK, map[string]interface{}{
+		"officers": emptyIfNil(tos),
+	})
+}
+
+// /api/v1/entries "GET"
+// Query parameters: clientId, businessType, outcome,
+// from, to (RFC 3339 or YYYY-MM-DD), limit, offset
+func (server *Server) apiEntriesHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+
+	page, ok := parsePage(writer, request)
+	if !ok {
+		return
+	}
+
+	query := request.URL.Query()
+	filter := store.ToiletEntryFilter{
+		BusinessType: strings.ToLower(query.Get("businessType")),
+		Outcome:      strings.ToLower(query.Get("outcome")),
+		Page:         page,
+	}
+
+	var err error
+	if query.Get("clientId") != "" {
+		filter.ClientId, err = strconv.Atoi(query.Get("clientId"))
+		if err != nil || filter.ClientId <= 0 {
+			writeJson(writer, http.StatusBadRequest, map[string]string{
+				"error": "Invalid clientId.",
+			})
+			return
+		}
+	}
+	filter.From, err = parseApiTime(query.Get("from"))
+	if err != nil {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Invalid from, use RFC 3339 or YYYY-MM-DD.",
+		})
+		return
+	}
+	filter.To, err = parseApiTime(query.Get("to"))
+	if err != nil {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Invalid to, use RFC 3339 or YYYY-MM-DD.",
+		})
+		return
+	}
+
+	entries, total, err := server.store.ToiletEntries.Find(filter)
+	if err != nil {
+		log.Println("apiEntriesHandler(), db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	writePage(writer, "entries", entries, total, page)
+}
+
+// /api/v1/officers "GET"
+// Query parameters: search, type, limit, offset
+func (server *Server) apiOfficersHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+
+	page, ok := parsePage(writer, request)
+	if !ok {
+		return
+	}
+
+	query := request.URL.Query()
+	tos, total, err := server.store.TOfficers.Find(store.TOFilter{
+		Search:   query.Get("search"),
+		UserType: strings.ToLower(query.Get("type")),
+		Page:     page,
+	})
+	if err != nil {
+		log.Println("apiOfficersHandler(), db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	writePage(writer, "officers", tos, total, page)
+}
+
+// /api/v1/officers/{id} "GET"
+func (server *Server) apiOfficerHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+
+	to, err := server.store.TOfficers.Get(pathId(request, "id"))
+	if err == sql.ErrNoRows {
+		genericNotFoundReply(writer)
+		return
+	} else if err != nil {
+		log.Println("apiOfficerHandler(), db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	writeJson(writer, http.StatusOK, map[string]interface{}{
+		"officer": to,
+	})
+}
+
+// /api/v1/officers/{id}/tracking "GET"
+// Lists the clients tracked by the TO, along with
+// their active session, if any
+func (server *Server) apiTrackingHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+
+	toId := pathId(request, "id")
+	_, err := server.store.TOfficers.Get(toId)
+	if err == sql.ErrNoRows {
+		genericNotFoundReply(writer)
+		return
+	} else if err != nil {
+		log.Println("apiTrackingHandler(), db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	clients, err := server.store.Track.ListClients(toId)
+	if err != nil {
+		log.Println("apiTrackingHandler(), db query tracking")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	writeJson(writer, http.StatusOK, map[string]interface{}{
+		"clients": emptyIfNil(clients),
+	})
+}
+
+// /api/v1/officers/{id}/tracking/{clientId}
+// "PUT" starts tracking the client, "DELETE" stops tracking
+func (server *Server) apiTrackingClientHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodPut &&
+		request.Method != http.MethodDelete {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+
+	toId := pathId(request, "id")
+	clientId := pathId(request, "clientId")
+
+	_, err := server.store.TOfficers.Get(toId)
+	if err == nil {
+		_, err = server.store.Clients.Get(clientId)
+	}
+	if err == sql.ErrNoRows {
+		genericNotFoundReply(writer)
+		return
+	} else if err != nil {
+		log.Println("apiTrackingClientHandler(), db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	message := "Client tracked."
+	action := store.AuditActionTrack
+	if request.Method == http.MethodPut {
+		err = server.store.Track.Add(toId, clientId)
+	} else {
+		err = server.store.Track.Remove(toId, clientId)
+		message = "Client untracked."
+		action = store.AuditActionUntrack
+	}
+	if err != nil {
+		log.Println("apiTrackingClientHandler(), db update")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	server.recordAudit(apiActor(request), action, store.AuditTargetClient,
+		clientId, nil, map[string]int{"toId": toId})
+
+	writeJson(writer, http.StatusOK, map[string]string{
+		"message": message,
+	})
+}
+
+// Gets the numeric path variable, which is
+// guaranteed to be numeric by the route
+func pathId(request *http.Request, name string) int {
+	id, _ := strconv.Atoi(mux.Vars(request)[name])
+	return id
+}
+
+// Parses the limit and offset query parameters,
+// replying with the error if invalid
+func parsePage(writer http.ResponseWriter,
+	request *http.Request) (store.Page, bool) {
+	query := request.URL.Query()
+	page := store.Page{
+		Limit: globals.API_DEFAULT_LIMIT,
+	}
+
+	var err error
+	if query.Get("limit") != "" {
+		page.Limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || page.Limit <= 0 || page.Limit > globals.API_MAX_LIMIT {
+			writeJson(writer, http.StatusBadRequest, map[string]string{
+				"error": "limit should be between 1 and " +
+					strconv.Itoa(globals.API_MAX_LIMIT) + ".",
+			})
+			return page, false
+		}
+	}
+	if query.Get("offset") != "" {
+		page.Offset, err = strconv.Atoi(query.Get("offset"))
+		if err != nil || page.Offset < 0 {
+			writeJson(writer, http.StatusBadRequest, map[string]string{
+				"error": "offset should be a non-negative number.",
+			})
+			return page, false
+		}
+	}
+	return page, true
+}
+
+// Writes a page of results, along with the
+// total number of results for the query
+func writePage[T any](writer http.ResponseWriter, name string,
+	results []T, total int, page store.Page) {
+	writeJson(writer, http.StatusOK, map[string]interface{}{
+		name:     emptyIfNil(results),
+		"total":  total,
+		"limit":  page.Limit,
+		"offset": page.Offset,
+	})
+}
+
+// Empty slices are written as [] instead of null
+func emptyIfNil[T any](results []T) []T {
+	if results == nil {
+		return []T{}
+	}
+	return results
+}
+
+// Parses a time in RFC 3339 or a date in YYYY-MM-DD,
+// taken as the start of the day in local time
+func parseApiTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return parsed, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/genekkion/PottySenseShared/store"
+)
+
+// Who made a change, and through what
+type auditActor struct {
+	id     int
+	name   string
+	source string
+}
+
+// The TO logged in to the dashboard
+func webActor(to *TO) auditActor {
+	return auditActor{id: to.Id, name: to.Username, source: store.AuditSourceWeb}
+}
+
+// The API key authenticating the request, see extWrapper
+func apiActor(request *http.Request) auditActor {
+	apiKey, _ := apiKeyFromContext(request.Context())
+	return auditActor{name: apiKey.Name, source: store.AuditSourceApi}
+}
+
+// The TO using the telegram bot, if known
+func (server *Server) botActor(toId int) auditActor {
+	actor := auditActor{id: toId, name: "telegram bot", source: store.AuditSourceBot}
+	if toId == 0 {
+		return actor
+	}
+	to, err := server.store.TOfficers.Get(toId)
+	if err != nil {
+		log.Println("botActor() - db query")
+		log.Println(err)
+		return actor
+	}
+	actor.name = to.Username
+	return actor
+}
+
+// Records the change in the audit log, along with the values
+// before and after, which are nil if there are none. Failures
+// are only logged, as the change has already been made.
+func (server *Server) recordAudit(actor auditActor, action string,
+	targetType string, targetId int, before any, after any) {
+	_, err := server.store.Audit.Record(store.AuditEntry{
+		ActorId:    actor.id,
+		Actor:      actor.name,
+		Source:     actor.source,
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   targetId,
+		Before:     store.AuditValue(before),
+		After:      store.AuditValue(after),
+	})
+	if err != nil {
+		log.Println("recordAudit() - db insert")
+		log.Println(err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/genekkion/PottySenseServer/internal/globals"
 	"github.com/genekkion/PottySenseServer/internal/utils"
+	"github.com/genekkion/PottySenseShared/store"
 	"github.com/gorilla/csrf"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -127,22 +128,7 @@ func (server *Server) htmxLoginForm(writer http.ResponseWriter,
 	username := strings.ToLower(request.FormValue("username"))
 	password := utils.SaltPassword(request.FormValue("password"))
 
-	var id int
-	var telegramChatId string
-	var userType string
-	var passwordHash string
-	err = server.db.QueryRow(
-		`SELECT id, password, 
-			telegram_chat_id, type
-		FROM TOfficers
-		WHERE username = $1
-		`, username).Scan(
-		&id,
-		&passwordHash,
-		&telegramChatId,
-		&userType,
-	)
-
+	to, err := server.store.TOfficers.GetByUsername(username)
 	if err == sql.ErrNoRows {
 		tmpl := template.Must(template.ParseFiles("./templates/htmx/loginForm.html"))
 		tmpl.Execute(writer, map[string]interface{}{
@@ -157,6 +143,18 @@ func (server *Server) htmxLoginForm(writer http.ResponseWriter,
 			"errorMessage":   "The server is experiencing issues right now, please try again later",
 		})
 		log.Println(err)
+		return
+	}
+
+	passwordHash, err := server.store.TOfficers.GetPasswordHash(to.Id)
+	if err != nil {
+		tmpl := template.Must(template.ParseFiles("./templates/htmx/loginForm.html"))
+		tmpl.Execute(writer, map[string]interface{}{
+			csrf.TemplateTag: csrf.TemplateField(request),
+			"errorMessage":   "The server is experiencing issues right now, please try again later",
+		})
+		log.Println(err)
+		return
 	}
 
 	err = bcrypt.CompareHashAndPassword(
@@ -172,14 +170,8 @@ func (server *Server) htmxLoginForm(writer http.ResponseWriter,
 		return
 	}
 
-	server.createSession(writer, request,
-		TO{
-			Id:             id,
-			Username:       username,
-			TelegramChatId: telegramChatId,
-			UserType:       userType,
-		})
-	writer.Header().Set("HX-Redirect", "/track")
+	server.createSession(writer, request, to)
+	writer.Header().Set("HX-Redirect", dashboardRoute(to.UserType))
 }
 
 // /logout
@@ -223,6 +215,25 @@ func (server *Server) authWrapper(function serverFunc) http.HandlerFunc {
 	}
 }
 
+// Wraps routes which need the permission supplied, to be
+// composed with authWrapper, i.e. authWrapper(permissionWrapper(...)).
+// Pages are redirected to the first tab the TO can see, while
+// htmx requests are forbidden.
+func (server *Server) permissionWrapper(permission string,
+	function serverFunc) serverFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		to := server.getTOFromCookie(request)
+		if store.HasPermission(to.UserType, permission) {
+			function(writer, request)
+		} else if request.Header.Get("HX-Request") == "" {
+			http.Redirect(writer, request,
+				dashboardRoute(to.UserType), http.StatusSeeOther)
+		} else {
+			genericForbiddenReply(writer)
+		}
+	}
+}
+
 // WARN: For internal use only. Only to be
 // used WITHIN a route that is auth wrapped to
 // guarantee existence of TO details being found
@@ -231,10 +242,27 @@ func (server *Server) getTOFromCookie(request *http.Request) *TO {
 	// Will not error here since auth wrapped
 	session, _ := server.redisSessionStore.Get(request, globals.COOKIE_NAME)
 
-	return &TO{
+	to := &TO{
 		Id:             session.Values[globals.COOKIE_TO_ID].(int),
-		Username:       session.Values[globals.COOKIE_TO_USER_TYPE].(string),
+		Username:       session.Values[globals.COOKIE_TO_USERNAME].(string),
 		TelegramChatId: session.Values[globals.COOKIE_TO_TELE_CHAT_ID].(string),
 		UserType:       session.Values[globals.COOKIE_TO_USER_TYPE].(string),
 	}
+
+	// The role may have changed since the login, so is always
+	// loaded. The role of the session is kept only if the
+	// database cannot be reached, while a TO no longer found
+	// has no role.
+	current, err := server.store.TOfficers.Get(to.Id)
+	if err == nil {
+		to.UserType = current.UserType
+	} else {
+		log.Println("getTOFromCookie() - db query TO")
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			to.UserType = ""
+		}
+	}
+	to.UserType = store.RoleOf(to.UserType)
+	return to
 }
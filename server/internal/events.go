@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"sync"
+)
+
+// A server-sent event. Name is the event name which
+// htmx matches against sse-swap, Data is the html to swap in.
+type serverEvent struct {
+	Name string
+	Data string
+}
+
+// Fans out server-sent events to the open streams of each TO
+type eventBroker struct {
+	mutex sync.Mutex
+	// TO id -> set of channels of the open streams
+	subscribers map[int]map[chan serverEvent]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subscribers: map[int]map[chan serverEvent]struct{}{},
+	}
+}
+
+// Registers a new stream for the TO. The channel
+// returned needs to be unsubscribed once done.
+func (broker *eventBroker) subscribe(toId int) chan serverEvent {
+	channel := make(chan serverEvent, 16)
+
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if broker.subscribers[toId] == nil {
+		broker.subscribers[toId] = map[chan serverEvent]struct{}{}
+	}
+	broker.subscribers[toId][channel] = struct{}{}
+	return channel
+}
+
+func (broker *eventBroker) unsubscribe(toId int, channel chan serverEvent) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	delete(broker.subscribers[toId], channel)
+	if len(broker.subscribers[toId]) == 0 {
+		delete(broker.subscribers, toId)
+	}
+}
+
+// Whether the TO has any open streams
+func (broker *eventBroker) hasSubscribers(toId int) bool {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return len(broker.subscribers[toId]) > 0
+}
+
+// Sends the event to all open streams of the TO. Events
+// are dropped for streams which are too far behind rather
+// than blocking the publisher.
+func (broker *eventBroker) publish(toId int, event serverEvent) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	for channel := range broker.subscribers[toId] {
+		select {
+		case channel <- event:
+		default:
+		}
+	}
+}
@@ -3,37 +3,193 @@ package internal
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"PottySenseTelebot/bot"
 
 	"github.com/genekkion/PottySenseServer/internal/globals"
+	"github.com/genekkion/PottySenseServer/internal/utils"
+	"github.com/genekkion/PottySenseShared/signing"
+	"github.com/genekkion/PottySenseShared/store"
 )
 
-// Wraps any http.HandleFunc functions which
-// are unprotected by CSRF
-func (server *Server) extWrapper(function serverFunc) http.HandlerFunc {
+// Wraps any http.HandleFunc functions which are unprotected
+// by CSRF. The request needs to be authenticated with an
+// unrevoked API key granted the scope supplied, or any valid
+// key if the scope is empty. The key is the one which signed
+// the request, see signatureWrapper, or the one supplied in
+// the X-PS-Header for the JSON API, see apiKeyHeaderWrapper.
+func (server *Server) extWrapper(scope string,
+	function serverFunc) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		log.Println(request.Header.Get(globals.SECRET_HEADER))
-		log.Println(os.Getenv("SECRET_HEADER"))
-		if request.Header.Get(globals.SECRET_HEADER) !=
-			os.Getenv("SECRET_HEADER") {
+		apiKey, ok := apiKeyFromContext(request.Context())
+		if !ok {
 			genericUnauthorizedReply(writer)
-		} else {
-			function(writer, request)
+			return
+		}
+		if scope != "" && !apiKey.HasScope(scope) {
+			genericForbiddenReply(writer)
+			return
+		}
+
+		err := server.store.ApiKeys.Touch(apiKey.Id)
+		if err != nil {
+			log.Println("extWrapper() - db update")
+			log.Println(err)
+		}
+		function(writer, request)
+	}
+}
+
+// Authenticates the request with the API key in the
+// X-PS-Header. Needs to wrap extWrapper, which then uses
+// the key. Neither the header nor the key are logged.
+func (server *Server) apiKeyHeaderWrapper(function http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		apiKey, err := server.authenticateApiKey(
+			request.Header.Get(globals.SECRET_HEADER))
+		if err != nil {
+			genericUnauthorizedReply(writer)
+			return
+		}
+		// Kept for recording the changes made with the key
+		function(writer, request.WithContext(
+			context.WithValue(request.Context(), apiKeyContextKey{}, apiKey)))
+	}
+}
+
+// Requires the request to be signed, see the signing package.
+// Requests outside of the skew window, or with a nonce which
+// has been seen before, are rejected so that captured requests
+// cannot be replayed. Needs to wrap extWrapper, which then uses
+// the key which signed the request.
+func (server *Server) signatureWrapper(function http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		signature, err := signing.Parse(request.Header)
+		if err != nil {
+			writeJson(writer, http.StatusUnauthorized, map[string]string{
+				"error": "Request signature required.",
+			})
+			return
 		}
 
+		skew := time.Since(signature.Timestamp)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > globals.SIGNATURE_MAX_SKEW*time.Second {
+			writeJson(writer, http.StatusUnauthorized, map[string]string{
+				"error": "Request timestamp outside of the allowed window.",
+			})
+			return
+		}
+
+		apiKey, err := server.store.ApiKeys.GetByPrefix(signature.KeyId)
+		if err != nil && err != sql.ErrNoRows {
+			log.Println("signatureWrapper() - db query")
+			log.Println(err)
+			genericInternalServerErrorReply(writer)
+			return
+		} else if err == sql.ErrNoRows || apiKey.IsRevoked() {
+			genericUnauthorizedReply(writer)
+			return
+		}
+
+		body, err := signing.ReadBody(request, globals.SIGNATURE_MAX_BODY)
+		if err != nil {
+			writeJson(writer, http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body.",
+			})
+			return
+		}
+
+		stringToSign := signing.StringToSign(request.Method,
+			request.URL.RequestURI(), request.Header.Get(signing.HeaderTimestamp),
+			signature.Nonce, body)
+		secret := signing.DeriveSecret(server.signingSecret, apiKey.KeyHash)
+		if !signing.Verify(secret, stringToSign, signature.Signature) {
+			genericUnauthorizedReply(writer)
+			return
+		}
+
+		// Only checked once the signature is valid, so that
+		// nonces cannot be used up by anyone else
+		isNew, err := server.redisStorage.SetNX(
+			request.Context(),
+			"nonce-"+apiKey.Prefix+"-"+signature.Nonce,
+			1,
+			2*globals.SIGNATURE_MAX_SKEW*time.Second,
+		).Result()
+		if err != nil {
+			log.Println("signatureWrapper() - set redis")
+			log.Println(err)
+			genericInternalServerErrorReply(writer)
+			return
+		} else if !isNew {
+			writeJson(writer, http.StatusUnauthorized, map[string]string{
+				"error": "Request has already been received.",
+			})
+			return
+		}
+
+		function(writer, request.WithContext(
+			context.WithValue(request.Context(), apiKeyContextKey{}, apiKey)))
 	}
 }
 
+// Key of the API key which signed the request in its context
+type apiKeyContextKey struct{}
+
+func apiKeyFromContext(ctx context.Context) (store.ApiKey, bool) {
+	apiKey, ok := ctx.Value(apiKeyContextKey{}).(store.ApiKey)
+	return apiKey, ok
+}
+
+// Looks up the API key supplied, returning
+// store.ErrInvalidApiKey if it is not valid
+func (server *Server) authenticateApiKey(key string) (store.ApiKey, error) {
+	prefix, err := store.ParseApiKeyPrefix(key)
+	if err != nil {
+		return store.ApiKey{}, err
+	}
+
+	apiKey, err := server.store.ApiKeys.GetByPrefix(prefix)
+	if err == sql.ErrNoRows {
+		return store.ApiKey{}, store.ErrInvalidApiKey
+	} else if err != nil {
+		log.Println("authenticateApiKey() - db query")
+		log.Println(err)
+		return store.ApiKey{}, err
+	}
+
+	if !apiKey.Matches(key) || apiKey.IsRevoked() {
+		return store.ApiKey{}, store.ErrInvalidApiKey
+	}
+	return apiKey, nil
+}
+
 func (server *Server) addExternalRoutes() {
 	router := server.router
-	router.HandleFunc("/ext", server.extWrapper(server.externalHealth))
-	router.HandleFunc("/ext/api", server.extWrapper(server.extApiHandler))
-	router.HandleFunc("/ext/bot", server.extWrapper(server.extBotHandler))
+	router.HandleFunc("/ext", server.signatureWrapper(
+		server.extWrapper("", server.externalHealth)))
+	router.HandleFunc("/ext/api", server.signatureWrapper(
+		server.extWrapper(store.ScopeSendNotification, server.extApiHandler)))
+	router.HandleFunc("/ext/bot", server.signatureWrapper(
+		server.extWrapper(store.ScopeStartSession, server.extBotHandler)))
+	router.HandleFunc("/ext/bot/alert", server.signatureWrapper(
+		server.extWrapper(store.ScopeStartSession, server.extBotAlertHandler)))
+	router.HandleFunc("/ext/session", server.signatureWrapper(
+		server.extWrapper(store.ScopeReportSession, server.extSessionHandler)))
+	router.HandleFunc("/ext/session-result", server.signatureWrapper(
+		server.extWrapper(store.ScopeReportSession, server.extSessionResultHandler)))
 
 }
 
@@ -62,10 +218,15 @@ func (server *Server) extBotHandler(writer http.ResponseWriter,
 }
 
 // /ext/bot "POST"
+// Starts a session for the client at the toilet supplied.
+// toiletId may be left out if there is only one toilet registered.
+// toId is the TO starting the session, if known.
 func (server *Server) extBotSessionStart(writer http.ResponseWriter,
 	request *http.Request) {
 	type BotMessage struct {
 		ClientId int `json:"clientId"`
+		ToiletId int `json:"toiletId"`
+		ToId     int `json:"toId"`
 	}
 
 	var botMessage BotMessage
@@ -78,179 +239,519 @@ func (server *Server) extBotSessionStart(writer http.ResponseWriter,
 		return
 	}
 
-	var urination int
-	var defecation int
-	server.db.QueryRow(`
-		SELECT urination, defecation
-		FROM Clients
-		WHERE id = $1
-	`, botMessage.ClientId).Scan(
-		&urination,
-		&defecation,
-	)
+	session, err := server.startBotSession(botMessage.ClientId,
+		botMessage.ToiletId, botMessage.ToId)
+	if err != nil {
+		writeBotError(writer, "extBotSessionStart(), start session", err)
+		return
+	}
+	writeJson(writer, http.StatusOK, map[string]interface{}{
+		"message":   "Bot session started.",
+		"toiletId":  session.ToiletId,
+		"sessionId": session.Id,
+	})
+}
+
+// Replies with the refusal of a bot action, or 500 for
+// other errors, which are logged after the description
+func writeBotError(writer http.ResponseWriter, description string, err error) {
+	var botErr bot.ServerError
+	if errors.As(err, &botErr) {
+		writeJson(writer, botErr.Status, map[string]string{
+			"error": botErr.Message,
+		})
+		return
+	}
+	log.Println(description)
+	log.Println(err)
+	genericInternalServerErrorReply(writer)
+}
+
+// Starts a session for the client at the toilet on behalf of
+// the TO using the bot. toiletId may be 0 if there is only one
+// toilet registered, and toId 0 if the TO is not known. Returns
+// a bot.ServerError with the reply to the TO if refused.
+func (server *Server) startBotSession(clientId int, toiletId int,
+	toId int) (ToiletSession, error) {
+	toilet, err := server.resolveToilet(toiletId)
+	if err == sql.ErrNoRows {
+		return ToiletSession{}, bot.ServerError{
+			Status: http.StatusNotFound, Message: "Toilet not found."}
+	} else if err == errToiletRequired {
+		return ToiletSession{}, bot.ServerError{Status: http.StatusBadRequest,
+			Message: "Multiple toilets registered, toiletId required."}
+	} else if err != nil {
+		return ToiletSession{}, err
+	}
+
+	client, err := server.store.Clients.Get(clientId)
+	if err == sql.ErrNoRows {
+		return ToiletSession{}, bot.ServerError{
+			Status: http.StatusNotFound, Message: "Client not found."}
+	} else if err != nil {
+		return ToiletSession{}, err
+	}
+
+	tx, err := server.db.Begin()
+	if err != nil {
+		return ToiletSession{}, err
+	}
+	defer tx.Rollback()
+
+	session, err := store.New(tx).Sessions.Start(clientId, toilet.Id, toId)
+	if err == store.ErrClientInSession {
+		return ToiletSession{}, bot.ServerError{Status: http.StatusConflict,
+			Message: "Client already has an active session."}
+	} else if err == store.ErrToiletInUse {
+		return ToiletSession{}, bot.ServerError{Status: http.StatusConflict,
+			Message: "Toilet is currently in use."}
+	} else if err != nil {
+		return ToiletSession{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return ToiletSession{}, err
+	}
 
 	body, err := json.Marshal(
 		map[string]interface{}{
-			"clientId":   botMessage.ClientId,
-			"urination":  urination,
-			"defecation": defecation,
+			"sessionId":  session.Id,
+			"clientId":   clientId,
+			"urination":  client.Urination,
+			"defecation": client.Defecation,
 			// "businessType": bot
 		},
 	)
 	if err != nil {
-		log.Println("extBotSessionStart(), make json")
+		server.store.Sessions.End(session.Id,
+			store.SessionStatusCancelled,
+			"Error starting the session.")
+		return ToiletSession{}, err
+	}
+
+	postResponse, err := server.sendToiletRequest(toilet,
+		http.MethodPost, body)
+	if err != nil {
+		log.Println("startBotSession(), post request")
 		log.Println(err)
-		genericInternalServerErrorReply(writer)
-		return
+		server.store.Sessions.End(session.Id,
+			store.SessionStatusCancelled,
+			"Toilet could not be reached.")
+		return ToiletSession{}, bot.ServerError{Status: http.StatusBadGateway,
+			Message: "Toilet could not be reached."}
+	}
+	defer postResponse.Body.Close()
+
+	log.Println(postResponse.StatusCode, postResponse.Body)
+	if postResponse.StatusCode != http.StatusOK {
+		server.store.Sessions.End(session.Id,
+			store.SessionStatusCancelled,
+			"Toilet failed to start the session.")
+		return ToiletSession{}, bot.ServerError{Status: http.StatusBadGateway,
+			Message: "Toilet failed to start the session."}
+	}
+
+	server.recordAudit(server.botActor(toId), store.AuditActionStart,
+		store.AuditTargetSession, session.Id, nil, session)
+	go server.publishClientUpdate(session.ClientId)
+	return session, nil
+}
+
+// Error returned when a toilet needs to be
+// chosen out of multiple registered toilets
+var errToiletRequired = errors.New("toiletId required")
+
+// Gets the toilet with the toiletId supplied. If toiletId
+// is 0, the only registered toilet is returned instead.
+func (server *Server) resolveToilet(toiletId int) (Toilet, error) {
+	if toiletId != 0 {
+		return server.store.Toilets.Get(toiletId)
+	}
+
+	toilets, err := server.store.Toilets.List()
+	if err != nil {
+		return Toilet{}, err
+	}
+
+	switch len(toilets) {
+	case 0:
+		return Toilet{}, sql.ErrNoRows
+	case 1:
+		return toilets[0], nil
+	default:
+		return Toilet{}, errToiletRequired
 	}
+}
 
-	response := bytes.NewBuffer(body)
+// Sends a request to the /api route of the toilet,
+// authenticated using the shared secret of the toilet
+func (server *Server) sendToiletRequest(toilet Toilet, method string,
+	body []byte) (*http.Response, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewBuffer(body)
+	}
 
-	postResponse, err := http.Post(
-		"http://"+os.Getenv("PI_ADDR")+"/api",
-		"application/json",
-		response,
+	toiletRequest, err := http.NewRequest(
+		method,
+		toilet.BaseUrl+"/api",
+		bodyReader,
 	)
 	if err != nil {
-		log.Println("extBotSessionStart(), post request")
+		return nil, err
+	}
+	toiletRequest.Header.Set("Content-Type", "application/json")
+	toiletRequest.Header.Set(globals.SECRET_HEADER, toilet.Secret)
+
+	return http.DefaultClient.Do(toiletRequest)
+}
+
+// /ext/bot "DELETE"
+// Cancels the active session of the client. The session
+// is recorded as cancelled even if the toilet cannot be
+// reached, so that the toilet can be used again.
+func (server *Server) extBotSessionCancel(writer http.ResponseWriter,
+	request *http.Request) {
+	type BotMessage struct {
+		ClientId int    `json:"clientId"`
+		ToId     int    `json:"toId"`
+		Reason   string `json:"reason"`
+	}
+
+	var botMessage BotMessage
+
+	err := json.NewDecoder(request.Body).Decode(&botMessage)
+	if err != nil {
+		log.Println("extBotSessionCancel(), decode json")
 		log.Println(err)
 		genericInternalServerErrorReply(writer)
 		return
 	}
 
-	log.Println(postResponse.StatusCode, postResponse.Body)
+	session, err := server.cancelBotSession(botMessage.ClientId,
+		botMessage.ToId, botMessage.Reason)
+	if err != nil {
+		writeBotError(writer, "extBotSessionCancel(), cancel session", err)
+		return
+	}
 	writeJson(writer, http.StatusOK, map[string]interface{}{
-		"message": "Bot session started.",
+		"message":   "Bot session cancelled.",
+		"sessionId": session.Id,
 	})
 }
 
-func (server *Server) getToilet(clientId int) string {
-	result, err := server.redisStorage.Get(
-		context.Background(),
-		"client-"+fmt.Sprint(clientId),
-	).Int()
+// Cancels the active session of the client on behalf of the
+// TO using the bot. Returns a bot.ServerError with the reply
+// to the TO if there is no session, or the toilet could not
+// be reached, in which case the session is still cancelled.
+func (server *Server) cancelBotSession(clientId int, toId int,
+	reason string) (ToiletSession, error) {
+	session, err := server.store.Sessions.GetActiveByClient(clientId)
+	if err == sql.ErrNoRows {
+		return ToiletSession{}, bot.ServerError{Status: http.StatusNotFound,
+			Message: "No session found for this client."}
+	} else if err != nil {
+		return ToiletSession{}, err
+	}
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		reason = "Cancelled by TO."
+	}
+
+	toiletReached, err := server.cancelToiletSession(session, reason)
 	if err != nil {
-		log.Println("getToilet()")
+		return ToiletSession{}, err
+	}
+	server.recordAudit(server.botActor(toId), store.AuditActionCancel,
+		store.AuditTargetSession, session.Id, nil,
+		map[string]string{"reason": reason})
+
+	if !toiletReached {
+		return ToiletSession{}, bot.ServerError{Status: http.StatusBadGateway,
+			Message: "Session cancelled, but the toilet could not be reached."}
+	}
+	return session, nil
+}
+
+// Stops the session at the toilet and records it as cancelled,
+// even if the toilet cannot be reached, returning whether the
+// toilet was reached
+func (server *Server) cancelToiletSession(session ToiletSession,
+	reason string) (bool, error) {
+	toiletReached := false
+	toilet, err := server.store.Toilets.Get(session.ToiletId)
+	if err != nil {
+		log.Println("cancelToiletSession(), get toilet")
 		log.Println(err)
-		return ""
+	} else {
+		response, err := server.sendToiletRequest(toilet,
+			http.MethodDelete, nil)
+		if err != nil {
+			log.Println("cancelToiletSession(), delete request")
+			log.Println(err)
+		} else {
+			log.Println(response.StatusCode, response.Body)
+			response.Body.Close()
+			toiletReached = response.StatusCode == http.StatusOK
+		}
 	}
 
-	return globals.TOILETS_URL[result]
+	err = server.store.Sessions.End(session.Id,
+		store.SessionStatusCancelled, reason)
+	if err != nil && err != sql.ErrNoRows {
+		return toiletReached, err
+	}
+	go server.publishClientUpdate(session.ClientId)
+	return toiletReached, nil
 }
 
-// /ext/bot "DELETE"
-func (server *Server) extBotSessionCancel(writer http.ResponseWriter,
+// /ext/bot/alert
+func (server *Server) extBotAlertHandler(writer http.ResponseWriter,
 	request *http.Request) {
-	// type BotMessage struct {
-	// 	ClientId int `json:"clientId"`
-	// }
-
-	// var botMessage BotMessage
-
-	// err := json.NewDecoder(request.Body).Decode(&botMessage)
-	// if err != nil {
-	// 	log.Println("extBotSessionCancel(), decode json")
-	// 	log.Println(err)
-	// 	genericInternalServerErrorReply(writer)
-	// 	return
-	// }
-	// log.Println("botMessage", botMessage)
-
-	// serverUrl := server.getToilet(botMessage.ClientId)
-	// if serverUrl == "" {
-	// 	log.Println("Error getting toilet url")
-	// 	genericInternalServerErrorReply(writer)
-	// 	return
-	// }
-
-	deleteRequest, err := http.NewRequest(
-		http.MethodDelete,
-		"http://"+os.Getenv("PI_ADDR")+"/api",
-		nil,
-	)
+
+	switch request.Method {
+	case http.MethodPut:
+		server.extBotAlertRespond(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /ext/bot/alert "PUT"
+// Records the response of the TO to the alert, which also
+// acknowledges the alert. For "omw", the TO is recorded as
+// on the way to the client during the active session. For
+// "resolved", the active session of the client is cancelled.
+// The alert may have been acknowledged by another TO.
+func (server *Server) extBotAlertRespond(writer http.ResponseWriter,
+	request *http.Request) {
+	type AlertResponse struct {
+		AlertId int    `json:"alertId"`
+		ToId    int    `json:"toId"`
+		Action  string `json:"action"`
+	}
+
+	var alertResponse AlertResponse
+
+	err := json.NewDecoder(request.Body).Decode(&alertResponse)
 	if err != nil {
-		log.Println("extBotSessionCancel(), create request")
+		log.Println("extBotAlertRespond(), decode json")
 		log.Println(err)
-		genericInternalServerErrorReply(writer)
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Invalid json body.",
+		})
 		return
 	}
 
-	response, err := http.DefaultClient.Do(deleteRequest)
+	message, sessionId, err := server.respondToAlert(alertResponse.AlertId,
+		alertResponse.ToId, alertResponse.Action)
 	if err != nil {
-		log.Println("extBotSessionCancel(), delete request")
-		log.Println(err)
-		genericInternalServerErrorReply(writer)
+		writeBotError(writer, "extBotAlertRespond(), respond", err)
 		return
 	}
-
-	log.Println(response.StatusCode, response.Body)
 	writeJson(writer, http.StatusOK, map[string]interface{}{
-		"message": "Bot session cancelled.",
+		"message":   message,
+		"sessionId": sessionId,
 	})
 }
 
-// /ext/api
-func (server *Server) extApiHandler(writer http.ResponseWriter,
+// Records the response of the TO to the alert using the bot,
+// returning the message sent to the dashboard and the id of
+// the active session of the client, 0 if none. Returns a
+// bot.ServerError with the reply to the TO if refused.
+func (server *Server) respondToAlert(alertId int, toId int,
+	action string) (string, int, error) {
+	if action != store.AlertActionOnMyWay &&
+		action != store.AlertActionResolved {
+		return "", 0, bot.ServerError{Status: http.StatusBadRequest,
+			Message: "Invalid action."}
+	}
+
+	to, err := server.store.TOfficers.Get(toId)
+	if err == sql.ErrNoRows {
+		return "", 0, bot.ServerError{Status: http.StatusNotFound,
+			Message: "TO not found."}
+	} else if err != nil {
+		return "", 0, err
+	}
+
+	alert, err := server.store.Alerts.Get(alertId)
+	if err == sql.ErrNoRows {
+		return "", 0, bot.ServerError{Status: http.StatusNotFound,
+			Message: "Alert not found."}
+	} else if err != nil {
+		return "", 0, err
+	}
+
+	err = server.store.Alerts.Acknowledge(alert.Id, to.Id, time.Now().UTC())
+	if err != nil && err != store.ErrAlertAcknowledged {
+		return "", 0, err
+	}
+
+	sessionId := 0
+	session, err := server.store.Sessions.GetActiveByClient(alert.ClientId)
+	if err == nil {
+		sessionId = session.Id
+	} else if err != sql.ErrNoRows {
+		return "", 0, err
+	}
+
+	var message string
+	if action == store.AlertActionOnMyWay {
+		message = to.Username + " is on the way."
+		if sessionId != 0 {
+			_, err = server.store.Sessions.SetResponder(sessionId, to.Id)
+			if err != nil && err != sql.ErrNoRows {
+				return "", 0, err
+			}
+			go server.publishClientUpdate(alert.ClientId)
+		}
+	} else {
+		message = "Resolved by " + to.Username + "."
+		if sessionId != 0 {
+			_, err = server.cancelToiletSession(session, "Alert resolved by "+to.Username+".")
+			if err != nil {
+				return "", 0, err
+			}
+		}
+	}
+	server.recordAudit(server.botActor(to.Id), store.AuditActionRespond,
+		store.AuditTargetAlert, alert.Id, nil,
+		map[string]string{"response": action})
+	go server.publishClientAlert(alert.ClientId, "notification", message)
+	return message, sessionId, nil
+}
+
+// /ext/session
+func (server *Server) extSessionHandler(writer http.ResponseWriter,
 	request *http.Request) {
 
 	switch request.Method {
-	case http.MethodPost:
-		server.extSendTele(writer, request)
+	case http.MethodGet:
+		server.extSessionGet(writer, request)
+	case http.MethodPut:
+		server.extSessionPhase(writer, request)
 	default:
 		genericMethodNotAllowedReply(writer)
 	}
 }
 
-// Gets client from the db based on clientId
-func (server *Server) getClient(clientId int) Client {
-	client := Client{
-		Id: clientId,
-	}
-	err := server.db.QueryRow(
-		`SELECT first_name, last_name,
-			gender, urination,
-			defecation, last_record
-		FROM Clients
-		WHERE id = $1
-		`, clientId).Scan(
-		&client.FirstName, &client.LastName,
-		&client.Gender, &client.Urination,
-		&client.Defecation, &client.LastRecord,
-	)
+// /ext/session "GET"
+// Lists the active sessions, optionally filtered by
+// the clientId or toiletId query parameters
+func (server *Server) extSessionGet(writer http.ResponseWriter,
+	request *http.Request) {
+	clientId, _ := strconv.Atoi(request.URL.Query().Get("clientId"))
+	toiletId, _ := strconv.Atoi(request.URL.Query().Get("toiletId"))
 
+	sessions, err := server.store.Sessions.ListActive()
 	if err != nil {
+		log.Println("extSessionGet(), get sessions")
 		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
 	}
-	return client
+
+	filtered := []ToiletSession{}
+	for _, session := range sessions {
+		if (clientId == 0 || session.ClientId == clientId) &&
+			(toiletId == 0 || session.ToiletId == toiletId) {
+			filtered = append(filtered, session)
+		}
+	}
+
+	writeJson(writer, http.StatusOK, map[string]interface{}{
+		"sessions": filtered,
+	})
 }
 
-// Gets the chatIds for TOs Tracking for a particular client.
-// TOs must have their telegram registered with the bot beforehand
-func (server *Server) getAllTOTracking(clientId int) []string {
-	rows, err := server.db.Query(
-		`SELECT Tofficers.telegram_chat_id
-		FROM Tofficers INNER JOIN Track
-		On Tofficers.id = Track.to_id
-		WHERE Track.client_id = $1
-		`, clientId)
+// /ext/session "PUT"
+// Updates the phase of a session as reported by the toilet.
+// The session is identified by sessionId, falling back to
+// the active session of clientId.
+func (server *Server) extSessionPhase(writer http.ResponseWriter,
+	request *http.Request) {
+	type PhaseMessage struct {
+		SessionId int `json:"sessionId"`
+		ClientId  int `json:"clientId"`
+		Phase     int `json:"phase"`
+	}
+
+	var phaseMessage PhaseMessage
+
+	err := json.NewDecoder(request.Body).Decode(&phaseMessage)
 	if err != nil {
+		log.Println("extSessionPhase(), decode json")
 		log.Println(err)
-		return nil
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Invalid json body.",
+		})
+		return
 	}
-	var TOChatIDs []string
 
-	for rows.Next() {
-		var chatId string
-		err := rows.Scan(&chatId)
-		if err != nil {
+	sessionId := phaseMessage.SessionId
+	if sessionId == 0 {
+		session, err := server.store.Sessions.GetActiveByClient(phaseMessage.ClientId)
+		if err == sql.ErrNoRows {
+			writeJson(writer, http.StatusNotFound, map[string]string{
+				"error": "No session found for this client.",
+			})
+			return
+		} else if err != nil {
+			log.Println("extSessionPhase(), get session")
 			log.Println(err)
-			continue
-		} else if chatId == "" {
-			continue
+			genericInternalServerErrorReply(writer)
+			return
 		}
-		TOChatIDs = append(TOChatIDs, chatId)
+		sessionId = session.Id
+	}
+
+	session, err := server.store.Sessions.SetPhase(sessionId, phaseMessage.Phase)
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusNotFound, map[string]string{
+			"error": "No active session found.",
+		})
+		return
+	} else if err == store.ErrSessionPhase {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Invalid phase for this session.",
+		})
+		return
+	} else if err != nil {
+		log.Println("extSessionPhase(), set phase")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
 	}
-	return TOChatIDs
+
+	go server.publishClientUpdate(session.ClientId)
+	writeJson(writer, http.StatusOK, map[string]interface{}{
+		"message": "Session phase updated.",
+		"session": session,
+	})
+}
+
+// /ext/api
+func (server *Server) extApiHandler(writer http.ResponseWriter,
+	request *http.Request) {
+
+	switch request.Method {
+	case http.MethodPost:
+		server.extSendTele(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// Gets client from the db based on clientId
+func (server *Server) getClient(clientId int) Client {
+	client, err := server.store.Clients.Get(clientId)
+	if err != nil {
+		log.Println(err)
+		client.Id = clientId
+	}
+	return newClient(client)
 }
 
 // /ext/api/client "POST"
@@ -274,47 +775,282 @@ func (server *Server) extSendTele(writer http.ResponseWriter,
 	}
 	log.Println(piMessage)
 
-	chatIDs := server.getAllTOTracking(piMessage.ClientId)
-	if len(chatIDs) == 0 {
-		writeJson(writer, http.StatusInternalServerError, map[string]string{
+	messageType := strings.ToLower(piMessage.MessageType)
+	go server.publishClientAlert(piMessage.ClientId,
+		messageType, piMessage.Message)
+
+	var notified, errCount int
+	if messageType == "alert" {
+		// Alerts are escalated until they are acknowledged,
+		// even if no TOs are tracking the client
+		notified, errCount, err = server.createAlert(piMessage.ClientId,
+			piMessage.Message)
+		if err != nil {
+			log.Println("extSendTele() - create alert")
+			log.Println(err)
+			genericInternalServerErrorReply(writer)
+			return
+		}
+	} else {
+		title := ""
+		isSilent := true
+		switch messageType {
+		case "notification":
+			title = "Notification!"
+			isSilent = false
+		case "complete":
+			title = "Complete!"
+			isSilent = false
+		}
+		notified, errCount = server.notifyTracking(piMessage.ClientId, Notification{
+			Type:     messageType,
+			Title:    title,
+			Message:  piMessage.Message,
+			IsSilent: isSilent,
+		})
+	}
+	if notified == 0 && errCount == 0 {
+		// Alerts are still escalated, so the request succeeded
+		message := "No messages queued."
+		if messageType == "alert" {
+			message = "Alert recorded, it will be escalated until acknowledged."
+		}
+		writeJson(writer, http.StatusAccepted, map[string]string{
+			"message": message,
 			"warning": "No TOs currently tracking this client.",
 		})
 		return
 	}
 
+	// Messages are sent by the outbound workers, and
+	// retried until they are delivered
 	var message string
-	messageType := strings.ToLower(piMessage.MessageType)
-	isSilent := true
-	switch messageType {
-	case "alert":
-		message = "⚠️ <b>ALERT!</b> ⚠️\n"
-		isSilent = false
-	case "notification":
-		message = "🔔 <b>Notification!</b> 🔔\n"
-		isSilent = false
-	case "complete":
-		message = "✅ <b>Complete!</b> ✅\n"
-		isSilent = false
+	if errCount == 0 {
+		message = "All messages successfuly queued."
+	} else {
+		message = "Some messages successfuly queued."
+	}
+	writeJson(writer, http.StatusOK, map[string]string{
+		"message": message,
+	})
+}
+
+// /ext/session-result
+func (server *Server) extSessionResultHandler(writer http.ResponseWriter,
+	request *http.Request) {
+
+	switch request.Method {
+	case http.MethodPost:
+		server.extSessionResultSave(writer, request)
 	default:
-		message = ""
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /ext/session-result "POST"
+// Records a completed toilet session into ToiletEntries and
+// updates the last record of the client in a single transaction.
+// Timestamps are in unix seconds, with non-positive values
+// treated as not having occurred.
+func (server *Server) extSessionResultSave(writer http.ResponseWriter,
+	request *http.Request) {
+	type SessionResult struct {
+		SessionId    int     `json:"sessionId"`
+		ClientId     int     `json:"clientId"`
+		BusinessType string  `json:"businessType"`
+		Outcome      string  `json:"outcome"`
+		StartTime    float64 `json:"startTime"`
+		EnterTime    float64 `json:"enterTime"`
+		FinishTime   float64 `json:"finishTime"`
+		ExitTime     float64 `json:"exitTime"`
 	}
 
-	message += piMessage.Message
+	var result SessionResult
 
-	errCount := 0
-	for _, chatId := range chatIDs {
-		err := server.sendTeleString(chatId, message, isSilent)
-		if err != nil {
+	err := json.NewDecoder(request.Body).Decode(&result)
+	if err != nil {
+		log.Println("extSessionResultSave(), decode json")
+		log.Println(err)
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Invalid json body.",
+		})
+		return
+	}
+
+	result.BusinessType = strings.ToLower(result.BusinessType)
+	if result.BusinessType != "urination" &&
+		result.BusinessType != "defecation" {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "businessType should be either urination or defecation.",
+		})
+		return
+	}
+
+	result.Outcome = strings.ToLower(result.Outcome)
+	if result.Outcome == "" {
+		result.Outcome = "complete"
+	} else if result.Outcome != "complete" &&
+		result.Outcome != "cancelled" &&
+		result.Outcome != "timeout" {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "outcome should be either complete, cancelled or timeout.",
+		})
+		return
+	}
+
+	startTime := utils.UnixSecondsToNullTime(result.StartTime)
+	enterTime := utils.UnixSecondsToNullTime(result.EnterTime)
+	finishTime := utils.UnixSecondsToNullTime(result.FinishTime)
+	exitTime := utils.UnixSecondsToNullTime(result.ExitTime)
+	if !startTime.Valid {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Missing startTime.",
+		})
+		return
+	}
+
+	// Duration of the business itself, from entering
+	// the toilet until the business is finished
+	duration := 0
+	if enterTime.Valid && finishTime.Valid &&
+		finishTime.Time.After(enterTime.Time) {
+		duration = int(finishTime.Time.Sub(enterTime.Time).Seconds())
+	}
+
+	// The last record is taken as when the client
+	// last left the toilet, falling back to the
+	// latest known timestamp
+	lastRecord := time.Now().UTC()
+	if exitTime.Valid {
+		lastRecord = exitTime.Time
+	} else if finishTime.Valid {
+		lastRecord = finishTime.Time
+	}
+
+	tx, err := server.db.Begin()
+	if err != nil {
+		log.Println("extSessionResultSave(), begin transaction")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	defer tx.Rollback()
+
+	txStore := store.New(tx)
+
+	err = txStore.Clients.SetLastRecord(result.ClientId, lastRecord)
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusNotFound, map[string]string{
+			"error": "Client not found.",
+		})
+		return
+	} else if err != nil {
+		log.Println("extSessionResultSave(), db update")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	// Links the entry to the session of the client, if any
+	var sessionId sql.NullInt32
+	if result.SessionId != 0 {
+		sessionClientId, err := txStore.Sessions.GetClientId(result.SessionId)
+		if err == sql.ErrNoRows {
+			writeJson(writer, http.StatusNotFound, map[string]string{
+				"error": "Session not found.",
+			})
+			return
+		} else if err != nil {
+			log.Println("extSessionResultSave(), get session")
 			log.Println(err)
-			errCount++
+			genericInternalServerErrorReply(writer)
+			return
 		}
-	}
-	if errCount == 0 {
-		message = "All messages successfuly sent."
+		if sessionClientId != result.ClientId {
+			writeJson(writer, http.StatusBadRequest, map[string]string{
+				"error": "Session is not of this client.",
+			})
+			return
+		}
+		sessionId = sql.NullInt32{Int32: int32(result.SessionId), Valid: true}
 	} else {
-		message = "Some messages successfuly sent."
+		session, err := txStore.Sessions.GetActiveByClient(result.ClientId)
+		if err == nil {
+			sessionId = sql.NullInt32{Int32: int32(session.Id), Valid: true}
+		} else if err != sql.ErrNoRows {
+			log.Println("extSessionResultSave(), get session")
+			log.Println(err)
+			genericInternalServerErrorReply(writer)
+			return
+		}
 	}
-	writeJson(writer, http.StatusOK, map[string]string{
-		"message": message,
+
+	if sessionId.Valid {
+		status := store.SessionStatusCompleted
+		cancelReason := ""
+		if result.Outcome == "cancelled" {
+			status = store.SessionStatusCancelled
+			cancelReason = "Cancelled at the toilet."
+		}
+		err = txStore.Sessions.End(int(sessionId.Int32),
+			status, cancelReason)
+		if err != nil && err != sql.ErrNoRows {
+			log.Println("extSessionResultSave(), end session")
+			log.Println(err)
+			genericInternalServerErrorReply(writer)
+			return
+		}
+	}
+
+	entry := store.ToiletEntry{
+		ClientId:     result.ClientId,
+		BusinessType: result.BusinessType,
+		Duration:     duration,
+		Outcome:      result.Outcome,
+		StartTime:    &startTime.Time,
+	}
+	if sessionId.Valid {
+		id := int(sessionId.Int32)
+		entry.SessionId = &id
+	}
+	if enterTime.Valid {
+		entry.EnterTime = &enterTime.Time
+	}
+	if finishTime.Valid {
+		entry.FinishTime = &finishTime.Time
+	}
+	if exitTime.Valid {
+		entry.ExitTime = &exitTime.Time
+	}
+
+	entryId, err := txStore.ToiletEntries.Create(entry)
+	if err != nil {
+		log.Println("extSessionResultSave(), db insert")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("extSessionResultSave(), commit transaction")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	server.recordAudit(apiActor(request), store.AuditActionCreate,
+		store.AuditTargetEntry, entryId, nil, entry)
+	if sessionId.Valid {
+		server.recordAudit(apiActor(request), store.AuditActionFinish,
+			store.AuditTargetSession, int(sessionId.Int32), nil,
+			map[string]int{"entryId": entryId})
+	}
+	go server.publishClientUpdate(result.ClientId)
+	go server.autoApplyThresholds(result.ClientId)
+
+	writeJson(writer, http.StatusCreated, map[string]interface{}{
+		"message": "Session result recorded.",
+		"entryId": entryId,
 	})
 }
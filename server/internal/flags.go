@@ -6,9 +6,11 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/genekkion/PottySenseServer/internal/globals"
 	"github.com/genekkion/PottySenseServer/internal/utils"
+	"github.com/genekkion/PottySenseShared/store"
 	"github.com/xuri/excelize/v2"
 )
 
@@ -17,13 +19,25 @@ func ParseFlags(db *sql.DB) {
 	globals.FLAG_VERBOSE = *flag.Bool("v", false, "Enables verbose mode for debugging")
 
 	adminFlag := flag.String("a", "", "Creates a new admin user with the username provided. Must be used with the -p flag.")
-	userFlag := flag.String("u", "", "Creates a new user with the username provided. Must be used with the -p flag.")
+	userFlag := flag.String("u", "", "Creates a new officer with the username provided. Must be used with the -p flag.")
 	passwordFlag := flag.String("p", "", "Password for user creation. Must be used with the -a or -u flag.")
 
 	fileFlag := flag.String("c", "", "Parses the .xlsx file supplied for client entries and saves to database.")
 
+	reportFlag := flag.String("r", "", "Writes the toileting report to the .xlsx file supplied. Can be used with the -from, -to and -clients flags.")
+	reportFromFlag := flag.String("from", "", "First day of the report, YYYY-MM-DD. Defaults to 30 days before the last day.")
+	reportToFlag := flag.String("to", "", "Last day of the report, YYYY-MM-DD. Defaults to today.")
+	reportClientsFlag := flag.String("clients", "", "Comma separated ids of the clients in the report. Defaults to all clients.")
+
+	botFlag := flag.String("bot", "", "Hosts the telegram bot in the server, receiving updates by either \"polling\" or \"webhook\". The bot is not hosted by default.")
+
 	flag.Parse()
 
+	if *botFlag != "" && *botFlag != "polling" && *botFlag != "webhook" {
+		log.Fatalln("Unknown bot mode \"" + *botFlag + "\", use either \"polling\" or \"webhook\". Exiting.")
+	}
+	globals.FLAG_BOT = *botFlag
+
 	if *passwordFlag != "" {
 		if *adminFlag != "" && *userFlag != "" {
 			log.Println("Only one user can be created at a time using the -a and -u flags. Skipping operation.")
@@ -31,7 +45,7 @@ func ParseFlags(db *sql.DB) {
 			err := utils.CreateUser(
 				db, "", "",
 				*adminFlag, *passwordFlag,
-				"admin")
+				store.RoleAdmin)
 			if err != nil {
 				log.Fatalln(err)
 			}
@@ -40,7 +54,7 @@ func ParseFlags(db *sql.DB) {
 				db, "", "",
 				*userFlag,
 				*passwordFlag,
-				"user")
+				store.RoleOfficer)
 			if err != nil {
 				log.Fatalln(err)
 			}
@@ -55,6 +69,11 @@ func ParseFlags(db *sql.DB) {
 		globals.RUN = false
 	}
 
+	if *reportFlag != "" {
+		ExportReport(*reportFlag, *reportFromFlag, *reportToFlag, *reportClientsFlag, db)
+		globals.RUN = false
+	}
+
 }
 
 func ParseFile(filePath string, db *sql.DB) {
@@ -71,11 +90,15 @@ func ParseFile(filePath string, db *sql.DB) {
 	file.Close()
 
 	log.Println("Beginning transaction.")
-	_, err = db.Exec("BEGIN TRANSACTION")
+	tx, err := db.Begin()
 	if err != nil {
 		log.Println("Error beginning transaction.")
 		log.Println(err)
+		return
 	}
+	defer tx.Rollback()
+	txStore := store.New(tx)
+
 	for i, row := range rows[1:] {
 		for j, colCell := range row {
 			if colCell == "" {
@@ -84,7 +107,7 @@ func ParseFile(filePath string, db *sql.DB) {
 			}
 		}
 
-		client := Client{
+		client := store.Client{
 			FirstName: row[0],
 			LastName:  row[1],
 			Gender:    strings.ToLower(row[2]),
@@ -107,19 +130,16 @@ func ParseFile(filePath string, db *sql.DB) {
 			return
 		}
 
-		_, err = db.Exec(
-			`INSERT INTO Clients
-            (first_name, last_name,
-            gender, urination, defecation)
-            VALUES ($1, $2, $3, $4, $5)`,
-			client.FirstName, client.LastName,
-			client.Gender, uri, defec)
+		client.Urination = uri
+		client.Defecation = defec
+
+		_, err = txStore.Clients.Create(client)
 		if err != nil {
 			log.Printf("Error saving Row %d, aborting.\n", i+1)
 			return
 		}
 	}
-	_, err = db.Exec("COMMIT TRANSACTION")
+	err = tx.Commit()
 	if err != nil {
 		log.Printf("Error committing transaction.")
 		return
@@ -128,3 +148,46 @@ func ParseFile(filePath string, db *sql.DB) {
 	db.Close()
 	log.Println("Transaction completed, exiting.")
 }
+
+// Writes the toileting report of the clients, comma separated
+// ids or all clients if empty, over the dates supplied
+func ExportReport(filePath string, fromValue string, toValue string,
+	clientsValue string, db *sql.DB) {
+	from, to, err := parseReportPeriod(fromValue, toValue, time.Now())
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var clientIds []int
+	for _, value := range strings.Split(clientsValue, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		clientId, err := strconv.Atoi(value)
+		if err != nil {
+			log.Fatalln("Invalid client id \"" + value + "\". Exiting.")
+		}
+		clientIds = append(clientIds, clientId)
+	}
+
+	dbStore := store.New(db)
+	clients, err := getReportClients(dbStore, clientIds)
+	if err == sql.ErrNoRows {
+		log.Fatalln("Client not found. Exiting.")
+	} else if err != nil {
+		log.Fatalln(err)
+	}
+
+	file, err := newToiletingReport(dbStore, clients, from, to)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer file.Close()
+
+	err = file.SaveAs(filePath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("Report of %d clients written to %s.\n", len(clients), filePath)
+}
@@ -20,4 +20,63 @@ const (
 
 	// Secret header name
 	SECRET_HEADER = "X-PS-Header"
+
+	// Signed requests older or newer than this are rejected,
+	// nonces are kept in redis for twice as long
+	SIGNATURE_MAX_SKEW = 300 // in seconds
+	// Largest body of a signed request
+	SIGNATURE_MAX_BODY = 1 << 20 // in bytes
+
+	// Suggested thresholds of a client are the percentile
+	// of the latest completed durations, plus a margin
+	THRESHOLD_SAMPLE_SIZE = 30 // latest entries used
+	THRESHOLD_MIN_SAMPLES = 5  // fewer entries give no suggestion
+	THRESHOLD_PERCENTILE  = 90
+	THRESHOLD_MARGIN      = 20   // in percent
+	THRESHOLD_STEP        = 10   // rounded up to, in seconds
+	THRESHOLD_MIN         = 60   // in seconds
+	THRESHOLD_MAX         = 1800 // in seconds
+
+	// Toileting reminders of the clients are checked
+	// every tick, and repeated until the client is
+	// no longer due
+	REMINDER_TICK          = 60 // in seconds
+	REMINDER_REPEAT        = 30 // in minutes
+	REMINDER_OVERDUE_AFTER = 15 // in minutes
+	// Clients who used the toilet shortly before a
+	// scheduled time are not due at that time
+	REMINDER_SCHEDULE_LEAD = 30 // in minutes
+
+	// Unacknowledged alerts are checked every tick, and
+	// escalated after the delay, which can be overridden
+	// with ALERT_ESCALATION_MINUTES
+	ALERT_TICK                       = 30 // in seconds
+	ALERT_DEFAULT_ESCALATION_MINUTES = 5
+
+	// Shifts which are overdue and tracking which has
+	// expired are ended every tick. New shifts end
+	// after the default length unless changed.
+	SHIFT_TICK           = 60 // in seconds
+	SHIFT_DEFAULT_LENGTH = 8  // in hours
+
+	// Notifications are queued and sent by the workers,
+	// which check the queue every poll, or as soon as a
+	// notification is queued. Failed sends are retried
+	// after the backoff, doubled after each attempt, until
+	// the last attempt. Sent notifications are kept for
+	// a while before being removed.
+	OUTBOUND_WORKERS      = 4
+	OUTBOUND_POLL         = 5   // in seconds
+	OUTBOUND_BACKOFF_BASE = 5   // in seconds
+	OUTBOUND_BACKOFF_MAX  = 900 // in seconds
+	OUTBOUND_MAX_ATTEMPTS = 8
+	OUTBOUND_KEEP_SENT    = 7 // in days
+
+	// Toileting reports cover the last number
+	// of days unless the dates are chosen
+	REPORT_DEFAULT_DAYS = 30
+
+	// Pagination of the JSON API
+	API_DEFAULT_LIMIT = 50
+	API_MAX_LIMIT     = 200
 )
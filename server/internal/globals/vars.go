@@ -2,17 +2,24 @@ package globals
 
 var (
 	FLAG_VERBOSE bool
-	RUN          bool
+	// How the telegram bot hosted by the server receives
+	// updates, empty if the bot runs on its own
+	FLAG_BOT string
+	RUN      bool
 
 	// All routes in UNPROTECTED_ROUTES will NOT
 	// be CSRF protected
 	UNPROTECTED_ROUTES = []string{
 		"/ext/api",
 		"/ext/bot",
+		"/ext/bot/alert",
+		"/ext/session",
+		"/ext/session-result",
 	}
 
-	// WARN: Harcoded for single toilet with id of 1
-	TOILETS_URL = map[int]string{
-	
+	// All routes starting with any of these prefixes
+	// will NOT be CSRF protected either
+	UNPROTECTED_ROUTE_PREFIXES = []string{
+		"/api/v1/",
 	}
 )
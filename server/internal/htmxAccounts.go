@@ -1,12 +1,15 @@
 package internal
 
 import (
+	"database/sql"
 	"html/template"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/genekkion/PottySenseServer/internal/utils"
+	"github.com/genekkion/PottySenseShared/store"
 	"github.com/gorilla/csrf"
 )
 
@@ -27,18 +30,10 @@ func (server *Server) htmxAccountsHandler(writer http.ResponseWriter,
 // /htmx/accounts "GET"
 func (server *Server) htmxAccountsPanel(writer http.ResponseWriter,
 	request *http.Request) {
-	to := server.getTOFromCookie(request)
-
-	if to.UserType != "admin" {
-		genericForbiddenReply(writer)
-		return
-	}
-
 	tmpl := template.Must(template.ParseFiles("./templates/htmx/accounts.html"))
 	tmpl.Execute(writer, map[string]interface{}{
 		csrf.TemplateTag: csrf.TemplateField(request),
 		"csrfToken":      csrf.Token(request),
-		"to":             server.getTOFromCookie(request),
 	})
 }
 
@@ -46,11 +41,6 @@ func (server *Server) htmxAccountsPanel(writer http.ResponseWriter,
 func (server *Server) htmxAccountsSearch(writer http.ResponseWriter,
 	request *http.Request) {
 	to := server.getTOFromCookie(request)
-
-	if to.UserType != "admin" {
-		genericForbiddenReply(writer)
-		return
-	}
 	err := request.ParseForm()
 	if err != nil {
 		log.Println("htmxAccountsSearch() - parse form")
@@ -59,38 +49,14 @@ func (server *Server) htmxAccountsSearch(writer http.ResponseWriter,
 		return
 	}
 
-	// Add wildcard for autocomplete
-	searchQuery := request.FormValue("search") + "%"
-	rows, err := server.db.Query(
-		`SELECT id, first_name,
-			last_name, username,
-			type
-        FROM TOfficers
-		WHERE id != $1
-        	AND (first_name LIKE $2 COLLATE NOCASE
-        		OR last_name LIKE $2 COLLATE NOCASE
-        		OR username LIKE $2 COLLATE NOCASE)
-		`, to.Id, searchQuery)
-
+	accounts, err := server.store.TOfficers.Search(
+		request.FormValue("search"), to.Id)
 	if err != nil {
 		log.Println("htmxAccountsSearch() - db query")
 		log.Println(err)
 		genericInternalServerErrorReply(writer)
 		return
 	}
-
-	var accounts []TO
-	for rows.Next() {
-		var to TO
-		rows.Scan(
-			&to.Id,
-			&to.FirstName,
-			&to.LastName,
-			&to.Username,
-			&to.UserType,
-		)
-		accounts = append(accounts, to)
-	}
 	tmpl := template.Must(template.ParseFiles("./templates/htmx/accountEntry.html"))
 	tmpl.Execute(writer, map[string]interface{}{
 		csrf.TemplateTag: csrf.TemplateField(request),
@@ -115,13 +81,6 @@ func (server *Server) htmxAccountsEditHandler(writer http.ResponseWriter,
 // /htmx/accounts/edit "POST"
 func (server *Server) htmxAccountEditModal(writer http.ResponseWriter,
 	request *http.Request) {
-	to := server.getTOFromCookie(request)
-
-	if to.UserType != "admin" {
-		genericForbiddenReply(writer)
-		return
-	}
-
 	err := request.ParseForm()
 	if err != nil {
 		log.Println("htmxAccountEditModal() - parse form")
@@ -138,6 +97,7 @@ func (server *Server) htmxAccountEditModal(writer http.ResponseWriter,
 		"lastName":       request.FormValue("lastName"),
 		"username":       request.FormValue("username"),
 		"userType":       request.FormValue("userType"),
+		"roles":          store.Roles,
 	})
 }
 
@@ -146,11 +106,6 @@ func (server *Server) htmxAccountEditSave(writer http.ResponseWriter,
 	request *http.Request) {
 	to := server.getTOFromCookie(request)
 
-	if to.UserType != "admin" {
-		genericForbiddenReply(writer)
-		return
-	}
-
 	err := request.ParseForm()
 	if err != nil {
 		log.Println("htmxAccountsSave() - parse form")
@@ -164,17 +119,31 @@ func (server *Server) htmxAccountEditSave(writer http.ResponseWriter,
 	lastName := request.FormValue("lastName")
 	username := request.FormValue("username")
 	userType := request.FormValue("userType")
-	db := server.db
-	_, err = db.Exec(
-		`UPDATE TOfficers SET
-        	first_name = $1,
-        	last_name = $2,
-        	username = $3,
-			type = $4
-        WHERE id = $5
-		`, firstName, lastName,
-		username, userType, toId)
+	if !store.IsValidRole(userType) {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Unknown account type.",
+		})
+		return
+	}
 
+	before, err := server.store.TOfficers.Get(toId)
+	if err == sql.ErrNoRows {
+		genericNotFoundReply(writer)
+		return
+	} else if err != nil {
+		log.Println("htmxAccountsSave() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	after := TO{
+		Id:        toId,
+		FirstName: firstName,
+		LastName:  lastName,
+		Username:  username,
+		UserType:  userType,
+	}
+	err = server.store.TOfficers.Update(after)
 	if err != nil {
 		log.Println("htmxAccountsSave() - db update")
 		log.Println(err)
@@ -182,6 +151,8 @@ func (server *Server) htmxAccountEditSave(writer http.ResponseWriter,
 		return
 
 	}
+	server.recordAudit(webActor(to), store.AuditActionUpdate,
+		store.AuditTargetAccount, toId, before, after)
 
 	if request.FormValue("telegram") != "" {
 		err = server.redisStorage.Set(
@@ -226,13 +197,6 @@ func (server *Server) htmxAccountsNewHandler(writer http.ResponseWriter,
 // /htmx/accounts/new "GET"
 func (server *Server) htmxAccountNewModal(writer http.ResponseWriter,
 	request *http.Request) {
-	to := server.getTOFromCookie(request)
-
-	if to.UserType != "admin" {
-		genericForbiddenReply(writer)
-		return
-	}
-
 	err := request.ParseForm()
 	if err != nil {
 		log.Println("htmxAccountNewModal() - parse form")
@@ -244,6 +208,8 @@ func (server *Server) htmxAccountNewModal(writer http.ResponseWriter,
 	tmpl := template.Must(template.ParseFiles("./templates/htmx/accountNewModal.html"))
 	tmpl.Execute(writer, map[string]interface{}{
 		csrf.TemplateTag: csrf.TemplateField(request),
+		"roles":          store.Roles,
+		"defaultRole":    store.RoleOfficer,
 	})
 }
 
@@ -252,11 +218,6 @@ func (server *Server) htmxAccountNewSave(writer http.ResponseWriter,
 	request *http.Request) {
 	to := server.getTOFromCookie(request)
 
-	if to.UserType != "admin" {
-		genericForbiddenReply(writer)
-		return
-	}
-
 	err := request.ParseForm()
 	if err != nil {
 		log.Println("htmxAccountsNewSave() - parse form")
@@ -281,24 +242,22 @@ func (server *Server) htmxAccountNewSave(writer http.ResponseWriter,
 		return
 	}
 
-	if request.FormValue("telegram") != "" {
-		var toId int
-		err = server.db.QueryRow(`
-		SELECT id
-		FROM TOfficers
-		WHERE username = $1
-	`, request.FormValue("username")).Scan(&toId)
-		if err != nil {
-			log.Println("htmxAccountsNewSave() - query id")
-			log.Println(err)
-			genericInternalServerErrorReply(writer)
-			return
-		}
+	account, err := server.store.TOfficers.GetByUsername(
+		strings.ToLower(request.FormValue("username")))
+	if err != nil {
+		log.Println("htmxAccountsNewSave() - query id")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	server.recordAudit(webActor(to), store.AuditActionCreate,
+		store.AuditTargetAccount, account.Id, nil, account)
 
+	if request.FormValue("telegram") != "" {
 		err = server.redisStorage.Set(
 			request.Context(),
 			request.FormValue("telegram"),
-			toId,
+			account.Id,
 			0,
 		).Err()
 		if err != nil {
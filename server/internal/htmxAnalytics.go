@@ -0,0 +1,286 @@
+package internal
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/genekkion/PottySenseShared/store"
+	"github.com/gorilla/csrf"
+)
+
+// /htmx/analytics
+func (server *Server) htmxAnalyticsHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		server.htmxAnalyticsPanel(writer, request)
+	case http.MethodPost:
+		server.htmxAnalyticsSearch(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /htmx/analytics "GET"
+func (server *Server) htmxAnalyticsPanel(writer http.ResponseWriter,
+	request *http.Request) {
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/analytics.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"csrfToken":      csrf.Token(request),
+		"dayOptions":     analyticsDayOptions,
+	})
+}
+
+// /htmx/analytics "POST"
+// Lists the clients matching the search to pick from
+func (server *Server) htmxAnalyticsSearch(writer http.ResponseWriter,
+	request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("htmxAnalyticsSearch() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	clients, err := server.store.Clients.Search(request.FormValue("search"))
+	if err != nil {
+		log.Println("htmxAnalyticsSearch() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/analyticsClients.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"clients":        clients,
+	})
+}
+
+// /htmx/analytics/client "POST"
+// Charts of the toilet usage of the client over the
+// last number of days, including today
+func (server *Server) htmxAnalyticsClient(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodPost {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("htmxAnalyticsClient() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	clientId, _ := strconv.Atoi(request.FormValue("clientId"))
+	days, _ := strconv.Atoi(request.FormValue("days"))
+	if !isAnalyticsDayOption(days) {
+		days = analyticsDayOptions[1]
+	}
+
+	client, err := server.store.Clients.Get(clientId)
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusNotFound, map[string]string{
+			"error": "Client not found.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxAnalyticsClient() - db query client")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	from := today.AddDate(0, 0, 1-days)
+	entries, _, err := server.store.ToiletEntries.Find(store.ToiletEntryFilter{
+		ClientId: clientId,
+		From:     from,
+		To:       today.AddDate(0, 0, 1),
+	})
+	if err != nil {
+		log.Println("htmxAnalyticsClient() - db query entries")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	analytics := newClientAnalytics(client, entries, from, days)
+	analytics.Thresholds, err = server.getClientThresholds(client,
+		csrf.TemplateField(request))
+	if err != nil {
+		log.Println("htmxAnalyticsClient() - get thresholds")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	analytics.Thresholds.CanEdit = store.HasPermission(
+		server.getTOFromCookie(request).UserType, store.PermissionEditClients)
+
+	tmpl := template.Must(template.ParseFiles(
+		"./templates/htmx/analyticsClient.html",
+		"./templates/htmx/analyticsThresholds.html",
+	))
+	err = tmpl.Execute(writer, analytics)
+	if err != nil {
+		log.Println("htmxAnalyticsClient() - execute template")
+		log.Println(err)
+	}
+}
+
+// /htmx/analytics/thresholds "PUT"
+// Applies the suggested threshold of the business type
+// to the client, responding with the updated section
+func (server *Server) htmxAnalyticsThresholdApply(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodPut {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+	to := server.getTOFromCookie(request)
+
+	client, ok := server.parseThresholdsClient(writer, request)
+	if !ok {
+		return
+	}
+
+	suggestions, err := server.getThresholdSuggestions(client)
+	if err != nil {
+		log.Println("htmxAnalyticsThresholdApply() - get suggestions")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	businessType := request.FormValue("businessType")
+	for _, suggestion := range suggestions {
+		if suggestion.BusinessType != businessType {
+			continue
+		}
+		if !suggestion.CanApply() {
+			writeJson(writer, http.StatusConflict, map[string]string{
+				"error": "No new threshold to apply.",
+			})
+			return
+		}
+		err = server.applyThreshold(client.Id, suggestion, to.Id)
+		if err != nil {
+			log.Println("htmxAnalyticsThresholdApply() - apply threshold")
+			log.Println(err)
+			genericInternalServerErrorReply(writer)
+			return
+		}
+		server.recordAudit(webActor(to), store.AuditActionUpdate,
+			store.AuditTargetThreshold, client.Id,
+			map[string]int{businessType: suggestion.Current},
+			map[string]int{businessType: suggestion.Suggested})
+		server.renderClientThresholds(writer, request, client.Id)
+		return
+	}
+
+	writeJson(writer, http.StatusBadRequest, map[string]string{
+		"error": "businessType should be either urination or defecation.",
+	})
+}
+
+// /htmx/analytics/thresholds/auto "PUT"
+// Sets whether suggested thresholds are applied
+// automatically, responding with the updated section
+func (server *Server) htmxAnalyticsThresholdAuto(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodPut {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+
+	client, ok := server.parseThresholdsClient(writer, request)
+	if !ok {
+		return
+	}
+
+	autoThreshold := request.FormValue("autoThreshold") == "true"
+	err := server.store.Clients.SetAutoThreshold(client.Id, autoThreshold)
+	if err != nil {
+		log.Println("htmxAnalyticsThresholdAuto() - db update")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	server.recordAudit(webActor(server.getTOFromCookie(request)),
+		store.AuditActionUpdate, store.AuditTargetThreshold, client.Id,
+		map[string]bool{"autoThreshold": client.AutoThreshold},
+		map[string]bool{"autoThreshold": autoThreshold})
+	server.renderClientThresholds(writer, request, client.Id)
+}
+
+// Gets the client of the thresholds form, replying
+// with the error and false if there is none
+func (server *Server) parseThresholdsClient(writer http.ResponseWriter,
+	request *http.Request) (store.Client, bool) {
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("parseThresholdsClient() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return store.Client{}, false
+	}
+
+	clientId, _ := strconv.Atoi(request.FormValue("clientId"))
+	client, err := server.store.Clients.Get(clientId)
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusNotFound, map[string]string{
+			"error": "Client not found.",
+		})
+		return store.Client{}, false
+	} else if err != nil {
+		log.Println("parseThresholdsClient() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return store.Client{}, false
+	}
+	return client, true
+}
+
+// Renders the thresholds section of the client
+func (server *Server) renderClientThresholds(writer http.ResponseWriter,
+	request *http.Request, clientId int) {
+	client, err := server.store.Clients.Get(clientId)
+	if err != nil {
+		log.Println("renderClientThresholds() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	thresholds, err := server.getClientThresholds(client,
+		csrf.TemplateField(request))
+	if err != nil {
+		log.Println("renderClientThresholds() - get thresholds")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	// Only rendered after changing the thresholds
+	thresholds.CanEdit = true
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/analyticsThresholds.html"))
+	tmpl.ExecuteTemplate(writer, "analyticsThresholds", thresholds)
+}
+
+func isAnalyticsDayOption(days int) bool {
+	for _, option := range analyticsDayOptions {
+		if days == option {
+			return true
+		}
+	}
+	return false
+}
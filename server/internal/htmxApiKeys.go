@@ -0,0 +1,253 @@
+package internal
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/genekkion/PottySenseShared/signing"
+	"github.com/genekkion/PottySenseShared/store"
+	"github.com/gorilla/csrf"
+)
+
+// An API key along with the csrf field for its forms
+type ApiKeyEntry struct {
+	store.ApiKey
+	CsrfField template.HTML
+}
+
+// /htmx/api-keys
+func (server *Server) htmxApiKeysHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		server.htmxApiKeysPanel(writer, request)
+	case http.MethodPost:
+		server.htmxApiKeysList(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /htmx/api-keys "GET"
+func (server *Server) htmxApiKeysPanel(writer http.ResponseWriter,
+	request *http.Request) {
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/apiKeys.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"csrfToken":      csrf.Token(request),
+	})
+}
+
+// /htmx/api-keys "POST"
+func (server *Server) htmxApiKeysList(writer http.ResponseWriter,
+	request *http.Request) {
+	apiKeys, err := server.store.ApiKeys.List()
+	if err != nil {
+		log.Println("htmxApiKeysList() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	var entries []ApiKeyEntry
+	for _, apiKey := range apiKeys {
+		entries = append(entries, ApiKeyEntry{
+			ApiKey:    apiKey,
+			CsrfField: csrf.TemplateField(request),
+		})
+	}
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/apiKeyEntry.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		"apiKeys": entries,
+	})
+}
+
+// /htmx/api-keys/new
+func (server *Server) htmxApiKeysNewHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		server.htmxApiKeyNewModal(writer, request)
+	case http.MethodPost:
+		server.htmxApiKeyNewSave(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /htmx/api-keys/new "GET"
+func (server *Server) htmxApiKeyNewModal(writer http.ResponseWriter,
+	request *http.Request) {
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/apiKeyNewModal.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"scopes":         store.ApiKeyScopes,
+	})
+}
+
+// /htmx/api-keys/new "POST"
+// Responds with a modal showing the key, which
+// is the only time the key is shown
+func (server *Server) htmxApiKeyNewSave(writer http.ResponseWriter,
+	request *http.Request) {
+	to := server.getTOFromCookie(request)
+
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("htmxApiKeyNewSave() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	apiKey := store.ApiKey{
+		Name:      strings.TrimSpace(request.FormValue("name")),
+		Scopes:    store.CleanApiKeyScopes(request.Form["scopes"]),
+		CreatedBy: to.Id,
+	}
+	if apiKey.Name == "" || len(apiKey.Scopes) == 0 {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Invalid API key details.",
+		})
+		return
+	}
+
+	key, prefix, err := store.GenerateApiKey()
+	if err != nil {
+		log.Println("htmxApiKeyNewSave() - generate key")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	apiKey.Prefix = prefix
+	apiKey.KeyHash = store.HashApiKey(key)
+
+	apiKey.Id, err = server.store.ApiKeys.Create(apiKey)
+	if err != nil {
+		log.Println("htmxApiKeyNewSave() - db insert")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	server.recordAudit(webActor(to), store.AuditActionCreate,
+		store.AuditTargetApiKey, apiKey.Id, nil, apiKey)
+
+	writer.Header().Set("HX-Trigger", "apiKeysChanged")
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/apiKeyIssuedModal.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		"apiKey": apiKey,
+		"key":    key,
+		"secret": signing.DeriveSecret(server.signingSecret, store.HashApiKey(key)),
+	})
+}
+
+// /htmx/api-keys/edit
+func (server *Server) htmxApiKeysEditHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodPut:
+		server.htmxApiKeyRotate(writer, request)
+	case http.MethodDelete:
+		server.htmxApiKeyRevoke(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /htmx/api-keys/edit "PUT"
+// Replaces the key, responding with a modal showing the new key.
+// The previous key stops working immediately.
+func (server *Server) htmxApiKeyRotate(writer http.ResponseWriter,
+	request *http.Request) {
+	to := server.getTOFromCookie(request)
+
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("htmxApiKeyRotate() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	apiKeyId, _ := strconv.Atoi(request.FormValue("id"))
+	key, prefix, err := store.GenerateApiKey()
+	if err != nil {
+		log.Println("htmxApiKeyRotate() - generate key")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	err = server.store.ApiKeys.Rotate(apiKeyId, prefix, store.HashApiKey(key))
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusNotFound, map[string]string{
+			"error": "API key not found or revoked.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxApiKeyRotate() - db update")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	apiKey, err := server.store.ApiKeys.Get(apiKeyId)
+	if err != nil {
+		log.Println("htmxApiKeyRotate() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	// Only the prefix of the new key, never the key
+	server.recordAudit(webActor(to), store.AuditActionRotate,
+		store.AuditTargetApiKey, apiKeyId, nil,
+		map[string]string{"prefix": apiKey.Prefix})
+
+	writer.Header().Set("HX-Trigger", "apiKeysChanged")
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/apiKeyIssuedModal.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		"apiKey": apiKey,
+		"key":    key,
+		"secret": signing.DeriveSecret(server.signingSecret, store.HashApiKey(key)),
+	})
+}
+
+// /htmx/api-keys/edit "DELETE"
+// Revokes the key, responding with the updated entry
+func (server *Server) htmxApiKeyRevoke(writer http.ResponseWriter,
+	request *http.Request) {
+	to := server.getTOFromCookie(request)
+
+	apiKeyId, _ := strconv.Atoi(request.FormValue("id"))
+	err := server.store.ApiKeys.Revoke(apiKeyId)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("htmxApiKeyRevoke() - db update")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	} else if err == nil {
+		server.recordAudit(webActor(to), store.AuditActionRevoke,
+			store.AuditTargetApiKey, apiKeyId, nil, nil)
+	}
+
+	apiKey, err := server.store.ApiKeys.Get(apiKeyId)
+	if err == sql.ErrNoRows {
+		// Nothing to show, so the entry is removed
+		return
+	} else if err != nil {
+		log.Println("htmxApiKeyRevoke() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/apiKeyEntry.html"))
+	tmpl.ExecuteTemplate(writer, "apiKeyRow", ApiKeyEntry{
+		ApiKey:    apiKey,
+		CsrfField: csrf.TemplateField(request),
+	})
+}
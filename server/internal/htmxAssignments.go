@@ -0,0 +1,375 @@
+package internal
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/genekkion/PottySenseShared/store"
+	"github.com/gorilla/csrf"
+)
+
+// Whether the officer is tracking the client, shown
+// as a cell of the assignments matrix
+type AssignmentCell struct {
+	ToId     int
+	ClientId int
+	Assigned bool
+}
+
+// A row of the assignments matrix, with a cell for
+// each officer in the order of the columns
+type AssignmentRow struct {
+	Client store.Client
+	Cells  []AssignmentCell
+}
+
+// Clients assigned to or unassigned from an officer
+// in one change, to let the officer know of them
+type assignmentChanges struct {
+	assigned   []string
+	unassigned []string
+}
+
+// Gets the TOs who can be assigned clients, ordered by id
+func (server *Server) getAssignableTOs() ([]TO, error) {
+	tos, err := server.store.TOfficers.List()
+	if err != nil {
+		return nil, err
+	}
+	var officers []TO
+	for _, to := range tos {
+		if store.HasPermission(to.UserType, store.PermissionTrack) {
+			officers = append(officers, to)
+		}
+	}
+	return officers, nil
+}
+
+// Gets the ids supplied in the form field, skipping invalid ones
+func formIds(request *http.Request, field string) []int {
+	var ids []int
+	for _, value := range request.Form[field] {
+		id, err := strconv.Atoi(value)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// /htmx/assignments
+func (server *Server) htmxAssignmentsHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		server.htmxAssignmentsPanel(writer, request)
+	case http.MethodPost:
+		server.htmxAssignmentsMatrix(writer, request)
+	case http.MethodPut:
+		server.htmxAssignmentsToggle(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /htmx/assignments "GET"
+func (server *Server) htmxAssignmentsPanel(writer http.ResponseWriter,
+	request *http.Request) {
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/assignments.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"csrfToken":      csrf.Token(request),
+	})
+}
+
+// /htmx/assignments "POST"
+// Lists the clients with the name searched for, against
+// every officer, marking which officers track them
+func (server *Server) htmxAssignmentsMatrix(writer http.ResponseWriter,
+	request *http.Request) {
+	clients, err := server.store.Clients.Search(request.FormValue("search"))
+	if err != nil {
+		log.Println("htmxAssignmentsMatrix() - db query clients")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	tos, err := server.store.TOfficers.List()
+	if err != nil {
+		log.Println("htmxAssignmentsMatrix() - db query officers")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	assignments, err := server.store.Track.List()
+	if err != nil {
+		log.Println("htmxAssignmentsMatrix() - db query assignments")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	assigned := make(map[store.Assignment]bool, len(assignments))
+	tracking := make(map[int]bool)
+	for _, assignment := range assignments {
+		assigned[assignment] = true
+		tracking[assignment.ToId] = true
+	}
+	// Officers who can no longer track clients are listed
+	// while they still track any, so they can be unassigned
+	var officers []TO
+	for _, officer := range tos {
+		if store.HasPermission(officer.UserType, store.PermissionTrack) ||
+			tracking[officer.Id] {
+			officers = append(officers, officer)
+		}
+	}
+	var rows []AssignmentRow
+	for _, client := range clients {
+		row := AssignmentRow{Client: client}
+		for _, officer := range officers {
+			row.Cells = append(row.Cells, AssignmentCell{
+				ToId:     officer.Id,
+				ClientId: client.Id,
+				Assigned: assigned[store.Assignment{ToId: officer.Id, ClientId: client.Id}],
+			})
+		}
+		rows = append(rows, row)
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/assignmentsMatrix.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		"officers": officers,
+		"rows":     rows,
+	})
+}
+
+// /htmx/assignments "PUT"
+// Assigns the client to the officer if "assigned" is
+// checked, else unassigns them, responding with the cell
+func (server *Server) htmxAssignmentsToggle(writer http.ResponseWriter,
+	request *http.Request) {
+	cell := AssignmentCell{
+		Assigned: request.FormValue("assigned") == "true",
+	}
+	cell.ToId, _ = strconv.Atoi(request.FormValue("toId"))
+	cell.ClientId, _ = strconv.Atoi(request.FormValue("clientId"))
+
+	ok := server.setAssignments(writer, request,
+		[]int{cell.ToId}, []int{cell.ClientId}, cell.Assigned)
+	if !ok {
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/assignmentsMatrix.html"))
+	tmpl.ExecuteTemplate(writer, "assignmentCell", cell)
+}
+
+// /htmx/assignments/bulk "POST"
+// Assigns all the clients checked to all the officers checked,
+// or unassigns them if "action" is "unassign"
+func (server *Server) htmxAssignmentsBulk(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodPost {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("htmxAssignmentsBulk() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	toIds := formIds(request, "toIds")
+	clientIds := formIds(request, "clientIds")
+	if len(toIds) == 0 || len(clientIds) == 0 {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Please check at least one client and one officer.",
+		})
+		return
+	}
+
+	ok := server.setAssignments(writer, request, toIds, clientIds,
+		request.FormValue("action") != "unassign")
+	if !ok {
+		return
+	}
+	writer.Header().Set("HX-Trigger", "assignmentsChanged")
+}
+
+// Assigns every client to every officer supplied, or unassigns
+// them, skipping those already so. Each change is recorded in the
+// audit log, and officers are let know of their changes through
+// telegram, unless they made them. Replies with the error and
+// returns false if any officer or client is not found.
+func (server *Server) setAssignments(writer http.ResponseWriter,
+	request *http.Request, toIds []int, clientIds []int, assign bool) bool {
+	to := server.getTOFromCookie(request)
+
+	// Officers may be unassigned whatever their role, e.g.
+	// once they can no longer track clients
+	var officers []TO
+	var err error
+	if assign {
+		officers, err = server.getAssignableTOs()
+	} else {
+		officers, err = server.store.TOfficers.List()
+	}
+	if err != nil {
+		log.Println("setAssignments() - db query officers")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return false
+	}
+	officersById := make(map[int]TO, len(officers))
+	for _, officer := range officers {
+		officersById[officer.Id] = officer
+	}
+	clients, err := server.store.Clients.List()
+	if err != nil {
+		log.Println("setAssignments() - db query clients")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return false
+	}
+	clientsById := make(map[int]store.Client, len(clients))
+	for _, client := range clients {
+		clientsById[client.Id] = client
+	}
+
+	for _, toId := range toIds {
+		if _, ok := officersById[toId]; !ok {
+			writeJson(writer, http.StatusNotFound, map[string]string{
+				"error": "Officer not found or cannot track clients.",
+			})
+			return false
+		}
+	}
+	for _, clientId := range clientIds {
+		if _, ok := clientsById[clientId]; !ok {
+			writeJson(writer, http.StatusNotFound, map[string]string{
+				"error": "Client not found.",
+			})
+			return false
+		}
+	}
+
+	tx, err := server.db.Begin()
+	if err != nil {
+		log.Println("setAssignments() - begin transaction")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return false
+	}
+	defer tx.Rollback()
+	txStore := store.New(tx)
+
+	assignments, err := txStore.Track.List()
+	if err != nil {
+		log.Println("setAssignments() - db query assignments")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return false
+	}
+	assigned := make(map[store.Assignment]bool, len(assignments))
+	for _, assignment := range assignments {
+		assigned[assignment] = true
+	}
+
+	var changed []store.Assignment
+	for _, toId := range toIds {
+		for _, clientId := range clientIds {
+			assignment := store.Assignment{ToId: toId, ClientId: clientId}
+			if assigned[assignment] == assign {
+				continue
+			}
+			if assign {
+				err = txStore.Track.Add(toId, clientId)
+			} else {
+				err = txStore.Track.Remove(toId, clientId)
+			}
+			if err != nil {
+				log.Println("setAssignments() - db update")
+				log.Println(err)
+				genericInternalServerErrorReply(writer)
+				return false
+			}
+			changed = append(changed, assignment)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println("setAssignments() - commit transaction")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return false
+	}
+
+	action := store.AuditActionTrack
+	if !assign {
+		action = store.AuditActionUntrack
+	}
+	changes := make(map[int]*assignmentChanges)
+	var changedToIds []int
+	for _, assignment := range changed {
+		server.recordAudit(webActor(to), action, store.AuditTargetClient,
+			assignment.ClientId, nil, map[string]int{"toId": assignment.ToId})
+		changedToIds = append(changedToIds, assignment.ToId)
+
+		if assignment.ToId == to.Id {
+			continue
+		}
+		if changes[assignment.ToId] == nil {
+			changes[assignment.ToId] = &assignmentChanges{}
+		}
+		client := clientsById[assignment.ClientId]
+		name := client.FirstName + " " + client.LastName
+		if assign {
+			changes[assignment.ToId].assigned = append(changes[assignment.ToId].assigned, name)
+		} else {
+			changes[assignment.ToId].unassigned = append(changes[assignment.ToId].unassigned, name)
+		}
+	}
+	for toId, officerChanges := range changes {
+		server.notifyAssignments(officersById[toId], to.Username, *officerChanges)
+	}
+	server.publishTrackingChanged(changedToIds...)
+	return true
+}
+
+// Lets the officer know of the clients assigned to or
+// unassigned from them through telegram, if registered
+func (server *Server) notifyAssignments(officer TO, by string,
+	changes assignmentChanges) {
+	if officer.TelegramChatId == "" {
+		return
+	}
+
+	var lines []string
+	if len(changes.assigned) > 0 {
+		lines = append(lines, "You have been assigned "+
+			strings.Join(changes.assigned, ", ")+" by "+by+".")
+	}
+	if len(changes.unassigned) > 0 {
+		lines = append(lines, "You have been unassigned from "+
+			strings.Join(changes.unassigned, ", ")+" by "+by+".")
+	}
+	lines = append(lines, "Use /current to see your tracked clients.")
+
+	err := server.enqueueNotification(officer.Id, store.ChannelTelegram,
+		officer.TelegramChatId, Notification{
+			Type:    "notification",
+			Title:   "Assignments changed",
+			Message: strings.Join(lines, "\n"),
+		})
+	if err != nil {
+		log.Println("notifyAssignments() - queue notification")
+		log.Println(err)
+	}
+}
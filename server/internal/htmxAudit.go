@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/genekkion/PottySenseShared/store"
+	"github.com/gorilla/csrf"
+	"github.com/xuri/excelize/v2"
+)
+
+// Most audit entries listed at once, the export has all of them
+const auditListLimit = 100
+
+// Gets the filters of the audit log from the form, with the
+// dates in local time. Entries up to the end of the day of
+// "to" are included.
+func auditFilterFromRequest(request *http.Request) store.AuditFilter {
+	filter := store.AuditFilter{
+		Actor:      request.FormValue("actor"),
+		Source:     request.FormValue("source"),
+		Action:     request.FormValue("action"),
+		TargetType: request.FormValue("targetType"),
+	}
+	filter.TargetId, _ = strconv.Atoi(request.FormValue("targetId"))
+
+	from, err := time.ParseInLocation(time.DateOnly, request.FormValue("from"), time.Local)
+	if err == nil {
+		filter.From = from
+	}
+	to, err := time.ParseInLocation(time.DateOnly, request.FormValue("to"), time.Local)
+	if err == nil {
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	return filter
+}
+
+// /htmx/audit
+func (server *Server) htmxAuditHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		server.htmxAuditPanel(writer, request)
+	case http.MethodPost:
+		server.htmxAuditList(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /htmx/audit "GET"
+func (server *Server) htmxAuditPanel(writer http.ResponseWriter,
+	request *http.Request) {
+	actors, err := server.store.Audit.ListActors()
+	if err != nil {
+		log.Println("htmxAuditPanel() - db query actors")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/audit.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"csrfToken":      csrf.Token(request),
+		"actors":         actors,
+		"sources": []string{
+			store.AuditSourceWeb,
+			store.AuditSourceBot,
+			store.AuditSourceApi,
+		},
+		"actions": []string{
+			store.AuditActionCreate,
+			store.AuditActionUpdate,
+			store.AuditActionDelete,
+			store.AuditActionTrack,
+			store.AuditActionUntrack,
+			store.AuditActionStart,
+			store.AuditActionCancel,
+			store.AuditActionFinish,
+			store.AuditActionRespond,
+			store.AuditActionRotate,
+			store.AuditActionRevoke,
+		},
+		"targetTypes": []string{
+			store.AuditTargetAccount,
+			store.AuditTargetClient,
+			store.AuditTargetThreshold,
+			store.AuditTargetReminder,
+			store.AuditTargetSession,
+			store.AuditTargetEntry,
+			store.AuditTargetAlert,
+			store.AuditTargetToilet,
+			store.AuditTargetApiKey,
+			store.AuditTargetShift,
+		},
+	})
+}
+
+// /htmx/audit "POST"
+// Lists the latest audit entries matching the filters
+func (server *Server) htmxAuditList(writer http.ResponseWriter,
+	request *http.Request) {
+	filter := auditFilterFromRequest(request)
+	filter.Page = store.Page{Limit: auditListLimit}
+	entries, total, err := server.store.Audit.Find(filter)
+	if err != nil {
+		log.Println("htmxAuditList() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/auditEntry.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		"entries": entries,
+		"total":   total,
+		"hidden":  total - len(entries),
+	})
+}
+
+// /htmx/audit/export "GET"
+// Downloads all the audit entries matching the filters
+// as a spreadsheet, newest first
+func (server *Server) htmxAuditExport(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+	entries, _, err := server.store.Audit.Find(auditFilterFromRequest(request))
+	if err != nil {
+		log.Println("htmxAuditExport() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	file, err := newAuditSpreadsheet(entries)
+	if err != nil {
+		log.Println("htmxAuditExport() - write spreadsheet")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	defer file.Close()
+
+	writer.Header().Set("Content-Type",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	writer.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="audit-log-%s.xlsx"`,
+			time.Now().Format(time.DateOnly)))
+	err = file.Write(writer)
+	if err != nil {
+		log.Println("htmxAuditExport() - send spreadsheet")
+		log.Println(err)
+	}
+}
+
+// Writes the audit entries to a spreadsheet, one per row
+func newAuditSpreadsheet(entries []store.AuditEntry) (*excelize.File, error) {
+	const sheet = "Audit log"
+
+	file := excelize.NewFile()
+	err := file.SetSheetName("Sheet1", sheet)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	rows := [][]interface{}{{
+		"ID", "Time", "Actor", "Actor ID", "Source",
+		"Action", "Target", "Target ID", "Before", "After",
+	}}
+	for _, entry := range entries {
+		rows = append(rows, []interface{}{
+			entry.Id, entry.CreatedAt.Local().Format(time.DateTime),
+			entry.Actor, entry.ActorId, entry.Source,
+			entry.Action, entry.TargetType, entry.TargetId,
+			entry.Before, entry.After,
+		})
+	}
+	err = setSheetRows(file, sheet, rows)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
@@ -6,10 +6,9 @@ import (
 	"log"
 	"net/http"
 	"strconv"
-	"time"
+	"strings"
 
-	"github.com/genekkion/PottySenseServer/internal/globals"
-	"github.com/genekkion/PottySenseServer/internal/utils"
+	"github.com/genekkion/PottySenseShared/store"
 	"github.com/gorilla/csrf"
 )
 
@@ -22,7 +21,8 @@ func (server *Server) htmxClients(writer http.ResponseWriter,
 	case http.MethodPost:
 		server.htmxClientSearch(writer, request)
 	case http.MethodPut:
-		server.htmxClientTrack(writer, request)
+		server.permissionWrapper(store.PermissionTrack,
+			server.htmxClientTrack)(writer, request)
 	default:
 		genericMethodNotAllowedReply(writer)
 	}
@@ -31,9 +31,12 @@ func (server *Server) htmxClients(writer http.ResponseWriter,
 // /htmx/clients "GET"
 func (server *Server) htmxClientsPanel(writer http.ResponseWriter,
 	request *http.Request) {
+	to := server.getTOFromCookie(request)
 	tmpl := template.Must(template.ParseFiles("./templates/htmx/clients.html"))
 	tmpl.Execute(writer, map[string]interface{}{
 		"csrfToken": csrf.Token(request),
+		"canEdit":   store.HasPermission(to.UserType, store.PermissionEditClients),
+		"canTrack":  store.HasPermission(to.UserType, store.PermissionTrack),
 	})
 
 }
@@ -51,19 +54,7 @@ func (server *Server) htmxClientSearch(writer http.ResponseWriter,
 
 	to := server.getTOFromCookie(request)
 
-	// Add wildcard for autocomplete
-	searchQuery := request.FormValue("search") + "%"
-	rows, err := server.db.Query(
-		`SELECT Clients.id, Clients.first_name,
-			Clients.last_name, Clients.gender,
-			Clients.urination, Clients.defecation,
-        	Clients.last_record, Track.to_id 
-        FROM Clients LEFT JOIN Track
-        	ON Clients.id = Track.client_id
-        		AND Track.to_id = $1
-        WHERE first_name LIKE $2 COLLATE NOCASE
-			OR last_name LIKE $2 COLLATE NOCASE
-		`, to.Id, searchQuery)
+	clients, err := server.store.Clients.Search(request.FormValue("search"))
 	if err != nil {
 		log.Println("htmxClientSearch() - db query")
 		log.Println(err)
@@ -71,46 +62,36 @@ func (server *Server) htmxClientSearch(writer http.ResponseWriter,
 		return
 	}
 
+	trackedIds, err := server.store.Track.ListClientIds(to.Id)
+	if err != nil {
+		log.Println("htmxClientSearch() - db query tracking")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	isTracking := map[int]bool{}
+	for _, clientId := range trackedIds {
+		isTracking[clientId] = true
+	}
+
 	type ClientEntry struct {
 		Client     Client
 		IsTracking bool
 	}
 
 	var entries []ClientEntry
-	for rows.Next() {
-		var client Client
-		// Needs to be nullable in case the TO
-		// is NOT Tracking a particular client
-		var checkTo sql.NullInt32
-
-		rows.Scan(
-			&client.Id,
-			&client.FirstName,
-			&client.LastName,
-			&client.Gender,
-			&client.Urination,
-			&client.Defecation,
-			&client.LastRecord,
-			&checkTo,
-		)
-
-		istracking := checkTo.Valid
-
-		if time.Since(client.LastRecord).Hours() < globals.LAST_RECORD_THRESHOLD {
-			client.PrettyLastRecord = utils.GetTimeElapsedPretty(client.LastRecord)
-		} else {
-			client.PrettyLastRecord = "nil"
-		}
-
+	for _, client := range clients {
 		entries = append(entries, ClientEntry{
-			Client:     client,
-			IsTracking: istracking,
+			Client:     newClient(client),
+			IsTracking: isTracking[client.Id],
 		})
 	}
 	tmpl := template.Must(template.ParseFiles("./templates/htmx/clientEntry.html"))
 	tmpl.Execute(writer, map[string]interface{}{
 		csrf.TemplateTag: csrf.TemplateField(request),
 		"entries":        entries,
+		"canEdit":        store.HasPermission(to.UserType, store.PermissionEditClients),
+		"canTrack":       store.HasPermission(to.UserType, store.PermissionTrack),
 	})
 }
 
@@ -119,25 +100,22 @@ func (server *Server) htmxClientTrack(writer http.ResponseWriter,
 	request *http.Request) {
 	to := server.getTOFromCookie(request)
 
-	clientId := request.FormValue("clientId")
+	clientId, _ := strconv.Atoi(request.FormValue("clientId"))
 	// TODO: change to toTrack from toAssign
 	toTrack := request.FormValue("toTrack")
 	tmpl := template.Must(template.ParseFiles("./templates/htmx/clientEntryButton.html"))
 
 	// This means to remove tracking
 	if toTrack == "false" {
-		_, err := server.db.Exec(
-			`DELETE FROM Track
-            WHERE to_id = $1
-				AND client_id = $2
-			`, to.Id, clientId)
-
+		err := server.store.Track.Remove(to.Id, clientId)
 		if err != nil {
 			log.Println("htmxClientTrack() - db delete")
 			log.Println(err)
 			genericInternalServerErrorReply(writer)
 			return
 		}
+		server.recordAudit(webActor(to), store.AuditActionUntrack,
+			store.AuditTargetClient, clientId, nil, nil)
 
 		tmpl.Execute(writer, map[string]interface{}{
 			csrf.TemplateTag: csrf.TemplateField(request),
@@ -148,16 +126,15 @@ func (server *Server) htmxClientTrack(writer http.ResponseWriter,
 		return
 	}
 
-	_, err := server.db.Exec(
-		`INSERT OR IGNORE
-        INTO Track (to_id, client_id)
-        VALUES ($1, $2)`, to.Id, clientId)
+	err := server.store.Track.Add(to.Id, clientId)
 	if err != nil {
 		log.Println("htmxClientTrack() - db insert")
 		log.Println(err)
 		genericInternalServerErrorReply(writer)
 		return
 	}
+	server.recordAudit(webActor(to), store.AuditActionTrack,
+		store.AuditTargetClient, clientId, nil, nil)
 
 	tmpl.Execute(writer, map[string]interface{}{
 		csrf.TemplateTag: csrf.TemplateField(request),
@@ -208,19 +185,159 @@ func htmxClientNewSave(writer http.ResponseWriter,
 	urination, _ := strconv.Atoi(request.FormValue("urination"))
 	defecation, _ := strconv.Atoi(request.FormValue("defecation"))
 
-	_, err = server.db.Exec(
-		`INSERT INTO Clients 
-        	(first_name, last_name,
-			gender, urination, defecation)
-        VALUES ($1, $2, $3, $4, $5)`,
-		firstName, lastName, gender,
-		urination, defecation)
+	client := store.Client{
+		FirstName:  firstName,
+		LastName:   lastName,
+		Gender:     gender,
+		Urination:  urination,
+		Defecation: defecation,
+	}
+	client.Id, err = server.store.Clients.Create(client)
 	if err != nil {
 		log.Println("htmxClientNewSave() - db insert")
 		log.Println(err)
 		genericInternalServerErrorReply(writer)
 		return
 	}
+	server.recordAudit(webActor(server.getTOFromCookie(request)),
+		store.AuditActionCreate, store.AuditTargetClient, client.Id, nil, client)
 	writer.Header().Add("HX-Trigger", "newClient")
 	//writeJson(writer, http.StatusCreated, nil)
 }
+
+// /htmx/clients/reminders
+func (server *Server) htmxClientRemindersHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodPost:
+		server.htmxClientRemindersModal(writer, request)
+	case http.MethodPut:
+		server.htmxClientRemindersSave(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /htmx/clients/reminders "POST"
+// Responds with the modal to configure the reminders of the client
+func (server *Server) htmxClientRemindersModal(writer http.ResponseWriter,
+	request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("htmxClientRemindersModal() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	clientId, _ := strconv.Atoi(request.FormValue("clientId"))
+	client, err := server.store.Clients.Get(clientId)
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusNotFound, map[string]string{
+			"error": "Client not found.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxClientRemindersModal() - db query client")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	reminder, err := server.store.Reminders.Get(clientId)
+	if err != nil {
+		log.Println("htmxClientRemindersModal() - db query reminders")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/clientRemindersModal.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"client":         client,
+		"reminder":       reminder,
+		"schedule":       strings.Join(reminder.Schedule, ", "),
+	})
+}
+
+// /htmx/clients/reminders "PUT"
+// An interval of 0 and an empty schedule disable the reminders
+func (server *Server) htmxClientRemindersSave(writer http.ResponseWriter,
+	request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("htmxClientRemindersSave() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	clientId, _ := strconv.Atoi(request.FormValue("clientId"))
+	intervalMinutes, err := strconv.Atoi(request.FormValue("intervalMinutes"))
+	if err != nil || intervalMinutes < 0 {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Interval should be a number of minutes.",
+		})
+		return
+	}
+	schedule, err := parseReminderSchedule(request.FormValue("schedule"))
+	if err != nil {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Schedule times should be HH:MM.",
+		})
+		return
+	}
+
+	reminder := store.ClientReminder{
+		ClientId:        clientId,
+		IntervalMinutes: intervalMinutes,
+		Schedule:        schedule,
+		QuietStart:      request.FormValue("quietStart"),
+		QuietEnd:        request.FormValue("quietEnd"),
+	}
+	if reminder.QuietStart != "" || reminder.QuietEnd != "" {
+		_, startErr := parseReminderTime(reminder.QuietStart)
+		_, endErr := parseReminderTime(reminder.QuietEnd)
+		if startErr != nil || endErr != nil {
+			writeJson(writer, http.StatusBadRequest, map[string]string{
+				"error": "Quiet hours need both a start and an end.",
+			})
+			return
+		}
+	}
+
+	_, err = server.store.Clients.Get(clientId)
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusNotFound, map[string]string{
+			"error": "Client not found.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxClientRemindersSave() - db query client")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	before, err := server.store.Reminders.Get(clientId)
+	if err != nil {
+		log.Println("htmxClientRemindersSave() - db query reminders")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	err = server.store.Reminders.Set(reminder)
+	if err != nil {
+		log.Println("htmxClientRemindersSave() - db update")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	server.recordAudit(webActor(server.getTOFromCookie(request)),
+		store.AuditActionUpdate, store.AuditTargetReminder, clientId, before, reminder)
+	writeJson(writer, http.StatusOK, map[string]string{
+		"message": "Reminders saved.",
+	})
+}
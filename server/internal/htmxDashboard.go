@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/genekkion/PottySenseServer/internal/globals"
+	"github.com/genekkion/PottySenseShared/store"
 	"github.com/gorilla/csrf"
 )
 
@@ -14,6 +15,9 @@ type TabListEntry struct {
 	Title       string
 	HtmxPath    string
 	RedirectUrl string
+	// Only shown to TOs with the permission,
+	// or to everyone if empty
+	Permission string
 }
 
 var (
@@ -23,18 +27,77 @@ var (
 			Title:       "Track",
 			HtmxPath:    "/htmx/track",
 			RedirectUrl: "/track",
+			Permission:  store.PermissionTrack,
+		},
+		{
+			Id:          "tab-shift",
+			Title:       "Shift",
+			HtmxPath:    "/htmx/shift",
+			RedirectUrl: "/shift",
+			Permission:  store.PermissionTrack,
 		},
 		{
 			Id:          "tab-clients",
 			Title:       "Clients",
 			HtmxPath:    "/htmx/clients",
 			RedirectUrl: "/clients",
+			Permission:  store.PermissionViewClients,
+		},
+		{
+			Id:          "tab-analytics",
+			Title:       "Analytics",
+			HtmxPath:    "/htmx/analytics",
+			RedirectUrl: "/analytics",
+			Permission:  store.PermissionViewClients,
+		},
+		{
+			Id:          "tab-reports",
+			Title:       "Reports",
+			HtmxPath:    "/htmx/reports",
+			RedirectUrl: "/reports",
+			Permission:  store.PermissionViewClients,
+		},
+		{
+			Id:          "tab-assignments",
+			Title:       "Assignments",
+			HtmxPath:    "/htmx/assignments",
+			RedirectUrl: "/assignments",
+			Permission:  store.PermissionAssignClients,
 		},
 		{
 			Id:          "tab-accounts",
 			Title:       "Accounts",
 			HtmxPath:    "/htmx/accounts",
 			RedirectUrl: "/accounts",
+			Permission:  store.PermissionManageAccounts,
+		},
+		{
+			Id:          "tab-toilets",
+			Title:       "Toilets",
+			HtmxPath:    "/htmx/toilets",
+			RedirectUrl: "/toilets",
+			Permission:  store.PermissionManageToilets,
+		},
+		{
+			Id:          "tab-api-keys",
+			Title:       "API keys",
+			HtmxPath:    "/htmx/api-keys",
+			RedirectUrl: "/api-keys",
+			Permission:  store.PermissionManageApiKeys,
+		},
+		{
+			Id:          "tab-deliveries",
+			Title:       "Deliveries",
+			HtmxPath:    "/htmx/deliveries",
+			RedirectUrl: "/deliveries",
+			Permission:  store.PermissionManageDeliveries,
+		},
+		{
+			Id:          "tab-audit",
+			Title:       "Audit",
+			HtmxPath:    "/htmx/audit",
+			RedirectUrl: "/audit",
+			Permission:  store.PermissionViewAudit,
 		},
 		{
 			Id:          "tab-settings",
@@ -45,6 +108,26 @@ var (
 	}
 )
 
+// Gets the tabs the role has the permission to see, in order
+func permittedTabs(role string) []TabListEntry {
+	var tabs []TabListEntry
+	for _, tab := range tabListEntries {
+		if tab.Permission == "" || store.HasPermission(role, tab.Permission) {
+			tabs = append(tabs, tab)
+		}
+	}
+	return tabs
+}
+
+// Gets the page of the first tab the role can see, which is
+// DEFAULT_DASHBOARD_ROUTE for all but viewers
+func dashboardRoute(role string) string {
+	if store.HasPermission(role, store.PermissionTrack) {
+		return globals.DEFAULT_DASHBOARD_ROUTE
+	}
+	return permittedTabs(role)[0].RedirectUrl
+}
+
 // Handles templating for dashboard routes
 // NOTE: Only for internal use
 func (server *Server) dashboardHandler(writer http.ResponseWriter,
@@ -54,10 +137,11 @@ func (server *Server) dashboardHandler(writer http.ResponseWriter,
 			globals.BASE_TEMPLATE,
 			"./templates/htmx/dashboard.html",
 		))
+	to := server.getTOFromCookie(request)
 	tmpl.ExecuteTemplate(writer, "base", map[string]interface{}{
 		csrf.TemplateTag: csrf.TemplateField(request),
-		"to":             server.getTOFromCookie(request),
-		"tabListEntries": tabListEntries,
+		"to":             to,
+		"tabListEntries": permittedTabs(to.UserType),
 		"htmxPath":       tabListEntry.HtmxPath,
 		"redirectUrl":    tabListEntry.RedirectUrl,
 	})
@@ -74,11 +158,21 @@ func (server *Server) dashboardTrack(writer http.ResponseWriter,
 	})
 }
 
+// /shift
+func (server *Server) dashboardShift(writer http.ResponseWriter,
+	request *http.Request) {
+	server.dashboardHandler(writer, request, TabListEntry{
+		Id:          "tab-shift",
+		Title:       "Shift",
+		HtmxPath:    "/htmx/shift",
+		RedirectUrl: "/shift",
+	})
+}
+
 // /clients
 func (server *Server) dashboardClients(writer http.ResponseWriter,
 	request *http.Request) {
 	server.dashboardHandler(writer, request, TabListEntry{
-
 		Id:          "tab-clients",
 		Title:       "Clients",
 		HtmxPath:    "/htmx/clients",
@@ -86,19 +180,43 @@ func (server *Server) dashboardClients(writer http.ResponseWriter,
 	})
 }
 
-// /accounts
-// Only admins can see this page
-func (server *Server) dashboardAccounts(writer http.ResponseWriter,
+// /analytics
+func (server *Server) dashboardAnalytics(writer http.ResponseWriter,
 	request *http.Request) {
-	to := server.getTOFromCookie(request)
-	if to.UserType != "admin" {
-		writer.Header().Set("HX-Redirect",
-			globals.DEFAULT_DASHBOARD_ROUTE)
-		return
-	}
+	server.dashboardHandler(writer, request, TabListEntry{
+		Id:          "tab-analytics",
+		Title:       "Analytics",
+		HtmxPath:    "/htmx/analytics",
+		RedirectUrl: "/analytics",
+	})
+}
 
+// /reports
+func (server *Server) dashboardReports(writer http.ResponseWriter,
+	request *http.Request) {
 	server.dashboardHandler(writer, request, TabListEntry{
+		Id:          "tab-reports",
+		Title:       "Reports",
+		HtmxPath:    "/htmx/reports",
+		RedirectUrl: "/reports",
+	})
+}
 
+// /assignments
+func (server *Server) dashboardAssignments(writer http.ResponseWriter,
+	request *http.Request) {
+	server.dashboardHandler(writer, request, TabListEntry{
+		Id:          "tab-assignments",
+		Title:       "Assignments",
+		HtmxPath:    "/htmx/assignments",
+		RedirectUrl: "/assignments",
+	})
+}
+
+// /accounts
+func (server *Server) dashboardAccounts(writer http.ResponseWriter,
+	request *http.Request) {
+	server.dashboardHandler(writer, request, TabListEntry{
 		Id:          "tab-accounts",
 		Title:       "Accounts",
 		HtmxPath:    "/htmx/accounts",
@@ -106,6 +224,50 @@ func (server *Server) dashboardAccounts(writer http.ResponseWriter,
 	})
 }
 
+// /toilets
+func (server *Server) dashboardToilets(writer http.ResponseWriter,
+	request *http.Request) {
+	server.dashboardHandler(writer, request, TabListEntry{
+		Id:          "tab-toilets",
+		Title:       "Toilets",
+		HtmxPath:    "/htmx/toilets",
+		RedirectUrl: "/toilets",
+	})
+}
+
+// /api-keys
+func (server *Server) dashboardApiKeys(writer http.ResponseWriter,
+	request *http.Request) {
+	server.dashboardHandler(writer, request, TabListEntry{
+		Id:          "tab-api-keys",
+		Title:       "API keys",
+		HtmxPath:    "/htmx/api-keys",
+		RedirectUrl: "/api-keys",
+	})
+}
+
+// /deliveries
+func (server *Server) dashboardDeliveries(writer http.ResponseWriter,
+	request *http.Request) {
+	server.dashboardHandler(writer, request, TabListEntry{
+		Id:          "tab-deliveries",
+		Title:       "Deliveries",
+		HtmxPath:    "/htmx/deliveries",
+		RedirectUrl: "/deliveries",
+	})
+}
+
+// /audit
+func (server *Server) dashboardAudit(writer http.ResponseWriter,
+	request *http.Request) {
+	server.dashboardHandler(writer, request, TabListEntry{
+		Id:          "tab-audit",
+		Title:       "Audit",
+		HtmxPath:    "/htmx/audit",
+		RedirectUrl: "/audit",
+	})
+}
+
 // /settings
 func (server *Server) dashboardSettings(writer http.ResponseWriter,
 	request *http.Request) {
@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/genekkion/PottySenseShared/store"
+	"github.com/gorilla/csrf"
+)
+
+// Most deliveries listed at once
+const deliveriesListLimit = 100
+
+// A queued notification along with its recipient and
+// the csrf field for its forms
+type DeliveryEntry struct {
+	store.OutboundMessage
+	Recipient string
+	Title     string
+	Message   string
+	CsrfField template.HTML
+}
+
+// Fills in the details of the message shown in the list
+func newDeliveryEntry(message store.OutboundMessage,
+	usernames map[int]string, request *http.Request) DeliveryEntry {
+	entry := DeliveryEntry{
+		OutboundMessage: message,
+		Recipient:       "Fallback chat",
+		CsrfField:       csrf.TemplateField(request),
+	}
+	if message.ToId != 0 {
+		entry.Recipient = usernames[message.ToId]
+	}
+
+	var notification Notification
+	if json.Unmarshal([]byte(message.Payload), &notification) == nil {
+		entry.Title = notification.Title
+		entry.Message = notification.Message
+	}
+	return entry
+}
+
+// Gets the usernames of the TOs by id
+func (server *Server) getUsernames() (map[int]string, error) {
+	tos, err := server.store.TOfficers.List()
+	if err != nil {
+		return nil, err
+	}
+	usernames := make(map[int]string, len(tos))
+	for _, to := range tos {
+		usernames[to.Id] = to.Username
+	}
+	return usernames, nil
+}
+
+// /htmx/deliveries
+func (server *Server) htmxDeliveriesHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		server.htmxDeliveriesPanel(writer, request)
+	case http.MethodPost:
+		server.htmxDeliveriesList(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /htmx/deliveries "GET"
+func (server *Server) htmxDeliveriesPanel(writer http.ResponseWriter,
+	request *http.Request) {
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/deliveries.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"csrfToken":      csrf.Token(request),
+		"statuses": []string{
+			store.OutboundStatusDead,
+			store.OutboundStatusPending,
+			store.OutboundStatusSending,
+			store.OutboundStatusSent,
+		},
+	})
+}
+
+// /htmx/deliveries "POST"
+// Lists the latest deliveries with the status chosen,
+// failed deliveries by default
+func (server *Server) htmxDeliveriesList(writer http.ResponseWriter,
+	request *http.Request) {
+	status := request.FormValue("status")
+	if status == "all" {
+		status = ""
+	} else if status == "" {
+		status = store.OutboundStatusDead
+	}
+
+	messages, total, err := server.store.Outbound.Find(store.OutboundFilter{
+		Status: status,
+		Page:   store.Page{Limit: deliveriesListLimit},
+	})
+	if err != nil {
+		log.Println("htmxDeliveriesList() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	usernames, err := server.getUsernames()
+	if err != nil {
+		log.Println("htmxDeliveriesList() - db query usernames")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	var entries []DeliveryEntry
+	for _, message := range messages {
+		entries = append(entries, newDeliveryEntry(message, usernames, request))
+	}
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/deliveryEntry.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		"deliveries": entries,
+		"total":      total,
+		"hidden":     total - len(entries),
+	})
+}
+
+// /htmx/deliveries/resend "PUT"
+// Queues a failed delivery again, responding with the updated entry
+func (server *Server) htmxDeliveriesResend(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodPut {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+	messageId, _ := strconv.Atoi(request.FormValue("id"))
+	err := server.store.Outbound.Resend(messageId)
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusNotFound, map[string]string{
+			"error": "Delivery not found or not failed.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxDeliveriesResend() - db update")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	server.wakeOutbound()
+
+	message, err := server.store.Outbound.Get(messageId)
+	if err != nil {
+		log.Println("htmxDeliveriesResend() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	usernames, err := server.getUsernames()
+	if err != nil {
+		log.Println("htmxDeliveriesResend() - db query usernames")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/deliveryEntry.html"))
+	tmpl.ExecuteTemplate(writer, "deliveryRow",
+		newDeliveryEntry(message, usernames, request))
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/genekkion/PottySenseServer/internal/globals"
+	"github.com/gorilla/csrf"
+)
+
+// /htmx/reports "GET"
+// Form for choosing the clients and the period of a report
+func (server *Server) htmxReportsPanel(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+
+	clients, err := server.store.Clients.List()
+	if err != nil {
+		log.Println("htmxReportsPanel() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	today := time.Now()
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/reports.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"clients":        clients,
+		"from": today.AddDate(0, 0, 1-globals.REPORT_DEFAULT_DAYS).
+			Format(time.DateOnly),
+		"to": today.Format(time.DateOnly),
+	})
+}
+
+// /htmx/reports/export "GET"
+// Downloads the toileting report of the clients checked,
+// or of all clients if none, over the dates chosen
+func (server *Server) htmxReportsExport(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("htmxReportsExport() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	from, to, err := parseReportPeriod(request.FormValue("from"),
+		request.FormValue("to"), time.Now())
+	if err != nil {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Dates should be YYYY-MM-DD, with the start not after the end.",
+		})
+		return
+	}
+
+	clients, err := getReportClients(server.store, formIds(request, "clientIds"))
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusNotFound, map[string]string{
+			"error": "Client not found.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxReportsExport() - db query clients")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	file, err := newToiletingReport(server.store, clients, from, to)
+	if err != nil {
+		log.Println("htmxReportsExport() - write spreadsheet")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	defer file.Close()
+
+	writer.Header().Set("Content-Type",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	writer.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="toileting-report-%s-%s.xlsx"`,
+			from.Format(time.DateOnly), to.AddDate(0, 0, -1).Format(time.DateOnly)))
+	err = file.Write(writer)
+	if err != nil {
+		log.Println("htmxReportsExport() - send spreadsheet")
+		log.Println(err)
+	}
+}
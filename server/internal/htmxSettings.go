@@ -4,10 +4,13 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/mail"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/genekkion/PottySenseServer/internal/utils"
+	"github.com/genekkion/PottySenseShared/store"
 	"github.com/gorilla/csrf"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -30,14 +33,7 @@ func (server *Server) htmxSettingsPanel(writer http.ResponseWriter,
 	request *http.Request) {
 	to := server.getTOFromCookie(request)
 
-	err := server.db.QueryRow(
-		`SELECT first_name, last_name
-        FROM TOfficers
-		WHERE ID = $1
-		`, to.Id).Scan(
-		&to.FirstName,
-		&to.LastName,
-	)
+	account, err := server.store.TOfficers.Get(to.Id)
 
 	// TODO: Change settings form
 	// to only get change of tele
@@ -54,7 +50,8 @@ func (server *Server) htmxSettingsPanel(writer http.ResponseWriter,
 	tmpl.Execute(writer, map[string]interface{}{
 		csrf.TemplateTag: csrf.TemplateField(request),
 		"csrfToken":      csrf.Token(request),
-		"account":        to,
+		"account":        account,
+		"canEditName":    store.HasPermission(to.UserType, store.PermissionManageAccounts),
 	})
 }
 
@@ -66,14 +63,23 @@ func (server *Server) htmxSettingsDetailsSave(writer http.ResponseWriter,
 	firstName := request.FormValue("firstName")
 	lastName := request.FormValue("lastName")
 
-	if firstName != "" || lastName != "" {
-		_, err := server.db.Exec(
-			`UPDATE TOfficers SET
-				first_name = $1,
-				last_name = $2
-			WHERE id = $3
-			`, firstName, lastName, to.Id)
+	// Only TOs who manage accounts can change names
+	if (firstName != "" || lastName != "") &&
+		!store.HasPermission(to.UserType, store.PermissionManageAccounts) {
+		genericForbiddenReply(writer)
+		return
+	}
 
+	if firstName != "" || lastName != "" {
+		before, err := server.store.TOfficers.Get(to.Id)
+		if err != nil {
+			log.Println("htmxSettingsDetailsSave(), db query")
+			log.Println(err)
+			genericInternalServerErrorReply(writer)
+			return
+		}
+		err = server.store.TOfficers.UpdateName(to.Id,
+			firstName, lastName)
 		if err != nil {
 			log.Println("htmxSettingsDetailsSave(), db update")
 			log.Println(err)
@@ -81,6 +87,10 @@ func (server *Server) htmxSettingsDetailsSave(writer http.ResponseWriter,
 			return
 		}
 		log.Println("updated names")
+		server.recordAudit(webActor(to), store.AuditActionUpdate,
+			store.AuditTargetAccount, to.Id,
+			map[string]string{"firstName": before.FirstName, "lastName": before.LastName},
+			map[string]string{"firstName": firstName, "lastName": lastName})
 	}
 
 	if request.FormValue("telegram") != "" {
@@ -144,14 +154,7 @@ func (server *Server) htmxSettingsPasswordChange(writer http.ResponseWriter,
 	oldPassword := request.FormValue("oldPassword")
 	newPassword := utils.SaltPassword(request.FormValue("newPassword"))
 
-	var passwordHash string
-	err := server.db.QueryRow(
-		`SELECT password
-		FROM TOfficers
-		WHERE id = $1
-		`, to.Id).Scan(
-		&passwordHash,
-	)
+	passwordHash, err := server.store.TOfficers.GetPasswordHash(to.Id)
 	if err != nil {
 		log.Println("htmxSettingsPasswordChange(), db query")
 		log.Println(err)
@@ -179,16 +182,18 @@ func (server *Server) htmxSettingsPasswordChange(writer http.ResponseWriter,
 		bcrypt.DefaultCost,
 	)
 
-	_, err = server.db.Exec(
-		`UPDATE TOfficers SET
-        password = $1
-        WHERE id = $2`,
-		newPasswordHash, to.Id)
+	err = server.store.TOfficers.SetPasswordHash(to.Id,
+		string(newPasswordHash))
 
 	status := "ok"
 	if err != nil {
 
 		status = "error"
+	} else {
+		// Only that the password changed, never the hash
+		server.recordAudit(webActor(to), store.AuditActionUpdate,
+			store.AuditTargetAccount, to.Id, nil,
+			map[string]string{"password": "changed"})
 	}
 
 	tmpl.Execute(writer, map[string]interface{}{
@@ -196,3 +201,181 @@ func (server *Server) htmxSettingsPasswordChange(writer http.ResponseWriter,
 		"status":         status,
 	})
 }
+
+// A channel shown in the notifications section of the Settings tab
+type NotificationChannelEntry struct {
+	store.NotificationChannel
+	Label       string
+	InputType   string
+	Placeholder string
+}
+
+// Details of each channel, other than telegram which
+// uses the chat id registered with the bot
+var notificationChannelInputs = map[string]NotificationChannelEntry{
+	store.ChannelTelegram: {Label: "Telegram"},
+	store.ChannelEmail: {
+		Label:       "Email",
+		InputType:   "email",
+		Placeholder: "Email address",
+	},
+	store.ChannelWebhook: {
+		Label:       "Webhook",
+		InputType:   "url",
+		Placeholder: "https://...",
+	},
+	store.ChannelNtfy: {
+		Label:       "ntfy",
+		InputType:   "text",
+		Placeholder: "Topic",
+	},
+}
+
+var ntfyTopicRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// /htmx/settings/notifications
+func (server *Server) htmxSettingsNotificationsHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		server.htmxSettingsNotificationsForm(writer, request, "")
+	case http.MethodPut:
+		server.htmxSettingsNotificationsSave(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /htmx/settings/notifications "GET"
+// Lists the channels configured on the server, along
+// with whether the TO is notified through them
+func (server *Server) htmxSettingsNotificationsForm(writer http.ResponseWriter,
+	request *http.Request, status string) {
+	to := server.getTOFromCookie(request)
+
+	account, err := server.store.TOfficers.Get(to.Id)
+	if err != nil {
+		log.Println("htmxSettingsNotificationsForm() - db query account")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	chosen, err := server.store.NotificationChannels.ListByTO(to.Id)
+	if err != nil {
+		log.Println("htmxSettingsNotificationsForm() - db query channels")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	chosenByName := map[string]store.NotificationChannel{}
+	for _, channel := range chosen {
+		chosenByName[channel.Channel] = channel
+	}
+
+	var entries []NotificationChannelEntry
+	for _, name := range store.NotificationChannelNames {
+		if _, ok := server.notifiers[name]; !ok {
+			continue
+		}
+		entry := notificationChannelInputs[name]
+		entry.NotificationChannel = store.NotificationChannel{
+			ToId:    to.Id,
+			Channel: name,
+			// Only telegram is used until the TO chooses
+			Enabled: len(chosen) == 0 && name == store.ChannelTelegram,
+		}
+		if channel, ok := chosenByName[name]; ok {
+			entry.NotificationChannel = channel
+		}
+		entries = append(entries, entry)
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/settingsNotifications.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag:     csrf.TemplateField(request),
+		"channels":           entries,
+		"telegramRegistered": account.TelegramChatId != "",
+		"status":             status,
+	})
+}
+
+// /htmx/settings/notifications "PUT"
+// Addresses are kept even when their channel is turned off
+func (server *Server) htmxSettingsNotificationsSave(writer http.ResponseWriter,
+	request *http.Request) {
+	to := server.getTOFromCookie(request)
+
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("htmxSettingsNotificationsSave() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	var channels []store.NotificationChannel
+	for _, name := range store.NotificationChannelNames {
+		if _, ok := server.notifiers[name]; !ok {
+			continue
+		}
+		channel := store.NotificationChannel{
+			ToId:    to.Id,
+			Channel: name,
+			Address: strings.TrimSpace(request.FormValue(name + "Address")),
+			Enabled: request.FormValue(name) == "true",
+		}
+		if !isValidChannelAddress(channel) {
+			server.htmxSettingsNotificationsForm(writer, request, "invalid")
+			return
+		}
+		channels = append(channels, channel)
+	}
+
+	tx, err := server.db.Begin()
+	if err != nil {
+		log.Println("htmxSettingsNotificationsSave() - begin tx")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	defer tx.Rollback()
+	txStore := store.New(tx)
+	for _, channel := range channels {
+		err = txStore.NotificationChannels.Set(channel)
+		if err != nil {
+			log.Println("htmxSettingsNotificationsSave() - db update")
+			log.Println(err)
+			genericInternalServerErrorReply(writer)
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println("htmxSettingsNotificationsSave() - commit tx")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	server.recordAudit(webActor(to), store.AuditActionUpdate,
+		store.AuditTargetAccount, to.Id, nil,
+		map[string]any{"notificationChannels": channels})
+	server.htmxSettingsNotificationsForm(writer, request, "ok")
+}
+
+// Whether the address suits the channel. Channels which are
+// turned off may be left without an address.
+func isValidChannelAddress(channel store.NotificationChannel) bool {
+	if channel.Address == "" {
+		return !channel.Enabled || channel.Channel == store.ChannelTelegram
+	}
+	switch channel.Channel {
+	case store.ChannelEmail:
+		address, err := mail.ParseAddress(channel.Address)
+		return err == nil && address.Address == channel.Address
+	case store.ChannelWebhook:
+		return isValidToiletUrl(channel.Address) && isPublicUrl(channel.Address)
+	case store.ChannelNtfy:
+		return ntfyTopicRegex.MatchString(channel.Address)
+	}
+	return true
+}
@@ -0,0 +1,325 @@
+package internal
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/genekkion/PottySenseServer/internal/globals"
+	"github.com/genekkion/PottySenseShared/store"
+	"github.com/gorilla/csrf"
+)
+
+// Parses the planned end of a shift, HH:MM in local time,
+// as the next time of the day after now
+func parseShiftEnd(value string, now time.Time) (time.Time, error) {
+	parsed, err := time.ParseInLocation("15:04", value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	local := now.In(time.Local)
+	endsAt := time.Date(local.Year(), local.Month(), local.Day(),
+		parsed.Hour(), parsed.Minute(), 0, 0, time.Local)
+	if !endsAt.After(now) {
+		endsAt = endsAt.AddDate(0, 0, 1)
+	}
+	return endsAt, nil
+}
+
+// /htmx/shift
+func (server *Server) htmxShiftHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		server.htmxShiftPanel(writer, request, "")
+	case http.MethodPost:
+		server.htmxShiftStart(writer, request)
+	case http.MethodDelete:
+		server.htmxShiftEnd(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /htmx/shift "GET"
+// Shows the shift of the TO along with the other TOs on
+// shift, who the tracked clients can be handed over to
+func (server *Server) htmxShiftPanel(writer http.ResponseWriter,
+	request *http.Request, message string) {
+	to := server.getTOFromCookie(request)
+
+	shifts, err := server.store.Shifts.ListActive()
+	if err != nil {
+		log.Println("htmxShiftPanel() - db query shifts")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	clientIds, err := server.store.Track.ListClientIds(to.Id)
+	if err != nil {
+		log.Println("htmxShiftPanel() - db query clients")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	var shift *store.Shift
+	var others []store.Shift
+	for i := range shifts {
+		if shifts[i].ToId == to.Id {
+			shift = &shifts[i]
+		} else {
+			others = append(others, shifts[i])
+		}
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/shift.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"csrfToken":      csrf.Token(request),
+		"shift":          shift,
+		"others":         others,
+		"tracked":        len(clientIds),
+		"defaultEnd": time.Now().Add(globals.SHIFT_DEFAULT_LENGTH * time.Hour).
+			Format("15:04"),
+		"message": message,
+	})
+}
+
+// /htmx/shift "POST"
+// Starts a shift for the TO until "endsAt"
+func (server *Server) htmxShiftStart(writer http.ResponseWriter,
+	request *http.Request) {
+	to := server.getTOFromCookie(request)
+	now := time.Now()
+
+	endsAt, err := parseShiftEnd(request.FormValue("endsAt"), now)
+	if err != nil {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "The end of the shift should be HH:MM.",
+		})
+		return
+	}
+
+	tx, err := server.db.Begin()
+	if err != nil {
+		log.Println("htmxShiftStart() - begin transaction")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	defer tx.Rollback()
+	txStore := store.New(tx)
+
+	shiftId, err := txStore.Shifts.Start(to.Id, now, endsAt)
+	if err == store.ErrOnShift {
+		writeJson(writer, http.StatusConflict, map[string]string{
+			"error": "You are already on shift.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxShiftStart() - db insert")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println("htmxShiftStart() - commit transaction")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	server.recordAudit(webActor(to), store.AuditActionStart, store.AuditTargetShift,
+		shiftId, nil, map[string]time.Time{"endsAt": endsAt})
+
+	server.htmxShiftPanel(writer, request, "")
+}
+
+// /htmx/shift "DELETE"
+// Ends the shift of the TO, who stops tracking their clients
+func (server *Server) htmxShiftEnd(writer http.ResponseWriter,
+	request *http.Request) {
+	to := server.getTOFromCookie(request)
+
+	shift, err := server.store.Shifts.GetActive(to.Id)
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusConflict, map[string]string{
+			"error": "You are not on shift.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxShiftEnd() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	clientIds, err := server.store.Track.ListClientIds(to.Id)
+	if err != nil {
+		log.Println("htmxShiftEnd() - db query clients")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	err = server.store.Shifts.End(shift.Id, time.Now(), 0)
+	if err != nil {
+		log.Println("htmxShiftEnd() - db update")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	server.recordAudit(webActor(to), store.AuditActionFinish, store.AuditTargetShift,
+		shift.Id, nil, nil)
+	for _, clientId := range clientIds {
+		server.recordAudit(webActor(to), store.AuditActionUntrack, store.AuditTargetClient,
+			clientId, nil, map[string]int{"toId": to.Id})
+	}
+	server.publishTrackingChanged(to.Id)
+
+	server.htmxShiftPanel(writer, request, "")
+}
+
+// /htmx/shift/handover "POST"
+// Hands the clients tracked by the TO over to "toId", who
+// must be on shift, and ends the shift of the TO. The
+// incoming TO is sent a summary of the clients.
+func (server *Server) htmxShiftHandover(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodPost {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
+	to := server.getTOFromCookie(request)
+
+	shift, err := server.store.Shifts.GetActive(to.Id)
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusConflict, map[string]string{
+			"error": "You are not on shift.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxShiftHandover() - db query shift")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	incomingId, _ := strconv.Atoi(request.FormValue("toId"))
+	if incomingId == to.Id {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Please choose another officer.",
+		})
+		return
+	}
+	incoming, err := server.store.TOfficers.Get(incomingId)
+	if err == sql.ErrNoRows ||
+		(err == nil && !store.HasPermission(incoming.UserType, store.PermissionTrack)) {
+		writeJson(writer, http.StatusNotFound, map[string]string{
+			"error": "Officer not found or cannot track clients.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxShiftHandover() - db query officer")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	_, err = server.store.Shifts.GetActive(incoming.Id)
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusConflict, map[string]string{
+			"error": incoming.Username + " is not on shift.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxShiftHandover() - db query incoming shift")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	tx, err := server.db.Begin()
+	if err != nil {
+		log.Println("htmxShiftHandover() - begin transaction")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	defer tx.Rollback()
+	txStore := store.New(tx)
+
+	clientIds, err := txStore.Track.Transfer(to.Id, incoming.Id)
+	if err != nil {
+		log.Println("htmxShiftHandover() - db transfer")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	err = txStore.Shifts.End(shift.Id, time.Now(), incoming.Id)
+	if err != nil {
+		log.Println("htmxShiftHandover() - db update")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println("htmxShiftHandover() - commit transaction")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	server.recordAudit(webActor(to), store.AuditActionFinish, store.AuditTargetShift,
+		shift.Id, nil, map[string]int{"handoverToId": incoming.Id})
+	for _, clientId := range clientIds {
+		server.recordAudit(webActor(to), store.AuditActionUntrack, store.AuditTargetClient,
+			clientId, nil, map[string]int{"toId": to.Id})
+		server.recordAudit(webActor(to), store.AuditActionTrack, store.AuditTargetClient,
+			clientId, nil, map[string]int{"toId": incoming.Id})
+	}
+	server.publishTrackingChanged(to.Id, incoming.Id)
+
+	server.sendHandover(to.Username, incoming, clientIds)
+	server.htmxShiftPanel(writer, request,
+		"Handed over "+strconv.Itoa(len(clientIds))+" clients to "+incoming.Username+".")
+}
+
+// Sends the summary of the clients handed over to the incoming TO
+func (server *Server) sendHandover(from string, incoming TO, clientIds []int) {
+	var clients []store.TrackedClient
+	for _, clientId := range clientIds {
+		client, err := server.store.Track.GetClient(clientId)
+		if err != nil {
+			log.Println("sendHandover() - db query client")
+			log.Println(err)
+			continue
+		}
+		clients = append(clients, client)
+	}
+
+	alerts, err := server.store.Alerts.ListUnacknowledged()
+	if err != nil {
+		log.Println("sendHandover() - db query alerts")
+		log.Println(err)
+	}
+	handedOver := make(map[int]bool, len(clientIds))
+	for _, clientId := range clientIds {
+		handedOver[clientId] = true
+	}
+	var openAlerts []store.Alert
+	for _, alert := range alerts {
+		if handedOver[alert.ClientId] {
+			openAlerts = append(openAlerts, alert)
+		}
+	}
+
+	server.notifyTOs([]TO{incoming}, Notification{
+		Type:    "notification",
+		Title:   "Handover from " + from,
+		Message: formatHandover(from, clients, openAlerts),
+	})
+}
@@ -0,0 +1,282 @@
+package internal
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/genekkion/PottySenseShared/store"
+	"github.com/gorilla/csrf"
+)
+
+// /htmx/toilets
+func (server *Server) htmxToiletsHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		server.htmxToiletsPanel(writer, request)
+	case http.MethodPost:
+		server.htmxToiletsSearch(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /htmx/toilets "GET"
+func (server *Server) htmxToiletsPanel(writer http.ResponseWriter,
+	request *http.Request) {
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/toilets.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"csrfToken":      csrf.Token(request),
+	})
+}
+
+// /htmx/toilets "POST"
+func (server *Server) htmxToiletsSearch(writer http.ResponseWriter,
+	request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("htmxToiletsSearch() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	toilets, err := server.store.Toilets.Search(request.FormValue("search"))
+	if err != nil {
+		log.Println("htmxToiletsSearch() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/toiletEntry.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"toilets":        toilets,
+	})
+}
+
+// /htmx/toilets/new
+func (server *Server) htmxToiletsNewHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		server.htmxToiletNewModal(writer, request)
+	case http.MethodPost:
+		server.htmxToiletNewSave(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /htmx/toilets/new "GET"
+func (server *Server) htmxToiletNewModal(writer http.ResponseWriter,
+	request *http.Request) {
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/toiletNewModal.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+	})
+}
+
+// /htmx/toilets/new "POST"
+func (server *Server) htmxToiletNewSave(writer http.ResponseWriter,
+	request *http.Request) {
+	to := server.getTOFromCookie(request)
+
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("htmxToiletNewSave() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	toilet := Toilet{
+		Name:     strings.TrimSpace(request.FormValue("name")),
+		Location: strings.TrimSpace(request.FormValue("location")),
+		BaseUrl:  strings.TrimRight(strings.TrimSpace(request.FormValue("baseUrl")), "/"),
+		Secret:   request.FormValue("secret"),
+	}
+	if toilet.Name == "" || toilet.Secret == "" || !isValidToiletUrl(toilet.BaseUrl) {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Invalid toilet details.",
+		})
+		return
+	}
+
+	toilet.Id, err = server.store.Toilets.Create(toilet)
+	if err != nil {
+		log.Println("htmxToiletNewSave() - db insert")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	server.recordAudit(webActor(to), store.AuditActionCreate,
+		store.AuditTargetToilet, toilet.Id, nil, toilet)
+
+	writer.Header().Set("HX-Trigger", "newToilet")
+}
+
+// /htmx/toilets/edit
+func (server *Server) htmxToiletsEditHandler(writer http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodPost:
+		server.htmxToiletEditModal(writer, request)
+	case http.MethodPut:
+		server.htmxToiletEditSave(writer, request)
+	case http.MethodDelete:
+		server.htmxToiletDelete(writer, request)
+	default:
+		genericMethodNotAllowedReply(writer)
+	}
+}
+
+// /htmx/toilets/edit "POST"
+func (server *Server) htmxToiletEditModal(writer http.ResponseWriter,
+	request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("htmxToiletEditModal() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	toiletId, _ := strconv.Atoi(request.FormValue("id"))
+	toilet, err := server.store.Toilets.Get(toiletId)
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusNotFound, map[string]string{
+			"error": "Toilet not found.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxToiletEditModal() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/toiletEditModal.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"toilet":         toilet,
+	})
+}
+
+// /htmx/toilets/edit "PUT"
+// Leaving the secret empty keeps the current secret
+func (server *Server) htmxToiletEditSave(writer http.ResponseWriter,
+	request *http.Request) {
+	to := server.getTOFromCookie(request)
+
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("htmxToiletEditSave() - parse form")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	toiletId, _ := strconv.Atoi(request.FormValue("id"))
+	toilet := Toilet{
+		Id:       toiletId,
+		Name:     strings.TrimSpace(request.FormValue("name")),
+		Location: strings.TrimSpace(request.FormValue("location")),
+		BaseUrl:  strings.TrimRight(strings.TrimSpace(request.FormValue("baseUrl")), "/"),
+		Secret:   request.FormValue("secret"),
+	}
+	if toilet.Name == "" || !isValidToiletUrl(toilet.BaseUrl) {
+		writeJson(writer, http.StatusBadRequest, map[string]string{
+			"error": "Invalid toilet details.",
+		})
+		return
+	}
+
+	before, err := server.store.Toilets.Get(toiletId)
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusNotFound, map[string]string{
+			"error": "Toilet not found.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxToiletEditSave() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	err = server.store.Toilets.Update(toilet)
+	if err == sql.ErrNoRows {
+		writeJson(writer, http.StatusNotFound, map[string]string{
+			"error": "Toilet not found.",
+		})
+		return
+	} else if err != nil {
+		log.Println("htmxToiletEditSave() - db update")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+	server.recordAudit(webActor(to), store.AuditActionUpdate,
+		store.AuditTargetToilet, toilet.Id, before, toilet)
+
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/toiletEntrySingle.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"Id":             toilet.Id,
+		"Name":           toilet.Name,
+		"Location":       toilet.Location,
+		"BaseUrl":        toilet.BaseUrl,
+	})
+}
+
+// /htmx/toilets/edit "DELETE"
+// Responds with nothing so that the entry is removed.
+// Toilets with an active session cannot be removed.
+func (server *Server) htmxToiletDelete(writer http.ResponseWriter,
+	request *http.Request) {
+	to := server.getTOFromCookie(request)
+
+	toiletId, _ := strconv.Atoi(request.FormValue("id"))
+	before, err := server.store.Toilets.Get(toiletId)
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		log.Println("htmxToiletDelete() - db query")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	err = server.store.Toilets.Delete(toiletId)
+	if err == store.ErrToiletInUse {
+		writeJson(writer, http.StatusConflict, map[string]string{
+			"error": "Toilet is currently in use.",
+		})
+	} else if err != nil && err != sql.ErrNoRows {
+		log.Println("htmxToiletDelete() - db delete")
+		log.Println(err)
+		genericInternalServerErrorReply(writer)
+	} else if err == nil {
+		server.recordAudit(webActor(to), store.AuditActionDelete,
+			store.AuditTargetToilet, toiletId, before, nil)
+	}
+}
+
+// Base url of the toilet needs to be an absolute
+// http(s) url, e.g. http://192.168.1.10:5000
+func isValidToiletUrl(baseUrl string) bool {
+	parsedUrl, err := url.ParseRequestURI(baseUrl)
+	if err != nil {
+		return false
+	}
+	return (parsedUrl.Scheme == "http" || parsedUrl.Scheme == "https") &&
+		parsedUrl.Host != ""
+}
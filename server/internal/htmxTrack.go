@@ -1,13 +1,16 @@
 package internal
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/genekkion/PottySenseServer/internal/globals"
-	"github.com/genekkion/PottySenseServer/internal/utils"
+	"github.com/genekkion/PottySenseShared/store"
 	"github.com/gorilla/csrf"
 )
 
@@ -42,16 +45,7 @@ func (server *Server) htmxTrackingLoad(writer http.ResponseWriter,
 	request *http.Request) {
 	to := server.getTOFromCookie(request)
 
-	rows, err := server.db.Query(
-		`SELECT Clients.id, Clients.first_name,
-			Clients.last_name, Clients.gender,
-			Clients.urination, Clients.defecation,
-			Clients.last_record
-        FROM Track
-		INNER JOIN Clients
-        	ON Track.client_id = Clients.id
-        WHERE Track.to_id = $1`,
-		to.Id)
+	clients, err := server.store.Track.ListClients(to.Id)
 	if err != nil {
 		log.Println("htmxTrackingLoad() - db query")
 		log.Println(err)
@@ -59,33 +53,225 @@ func (server *Server) htmxTrackingLoad(writer http.ResponseWriter,
 		return
 	}
 
-	var clients []Client
+	var entries []TrackEntry
+	for _, client := range clients {
+		entry := newTrackEntry(client)
+		entry.Alert = server.getClientAlert(request.Context(), client.Id)
+		entries = append(entries, entry)
+	}
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/trackEntry.html"))
+	tmpl.Execute(writer, map[string]interface{}{
+		csrf.TemplateTag: csrf.TemplateField(request),
+		"entries":        entries,
+	})
+}
+
+// A tracked client along with their active session
+type TrackEntry struct {
+	Client Client
+	// Description of the active session, if any
+	Session string
+	// Latest message sent to TOs about the client, if any
+	Alert template.HTML
+}
+
+// Describes the active session of the tracked client
+func newTrackEntry(client store.TrackedClient) TrackEntry {
+	session := "nil"
+	if client.SessionPhase != 0 {
+		session = toiletSessionPhasePretty(client.SessionPhase) +
+			" (" + client.ToiletName + ")"
+		if client.Responder != "" {
+			session += ", " + client.Responder + " on the way"
+		}
+	}
+
+	return TrackEntry{
+		Client:  newClient(client.Client),
+		Session: session,
+	}
+}
+
+// /htmx/track/stream "GET"
+// Server-sent events for the clients tracked by the TO.
+// Events are named "client-<id>" when the row of the client
+// changes, and "alert-<id>" when a message is sent about the
+// client, matching the sse-swap attributes in trackEntry.html
+func (server *Server) htmxTrackingStream(writer http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		genericMethodNotAllowedReply(writer)
+		return
+	}
 
-	for rows.Next() {
-		var client Client
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		log.Println("htmxTrackingStream() - streaming unsupported")
+		genericInternalServerErrorReply(writer)
+		return
+	}
+
+	to := server.getTOFromCookie(request)
+	channel := server.events.subscribe(to.Id)
+	defer server.events.unsubscribe(to.Id, channel)
 
-		rows.Scan(
-			&client.Id,
-			&client.FirstName,
-			&client.LastName,
-			&client.Gender,
-			&client.Urination,
-			&client.Defecation,
-			&client.LastRecord,
-		)
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
 
-		if time.Since(client.LastRecord).Hours() < globals.LAST_RECORD_THRESHOLD {
-			client.PrettyLastRecord = utils.GetTimeElapsedPretty(client.LastRecord)
-		} else {
-			client.PrettyLastRecord = "nil"
+	// Keeps the connection from being closed by proxies
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(writer, ": heartbeat\n\n")
+		case event := <-channel:
+			fmt.Fprintf(writer, "event: %s\n", event.Name)
+			for _, line := range strings.Split(event.Data, "\n") {
+				fmt.Fprintf(writer, "data: %s\n", line)
+			}
+			fmt.Fprint(writer, "\n")
 		}
+		flusher.Flush()
+	}
+}
+
+// Gets the ids of the TOs tracking a particular client
+func (server *Server) getAllTOIdsTracking(clientId int) []int {
+	tos, err := server.store.Track.ListTOs(clientId)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	var toIds []int
+	for _, to := range tos {
+		toIds = append(toIds, to.Id)
+	}
+	return toIds
+}
+
+// Gets the TOs tracking the client who have the
+// Track tab open, i.e. have an open stream
+func (server *Server) getSubscribedTOIds(clientId int) []int {
+	var toIds []int
+	for _, toId := range server.getAllTOIdsTracking(clientId) {
+		if server.events.hasSubscribers(toId) {
+			toIds = append(toIds, toId)
+		}
+	}
+	return toIds
+}
 
-		clients = append(clients, client)
+// Pushes the latest row of the client to the Track
+// tab of all TOs tracking the client
+func (server *Server) publishClientUpdate(clientId int) {
+	toIds := server.getSubscribedTOIds(clientId)
+	if len(toIds) == 0 {
+		return
+	}
+
+	client, err := server.store.Track.GetClient(clientId)
+	if err != nil {
+		log.Println("publishClientUpdate() - db query")
+		log.Println(err)
+		return
 	}
+	entry := newTrackEntry(client)
+	entry.Alert = server.getClientAlert(context.Background(), clientId)
+
+	var buffer bytes.Buffer
 	tmpl := template.Must(template.ParseFiles("./templates/htmx/trackEntry.html"))
-	tmpl.Execute(writer, map[string]interface{}{
-		csrf.TemplateTag: csrf.TemplateField(request),
-		"clients":        clients,
+	err = tmpl.ExecuteTemplate(&buffer, "trackRow", entry)
+	if err != nil {
+		log.Println("publishClientUpdate() - execute template")
+		log.Println(err)
+		return
+	}
+
+	event := serverEvent{
+		Name: "client-" + fmt.Sprint(clientId),
+		Data: buffer.String(),
+	}
+	for _, toId := range toIds {
+		server.events.publish(toId, event)
+	}
+}
+
+// Name of the event telling the Track tab to reload its list
+const trackingChangedEvent = "tracking"
+
+// Reloads the Track tab of the TOs whose tracked clients were
+// added or removed, e.g. on handover. The events of a client
+// only swap its existing row, so cannot add or remove rows.
+func (server *Server) publishTrackingChanged(toIds ...int) {
+	published := make(map[int]bool, len(toIds))
+	for _, toId := range toIds {
+		if !published[toId] {
+			published[toId] = true
+			server.events.publish(toId, serverEvent{
+				Name: trackingChangedEvent,
+				Data: "changed",
+			})
+		}
+	}
+}
+
+// Pushes a message sent about the client to the Track tab
+// of all TOs tracking the client. The message is also kept
+// for an hour so that it is shown when the tab is reloaded.
+func (server *Server) publishClientAlert(clientId int,
+	messageType string, message string) {
+	var buffer bytes.Buffer
+	tmpl := template.Must(template.ParseFiles("./templates/htmx/trackEntry.html"))
+	err := tmpl.ExecuteTemplate(&buffer, "trackAlert", map[string]string{
+		"time":        time.Now().Format("15:04"),
+		"messageType": messageType,
+		"message":     message,
 	})
+	if err != nil {
+		log.Println("publishClientAlert() - execute template")
+		log.Println(err)
+		return
+	}
+	alert := buffer.String()
+
+	err = server.redisStorage.Set(
+		context.Background(),
+		"alert-"+fmt.Sprint(clientId),
+		alert,
+		time.Hour,
+	).Err()
+	if err != nil {
+		log.Println("publishClientAlert() - set redis")
+		log.Println(err)
+	}
+
+	event := serverEvent{
+		Name: "alert-" + fmt.Sprint(clientId),
+		Data: alert,
+	}
+	for _, toId := range server.getSubscribedTOIds(clientId) {
+		server.events.publish(toId, event)
+	}
 }
 
+// Gets the latest message sent about the client, as
+// rendered by publishClientAlert
+func (server *Server) getClientAlert(ctx context.Context, clientId int) template.HTML {
+	alert, err := server.redisStorage.Get(
+		ctx,
+		"alert-"+fmt.Sprint(clientId),
+	).Result()
+	if err != nil {
+		return ""
+	}
+	// Safe since it was rendered from the template
+	return template.HTML(alert)
+}
@@ -0,0 +1,448 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/genekkion/PottySenseShared/store"
+)
+
+// A message sent to the TOs, in plain text
+type Notification struct {
+	ClientId int
+	// alert, escalated, notification, complete, reminder
+	// or overdue, anything else is a regular message
+	Type     string
+	Title    string
+	Message  string
+	IsSilent bool
+	// Alert to acknowledge, 0 if not an alert
+	AlertId int
+}
+
+// Sends notifications through a channel, to the address
+// chosen by the TO, e.g. the chat id or email address
+type Notifier interface {
+	Send(address string, notification Notification) error
+}
+
+var notificationTimeout = 10 * time.Second
+
+// Creates the notifiers of the channels which are configured.
+// Telegram and webhooks are always available, email needs
+// SMTP_ADDR and ntfy needs NTFY_URL.
+func newNotifiers(alerts *store.AlertRepository) map[string]Notifier {
+	notifiers := map[string]Notifier{
+		store.ChannelTelegram: &telegramNotifier{
+			apiUrl: "https://api.telegram.org/bot" + os.Getenv("TELEGRAM_BOT_TOKEN"),
+			alerts: alerts,
+			client: &http.Client{Timeout: notificationTimeout},
+		},
+		store.ChannelWebhook: &webhookNotifier{
+			client: newPublicHttpClient(),
+		},
+	}
+
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		email := &emailNotifier{
+			addr: smtpAddr,
+			from: os.Getenv("SMTP_FROM"),
+		}
+		if username := os.Getenv("SMTP_USERNAME"); username != "" {
+			host := strings.Split(smtpAddr, ":")[0]
+			email.auth = smtp.PlainAuth("", username,
+				os.Getenv("SMTP_PASSWORD"), host)
+		}
+		notifiers[store.ChannelEmail] = email
+	}
+	if ntfyUrl := os.Getenv("NTFY_URL"); ntfyUrl != "" {
+		notifiers[store.ChannelNtfy] = &ntfyNotifier{
+			baseUrl: strings.TrimRight(ntfyUrl, "/"),
+			token:   os.Getenv("NTFY_TOKEN"),
+			client:  &http.Client{Timeout: notificationTimeout},
+		}
+	}
+	return notifiers
+}
+
+// Sends messages through the telegram bot api
+type telegramNotifier struct {
+	// Ends with the bot token, without the method
+	apiUrl string
+	alerts *store.AlertRepository
+	client *http.Client
+}
+
+// Reply of the telegram bot api to sendMessage
+type teleSendReply struct {
+	Ok          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Result      struct {
+		MessageId int `json:"message_id"`
+	} `json:"result"`
+	Parameters struct {
+		// Seconds to wait when rate limited
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// Headers of the telegram messages of each type
+var telegramHeaders = map[string]string{
+	"alert":        "⚠️ <b>%s</b> ⚠️\n",
+	"escalated":    "🚨 <b>%s</b> 🚨\n",
+	"notification": "🔔 <b>%s</b> 🔔\n",
+	"complete":     "✅ <b>%s</b> ✅\n",
+	"reminder":     "⏰ <b>%s</b> ⏰\n",
+	"overdue":      "⏰ <b>%s</b> ⏰\n",
+}
+
+// Sends the notification to the chat. Alerts are sent with
+// a button to acknowledge them, and recorded so that the bot
+// can update the message once the alert is acknowledged.
+func (notifier *telegramNotifier) Send(chatId string,
+	notification Notification) error {
+	text := ""
+	if header, ok := telegramHeaders[notification.Type]; ok && notification.Title != "" {
+		text = fmt.Sprintf(header, html.EscapeString(notification.Title))
+	}
+	text += html.EscapeString(notification.Message)
+
+	message := map[string]interface{}{
+		"chat_id":              chatId,
+		"text":                 text,
+		"parse_mode":           "HTML",
+		"disable_notification": notification.IsSilent,
+	}
+	if notification.AlertId != 0 {
+		message["reply_markup"] = map[string]interface{}{
+			"inline_keyboard": alertKeyboard(notification.AlertId),
+		}
+	}
+
+	messageId, err := notifier.sendMessage(message)
+	if err != nil || notification.AlertId == 0 {
+		return err
+	}
+	// The message was sent, so it is not sent again
+	// even if it cannot be updated once acknowledged
+	err = notifier.alerts.AddMessage(store.AlertMessage{
+		AlertId:   notification.AlertId,
+		ChatId:    chatId,
+		MessageId: messageId,
+	})
+	if err != nil {
+		log.Println("telegramNotifier.Send() - db insert alert message")
+		log.Println(err)
+	}
+	return nil
+}
+
+// Buttons sent with an alert, to acknowledge it and
+// to let the other TOs know the client is attended to
+func alertKeyboard(alertId int) [][]map[string]string {
+	button := func(text string, action string) map[string]string {
+		return map[string]string{
+			"text":          text,
+			"callback_data": store.AlertCallbackData(action, alertId),
+		}
+	}
+	return [][]map[string]string{
+		{button("Acknowledge", store.AlertActionAcknowledge)},
+		{
+			button("On my way", store.AlertActionOnMyWay),
+			button("Resolved", store.AlertActionResolved),
+		},
+	}
+}
+
+// Calls sendMessage, returning the id of the message sent.
+// Rate limited messages can be retried after the delay given
+// by telegram, while bad requests, e.g. unknown chats, and
+// blocked bots cannot be retried.
+func (notifier *telegramNotifier) sendMessage(
+	message map[string]interface{}) (int, error) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return 0, err
+	}
+	response, err := notifier.client.Post(
+		notifier.apiUrl+"/sendMessage",
+		"application/json",
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	var reply teleSendReply
+	err = json.NewDecoder(response.Body).Decode(&reply)
+	if err != nil {
+		return 0, fmt.Errorf("telegram replied with %d: %w",
+			response.StatusCode, err)
+	} else if reply.Ok {
+		return reply.Result.MessageId, nil
+	}
+
+	err = fmt.Errorf("telegram replied with %d: %s",
+		reply.ErrorCode, reply.Description)
+	switch reply.ErrorCode {
+	case http.StatusTooManyRequests:
+		return 0, retryAfterError{
+			err:   err,
+			delay: time.Duration(reply.Parameters.RetryAfter) * time.Second,
+		}
+	case http.StatusBadRequest, http.StatusForbidden:
+		return 0, permanentError{err}
+	}
+	return 0, err
+}
+
+// Posts the notification as json to the URL chosen by the TO,
+// which may not be inside the network of the server
+type webhookNotifier struct {
+	client *http.Client
+}
+
+var errPrivateAddress = errors.New("private, loopback or link-local address")
+
+// Whether the ip is only reachable from inside the network
+// of the server, so that TOs cannot reach it with webhooks
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast()
+}
+
+// Whether the host of the URL resolves only to public addresses
+func isPublicUrl(rawUrl string) bool {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	ips, err := net.LookupIP(parsedUrl.Hostname())
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if isPrivateIP(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Client which refuses to connect to private addresses. The
+// address is checked when dialled, after the host is resolved,
+// so that redirects and DNS changes cannot get around it.
+func newPublicHttpClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: notificationTimeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || isPrivateIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   notificationTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+}
+
+func (notifier *webhookNotifier) Send(webhookUrl string,
+	notification Notification) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"clientId": notification.ClientId,
+		"type":     notification.Type,
+		"title":    notification.Title,
+		"message":  notification.Message,
+		"alertId":  notification.AlertId,
+		"sentAt":   time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	response, err := notifier.client.Post(webhookUrl,
+		"application/json", bytes.NewBuffer(body))
+	if errors.Is(err, errPrivateAddress) {
+		return permanentError{err}
+	} else if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return httpStatusError("webhook", response.StatusCode)
+	}
+	return nil
+}
+
+// Sends the notification as an email through the SMTP server
+type emailNotifier struct {
+	// host:port of the SMTP server
+	addr string
+	from string
+	// nil if the SMTP server needs no authentication
+	auth smtp.Auth
+}
+
+func (notifier *emailNotifier) Send(address string,
+	notification Notification) error {
+	subject := "PottySense"
+	if notification.Title != "" {
+		subject += ": " + notification.Title
+	}
+	message := "From: " + notifier.from + "\r\n" +
+		"To: " + address + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(notification.Message, "\n", "\r\n") + "\r\n"
+	err := smtp.SendMail(notifier.addr, notifier.auth, notifier.from,
+		[]string{address}, []byte(message))
+
+	// Permanent failures, e.g. unknown mailboxes, are not retried
+	var replyErr *textproto.Error
+	if errors.As(err, &replyErr) && replyErr.Code >= 500 {
+		return permanentError{err}
+	}
+	return err
+}
+
+// Publishes the notification to the ntfy topic chosen by the TO
+type ntfyNotifier struct {
+	baseUrl string
+	// Access token, empty for public topics
+	token  string
+	client *http.Client
+}
+
+func (notifier *ntfyNotifier) Send(topic string,
+	notification Notification) error {
+	request, err := http.NewRequest(http.MethodPost,
+		notifier.baseUrl+"/"+url.PathEscape(topic),
+		strings.NewReader(notification.Message))
+	if err != nil {
+		return err
+	}
+	if notification.Title != "" {
+		request.Header.Set("Title", notification.Title)
+	}
+	switch {
+	case notification.Type == "alert" || notification.Type == "escalated":
+		request.Header.Set("Priority", "urgent")
+		request.Header.Set("Tags", "warning")
+	case notification.IsSilent:
+		request.Header.Set("Priority", "low")
+	}
+	if notifier.token != "" {
+		request.Header.Set("Authorization", "Bearer "+notifier.token)
+	}
+
+	response, err := notifier.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return httpStatusError("ntfy", response.StatusCode)
+	}
+	return nil
+}
+
+// Gets the enabled channels of the TO which are configured on
+// the server, with the telegram address set to the chat id.
+// TOs who have not chosen any channels are notified through
+// telegram, if they have registered with the bot.
+func (server *Server) getTOChannels(to store.TO) ([]store.NotificationChannel, error) {
+	channels, err := server.store.NotificationChannels.ListByTO(to.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(channels) == 0 {
+		channels = []store.NotificationChannel{{
+			ToId:    to.Id,
+			Channel: store.ChannelTelegram,
+			Enabled: true,
+		}}
+	}
+
+	var enabled []store.NotificationChannel
+	for _, channel := range channels {
+		if channel.Channel == store.ChannelTelegram {
+			channel.Address = to.TelegramChatId
+		}
+		_, ok := server.notifiers[channel.Channel]
+		if channel.Enabled && ok && channel.Address != "" {
+			enabled = append(enabled, channel)
+		}
+	}
+	return enabled, nil
+}
+
+// Queues the notification to each of the TOs through their
+// channels, returning the number of TOs queued through at
+// least one channel and the number of channels which failed
+func (server *Server) notifyTOs(tos []store.TO,
+	notification Notification) (int, int) {
+	notified, failed := 0, 0
+	for _, to := range tos {
+		channels, err := server.getTOChannels(to)
+		if err != nil {
+			log.Println("notifyTOs() - db query channels")
+			log.Println(err)
+			failed++
+			continue
+		}
+
+		sent := false
+		for _, channel := range channels {
+			err := server.enqueueNotification(to.Id, channel.Channel,
+				channel.Address, notification)
+			if err != nil {
+				log.Printf("notifyTOs() - queue %s to %s\n", channel.Channel, to.Username)
+				log.Println(err)
+				failed++
+				continue
+			}
+			sent = true
+		}
+		if sent {
+			notified++
+		}
+	}
+	return notified, failed
+}
+
+// Queues the notification to the TOs tracking the client
+func (server *Server) notifyTracking(clientId int,
+	notification Notification) (int, int) {
+	tos, err := server.store.Track.ListTOs(clientId)
+	if err != nil {
+		log.Println("notifyTracking() - db query")
+		log.Println(err)
+		return 0, 1
+	}
+	notification.ClientId = clientId
+	return server.notifyTOs(tos, notification)
+}
@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/genekkion/PottySenseServer/internal/globals"
+	"github.com/genekkion/PottySenseShared/store"
+)
+
+// Error of a send which will fail again if retried,
+// e.g. the chat or address does not exist
+type permanentError struct {
+	err error
+}
+
+func (err permanentError) Error() string {
+	return err.err.Error()
+}
+
+// Error of a send which can be retried after the delay,
+// e.g. when telegram limits the rate of messages
+type retryAfterError struct {
+	err   error
+	delay time.Duration
+}
+
+func (err retryAfterError) Error() string {
+	return err.err.Error()
+}
+
+// Error of an HTTP reply, which is permanent for client
+// errors other than timeouts and rate limits
+func httpStatusError(name string, statusCode int) error {
+	err := fmt.Errorf("%s replied with %d", name, statusCode)
+	if statusCode >= 400 && statusCode < 500 &&
+		statusCode != http.StatusRequestTimeout &&
+		statusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// Queues the notification to be sent to the address through
+// the channel. toId is 0 for recipients other than TOs.
+func (server *Server) enqueueNotification(toId int, channel string,
+	address string, notification Notification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	_, err = server.store.Outbound.Enqueue(store.OutboundMessage{
+		ToId:    toId,
+		Channel: channel,
+		Address: address,
+		Payload: string(payload),
+	})
+	if err != nil {
+		return err
+	}
+	server.wakeOutbound()
+	return nil
+}
+
+// Wakes the dispatcher so that queued messages are sent
+// now, unless it is already woken
+func (server *Server) wakeOutbound() {
+	select {
+	case server.outboundWake <- struct{}{}:
+	default:
+	}
+}
+
+// Sends the queued notifications with the workers,
+// until the server stops
+func (server *Server) runOutbound() {
+	count, err := server.store.Outbound.ResetSending()
+	if err != nil {
+		log.Println("runOutbound() - db reset")
+		log.Println(err)
+	} else if count > 0 {
+		log.Printf("runOutbound() - %d interrupted messages queued again\n", count)
+	}
+
+	messages := make(chan store.OutboundMessage)
+	for i := 0; i < globals.OUTBOUND_WORKERS; i++ {
+		go func() {
+			for message := range messages {
+				server.deliverOutbound(message)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(globals.OUTBOUND_POLL * time.Second)
+	defer ticker.Stop()
+	var cleanedAt time.Time
+	for {
+		now := time.Now()
+		claimed, err := server.store.Outbound.Claim(now, globals.OUTBOUND_WORKERS)
+		if err != nil {
+			log.Println("runOutbound() - db claim")
+			log.Println(err)
+		}
+		for _, message := range claimed {
+			messages <- message
+		}
+		if len(claimed) > 0 {
+			continue
+		}
+
+		if now.Sub(cleanedAt) > time.Hour {
+			cleanedAt = now
+			err := server.store.Outbound.DeleteSentBefore(
+				now.AddDate(0, 0, -globals.OUTBOUND_KEEP_SENT))
+			if err != nil {
+				log.Println("runOutbound() - db delete sent")
+				log.Println(err)
+			}
+		}
+		select {
+		case <-server.outboundWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sends the message, retrying it later if the send fails,
+// unless the send can never succeed or was the last attempt
+func (server *Server) deliverOutbound(message store.OutboundMessage) {
+	var notification Notification
+	err := json.Unmarshal([]byte(message.Payload), &notification)
+	if err == nil {
+		notifier, ok := server.notifiers[message.Channel]
+		if !ok {
+			err = permanentError{errors.New("channel not configured")}
+		} else {
+			err = notifier.Send(message.Address, notification)
+		}
+	} else {
+		err = permanentError{err}
+	}
+
+	if err == nil {
+		err = server.store.Outbound.MarkSent(message.Id, time.Now())
+		if err != nil {
+			log.Println("deliverOutbound() - db mark sent")
+			log.Println(err)
+		}
+		return
+	}
+
+	log.Printf("deliverOutbound() - send %s message %d, attempt %d\n",
+		message.Channel, message.Id, message.Attempts+1)
+	log.Println(err)
+
+	var permanent permanentError
+	if errors.As(err, &permanent) ||
+		message.Attempts+1 >= globals.OUTBOUND_MAX_ATTEMPTS {
+		err = server.store.Outbound.MarkDead(message.Id, err.Error())
+		if err != nil {
+			log.Println("deliverOutbound() - db mark dead")
+			log.Println(err)
+		}
+		return
+	}
+
+	delay := outboundBackoff(message.Attempts)
+	var retryAfter retryAfterError
+	if errors.As(err, &retryAfter) && retryAfter.delay > delay {
+		delay = retryAfter.delay
+	}
+	err = server.store.Outbound.Retry(message.Id, err.Error(),
+		time.Now().Add(delay))
+	if err != nil {
+		log.Println("deliverOutbound() - db retry")
+		log.Println(err)
+	}
+}
+
+// Delay before the next attempt, after the attempts so far
+func outboundBackoff(attempts int) time.Duration {
+	delay := globals.OUTBOUND_BACKOFF_BASE * time.Second
+	for i := 0; i < attempts; i++ {
+		delay *= 2
+		if delay >= globals.OUTBOUND_BACKOFF_MAX*time.Second {
+			return globals.OUTBOUND_BACKOFF_MAX * time.Second
+		}
+	}
+	return delay
+}
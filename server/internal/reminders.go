@@ -0,0 +1,209 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/genekkion/PottySenseServer/internal/globals"
+	"github.com/genekkion/PottySenseShared/store"
+)
+
+var errInvalidReminderTime = errors.New("times should be HH:MM")
+
+// Parses a time of the day, HH:MM, into minutes since midnight
+func parseReminderTime(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errInvalidReminderTime
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// Parses a comma separated list of times of the day,
+// returning them sorted and without duplicates
+func parseReminderSchedule(value string) ([]string, error) {
+	var minutes []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		minute, err := parseReminderTime(field)
+		if err != nil {
+			return nil, err
+		}
+		minutes = append(minutes, minute)
+	}
+
+	sort.Ints(minutes)
+	var schedule []string
+	for i, minute := range minutes {
+		if i > 0 && minute == minutes[i-1] {
+			continue
+		}
+		schedule = append(schedule, fmt.Sprintf("%02d:%02d", minute/60, minute%60))
+	}
+	return schedule, nil
+}
+
+// Whether the time is within the quiet hours, which may
+// span midnight. Empty or equal bounds mean no quiet hours.
+func isQuietTime(reminder store.ClientReminder, now time.Time) bool {
+	start, err := parseReminderTime(reminder.QuietStart)
+	if err != nil {
+		return false
+	}
+	end, err := parseReminderTime(reminder.QuietEnd)
+	if err != nil || start == end {
+		return false
+	}
+
+	now = now.In(time.Local)
+	minute := now.Hour()*60 + now.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// Since when and why a client is due for the toilet
+type reminderDue struct {
+	At time.Time
+	// Whether the client is due because of a scheduled
+	// time rather than the interval
+	Scheduled bool
+}
+
+// Gets when the client last became due for the toilet, if
+// they have not used it since. Scheduled times are
+// checked for today and yesterday, in the local time.
+func getReminderDue(reminder store.ClientReminder,
+	lastRecord time.Time, now time.Time) (reminderDue, bool) {
+	var due reminderDue
+	isDue := false
+
+	if reminder.IntervalMinutes > 0 {
+		at := lastRecord.Add(time.Duration(reminder.IntervalMinutes) * time.Minute)
+		if !at.After(now) {
+			due = reminderDue{At: at}
+			isDue = true
+		}
+	}
+
+	local := now.In(time.Local)
+	lead := globals.REMINDER_SCHEDULE_LEAD * time.Minute
+	for _, scheduled := range reminder.Schedule {
+		minute, err := parseReminderTime(scheduled)
+		if err != nil {
+			continue
+		}
+		for _, days := range []int{-1, 0} {
+			at := time.Date(local.Year(), local.Month(), local.Day()+days,
+				minute/60, minute%60, 0, 0, time.Local)
+			if at.After(now) || !lastRecord.Before(at.Add(-lead)) {
+				continue
+			}
+			// The latest time the client became due is kept,
+			// so that each new scheduled time is reminded
+			if !isDue || at.After(due.At) {
+				due = reminderDue{At: at, Scheduled: true}
+				isDue = true
+			}
+		}
+	}
+	return due, isDue
+}
+
+// Whether a reminder should be sent for the client now
+func shouldRemind(reminder store.ActiveReminder, now time.Time) (reminderDue, bool) {
+	if reminder.Client.SessionPhase != 0 || isQuietTime(reminder.ClientReminder, now) {
+		return reminderDue{}, false
+	}
+	due, isDue := getReminderDue(reminder.ClientReminder,
+		reminder.Client.LastRecord, now)
+	if !isDue {
+		return reminderDue{}, false
+	}
+
+	lastRemindedAt := reminder.LastRemindedAt
+	if lastRemindedAt == nil || lastRemindedAt.Before(due.At) {
+		return due, true
+	}
+	repeat := globals.REMINDER_REPEAT * time.Minute
+	return due, now.Sub(*lastRemindedAt) >= repeat
+}
+
+// Formats the reminder, both for the notifiers and the Track tab
+func formatReminder(client store.Client, due reminderDue,
+	now time.Time) (messageType string, message string) {
+	name := client.FirstName + " " + client.LastName
+	overdue := now.Sub(due.At)
+
+	if overdue < globals.REMINDER_OVERDUE_AFTER*time.Minute {
+		messageType = "reminder"
+		message = name + " is due for the toilet"
+	} else {
+		messageType = "overdue"
+		message = fmt.Sprintf("%s is overdue for the toilet by %d min",
+			name, int(overdue.Minutes()))
+	}
+	if due.Scheduled {
+		message += " (scheduled at " + due.At.In(time.Local).Format("15:04") + ")"
+	}
+	message += ". Last used the toilet at " +
+		client.LastRecord.In(time.Local).Format("02/01 15:04") + "."
+	return messageType, message
+}
+
+// Checks the reminders of all clients every tick,
+// until the server stops
+func (server *Server) runReminders() {
+	ticker := time.NewTicker(globals.REMINDER_TICK * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		server.sendReminders(now)
+	}
+}
+
+// Sends the reminders which are due to the TOs tracking
+// the clients. Clients in a session or in their quiet
+// hours are skipped.
+func (server *Server) sendReminders(now time.Time) {
+	reminders, err := server.store.Reminders.ListActive()
+	if err != nil {
+		log.Println("sendReminders() - db query")
+		log.Println(err)
+		return
+	}
+
+	for _, reminder := range reminders {
+		due, ok := shouldRemind(reminder, now)
+		if !ok {
+			continue
+		}
+		messageType, message := formatReminder(reminder.Client.Client, due, now)
+		title := "Reminder"
+		if messageType == "overdue" {
+			title = "Overdue!"
+		}
+		notified, _ := server.notifyTracking(reminder.ClientId, Notification{
+			Type:    messageType,
+			Title:   title,
+			Message: message,
+		})
+		if notified == 0 {
+			continue
+		}
+		server.publishClientAlert(reminder.ClientId, messageType, message)
+
+		err = server.store.Reminders.SetLastReminded(reminder.ClientId, now.UTC())
+		if err != nil {
+			log.Println("sendReminders() - db update")
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/genekkion/PottySenseServer/internal/globals"
+	"github.com/genekkion/PottySenseShared/store"
+	"github.com/xuri/excelize/v2"
+)
+
+// Name of the summary sheet of a report, the
+// clients each have a sheet after it
+const reportSummarySheet = "Summary"
+
+var errInvalidReportPeriod = errors.New("dates should be YYYY-MM-DD, with from not after to")
+
+// Toileting records of a client over the period of a report
+type clientReport struct {
+	Client store.Client
+	// Oldest first
+	Entries []store.ToiletEntry
+	Alerts  []store.Alert
+}
+
+// Parses the period of a report from the dates, YYYY-MM-DD in
+// local time, into [from, to) with the day of "to" included.
+// Missing dates default to the last REPORT_DEFAULT_DAYS days.
+func parseReportPeriod(fromValue string, toValue string,
+	now time.Time) (time.Time, time.Time, error) {
+	local := now.In(time.Local)
+	to := time.Date(local.Year(), local.Month(), local.Day(),
+		0, 0, 0, 0, time.Local)
+	if toValue != "" {
+		parsed, err := time.ParseInLocation(time.DateOnly, toValue, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, errInvalidReportPeriod
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, 1-globals.REPORT_DEFAULT_DAYS)
+	if fromValue != "" {
+		parsed, err := time.ParseInLocation(time.DateOnly, fromValue, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, errInvalidReportPeriod
+		}
+		from = parsed
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, errInvalidReportPeriod
+	}
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+// Gets the clients with the ids supplied, or all the
+// clients if none. Returns sql.ErrNoRows if any client
+// is not found.
+func getReportClients(s *store.Store, clientIds []int) ([]store.Client, error) {
+	if len(clientIds) == 0 {
+		return s.Clients.List()
+	}
+	var clients []store.Client
+	for _, clientId := range clientIds {
+		client, err := s.Clients.Get(clientId)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// Creates the toileting report of the clients over [from, to)
+func newToiletingReport(s *store.Store, clients []store.Client,
+	from time.Time, to time.Time) (*excelize.File, error) {
+	tos, err := s.TOfficers.List()
+	if err != nil {
+		return nil, err
+	}
+	officers := make(map[int]string, len(tos))
+	for _, officer := range tos {
+		officers[officer.Id] = officer.Username
+	}
+
+	var reports []clientReport
+	for _, client := range clients {
+		entries, _, err := s.ToiletEntries.Find(store.ToiletEntryFilter{
+			ClientId: client.Id,
+			From:     from,
+			To:       to,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+		alerts, err := s.Alerts.ListByClient(client.Id, from, to)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, clientReport{
+			Client:  client,
+			Entries: entries,
+			Alerts:  alerts,
+		})
+	}
+	return newReportSpreadsheet(reports, officers, from, to)
+}
+
+// Gets a sheet name for the client which is unique and
+// valid, i.e. at most 31 characters without :\/?*[]
+func reportSheetName(client store.Client) string {
+	suffix := " (" + strconv.Itoa(client.Id) + ")"
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`:\/?*[]`, r) {
+			return -1
+		}
+		return r
+	}, client.FirstName+" "+client.LastName)
+
+	runes := []rune(name)
+	if limit := excelize.MaxSheetNameLength - len(suffix); len(runes) > limit {
+		runes = runes[:limit]
+	}
+	return string(runes) + suffix
+}
+
+// Formats the time in the local time of the server, empty for nil
+func formatReportTime(value *time.Time) string {
+	if value == nil {
+		return ""
+	}
+	return value.Local().Format(time.DateTime)
+}
+
+// Writes the reports to a spreadsheet, with a summary sheet of
+// the counts and averages of each client, followed by a sheet
+// of the entries and the alerts of each client
+func newReportSpreadsheet(reports []clientReport, officers map[int]string,
+	from time.Time, to time.Time) (*excelize.File, error) {
+	file := excelize.NewFile()
+	err := file.SetSheetName("Sheet1", reportSummarySheet)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	summary := [][]interface{}{
+		{"Toileting report"},
+		{"From", from.Local().Format(time.DateOnly)},
+		// The report is up to the start of "to"
+		{"To", to.Local().AddDate(0, 0, -1).Format(time.DateOnly)},
+		{},
+		{
+			"Client", "Client ID", "Entries", "Urination", "Defecation",
+			"Completed", "Cancelled", "Timed out",
+			"Average urination (MM:SS)", "Average defecation (MM:SS)",
+			"Alerts", "Acknowledged", "Average time to acknowledge (MM:SS)",
+		},
+	}
+	for _, report := range reports {
+		sheet := reportSheetName(report.Client)
+		_, err := file.NewSheet(sheet)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		summaryRow, rows := newClientReportRows(report, officers)
+		summary = append(summary, summaryRow)
+
+		err = setSheetRows(file, sheet, rows)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	err = setSheetRows(file, reportSummarySheet, summary)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Gets the row of the client on the summary sheet,
+// along with the rows of the sheet of the client
+func newClientReportRows(report clientReport,
+	officers map[int]string) ([]interface{}, [][]interface{}) {
+	client := report.Client
+	var urination, defecation, completed, cancelled, timedOut int
+	var urinationTotal, urinationDone, defecationTotal, defecationDone int
+
+	rows := [][]interface{}{{
+		"Entry ID", "Started", "Business", "Outcome", "Duration (s)",
+		"Entered", "Finished", "Exited",
+	}}
+	for _, entry := range report.Entries {
+		startedAt := entry.StartedAt()
+		rows = append(rows, []interface{}{
+			entry.Id, formatReportTime(&startedAt), entry.BusinessType,
+			entry.Outcome, entry.Duration, formatReportTime(entry.EnterTime),
+			formatReportTime(entry.FinishTime), formatReportTime(entry.ExitTime),
+		})
+
+		if entry.BusinessType == "defecation" {
+			defecation++
+		} else {
+			urination++
+		}
+		switch entry.Outcome {
+		case "complete":
+			completed++
+			if entry.BusinessType == "defecation" {
+				defecationTotal += entry.Duration
+				defecationDone++
+			} else {
+				urinationTotal += entry.Duration
+				urinationDone++
+			}
+		case "cancelled":
+			cancelled++
+		case "timeout":
+			timedOut++
+		}
+	}
+
+	rows = append(rows, []interface{}{}, []interface{}{
+		"Alert ID", "Created", "Message", "Escalation level",
+		"Acknowledged", "Acknowledged by", "Time to acknowledge (MM:SS)",
+	})
+	var acknowledged, acknowledgeTotal int
+	for _, alert := range report.Alerts {
+		row := []interface{}{
+			alert.Id, formatReportTime(&alert.CreatedAt), alert.Message,
+			alert.EscalationLevel, formatReportTime(alert.AcknowledgedAt),
+		}
+		if alert.IsAcknowledged() {
+			officer, ok := officers[alert.AcknowledgedBy]
+			if !ok {
+				officer = fmt.Sprintf("removed officer %d", alert.AcknowledgedBy)
+			}
+			elapsed := int(alert.AcknowledgedAt.Sub(alert.CreatedAt).Seconds())
+			row = append(row, officer, formatAverageDuration(elapsed, 1))
+			acknowledged++
+			acknowledgeTotal += elapsed
+		}
+		rows = append(rows, row)
+	}
+
+	summaryRow := []interface{}{
+		client.FirstName + " " + client.LastName, client.Id,
+		len(report.Entries), urination, defecation,
+		completed, cancelled, timedOut,
+		formatAverageDuration(urinationTotal, urinationDone),
+		formatAverageDuration(defecationTotal, defecationDone),
+		len(report.Alerts), acknowledged,
+		formatAverageDuration(acknowledgeTotal, acknowledged),
+	}
+	return summaryRow, rows
+}
+
+// Writes the rows to the sheet, starting from the first cell
+func setSheetRows(file *excelize.File, sheet string, rows [][]interface{}) error {
+	for i, row := range rows {
+		cell, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			return err
+		}
+		err = file.SetSheetRow(sheet, cell, &row)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
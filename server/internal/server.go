@@ -1,15 +1,17 @@
 package internal
 
 import (
-	"bytes"
 	"database/sql"
 	"encoding/json"
-	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/genekkion/PottySenseServer/internal/globals"
+	"github.com/genekkion/PottySenseShared/store"
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
 	"github.com/redis/go-redis/v9"
@@ -19,10 +21,22 @@ import (
 type Server struct {
 	listenAddr        string
 	db                *sql.DB
+	store             *store.Store
 	redisSessionStore *redistore.RediStore
 	router            *mux.Router
 	redisStorage      *redis.Client
-	telebotAddr       string
+	events            *eventBroker
+	// Channels the TOs are notified through, by name
+	notifiers map[string]Notifier
+	// Wakes the outbound dispatcher when a message is queued
+	outboundWake chan struct{}
+	// Unacknowledged alerts are escalated after this delay,
+	// lastly to the fallback chat if there is one
+	alertEscalation     time.Duration
+	alertFallbackChatId string
+	// Signing secrets of the API keys are derived from
+	// their hashes with this, see signing.DeriveSecret
+	signingSecret string
 }
 
 func InitServer(dbStorage *sql.DB,
@@ -30,22 +44,36 @@ func InitServer(dbStorage *sql.DB,
 	redisStorage *redis.Client) *Server {
 
 	listenAddr := os.Getenv("SERVER_ADDR")
-	telebotAddr := "https://api.telegram.org/bot" +
-		os.Getenv("TELEGRAM_BOT_TOKEN") + "/sendMessage"
+
+	alertEscalationMinutes, err := strconv.Atoi(os.Getenv("ALERT_ESCALATION_MINUTES"))
+	if err != nil || alertEscalationMinutes <= 0 {
+		alertEscalationMinutes = globals.ALERT_DEFAULT_ESCALATION_MINUTES
+	}
+
+	dataStore := store.New(dbStorage)
+	notifiers := newNotifiers(dataStore.Alerts)
 
 	router := mux.NewRouter()
 	server := &Server{
 		listenAddr:        listenAddr,
 		db:                dbStorage,
+		store:             dataStore,
 		redisSessionStore: redisSessionStore,
 		redisStorage:      redisStorage,
 		router:            router,
-		telebotAddr:       telebotAddr,
+		events:            newEventBroker(),
+		notifiers:         notifiers,
+		outboundWake:      make(chan struct{}, 1),
+
+		alertEscalation:     time.Duration(alertEscalationMinutes) * time.Minute,
+		alertFallbackChatId: os.Getenv("ALERT_FALLBACK_CHAT_ID"),
+		signingSecret:       os.Getenv("API_SIGNING_SECRET"),
 	}
 
 	server.addFileServer()
 	server.addInternalRoutes()
 	server.addExternalRoutes()
+	server.addApiRoutes()
 
 	log.Printf("Server running on: http://%s\n", server.listenAddr)
 	return server
@@ -62,21 +90,99 @@ func (server *Server) addInternalRoutes() {
 
 	router.HandleFunc("/logout", server.logout)
 
-	router.HandleFunc("/track", server.authWrapper(server.dashboardTrack))
-	router.HandleFunc("/htmx/track", server.authWrapper(server.htmxTrackingHandler))
+	router.HandleFunc("/track", server.authWrapper(
+		server.permissionWrapper(store.PermissionTrack, server.dashboardTrack)))
+	router.HandleFunc("/htmx/track", server.authWrapper(
+		server.permissionWrapper(store.PermissionTrack, server.htmxTrackingHandler)))
+	router.HandleFunc("/htmx/track/stream", server.authWrapper(
+		server.permissionWrapper(store.PermissionTrack, server.htmxTrackingStream)))
+
+	router.HandleFunc("/clients", server.authWrapper(
+		server.permissionWrapper(store.PermissionViewClients, server.dashboardClients)))
+	router.HandleFunc("/htmx/clients", server.authWrapper(
+		server.permissionWrapper(store.PermissionViewClients, server.htmxClients)))
+	router.HandleFunc("/htmx/clients/new", server.authWrapper(
+		server.permissionWrapper(store.PermissionEditClients, server.htmxClientNewHandler)))
+	router.HandleFunc("/htmx/clients/reminders", server.authWrapper(
+		server.permissionWrapper(store.PermissionEditClients, server.htmxClientRemindersHandler)))
+
+	router.HandleFunc("/analytics", server.authWrapper(
+		server.permissionWrapper(store.PermissionViewClients, server.dashboardAnalytics)))
+	router.HandleFunc("/htmx/analytics", server.authWrapper(
+		server.permissionWrapper(store.PermissionViewClients, server.htmxAnalyticsHandler)))
+	router.HandleFunc("/htmx/analytics/client", server.authWrapper(
+		server.permissionWrapper(store.PermissionViewClients, server.htmxAnalyticsClient)))
+	router.HandleFunc("/htmx/analytics/thresholds", server.authWrapper(
+		server.permissionWrapper(store.PermissionEditClients, server.htmxAnalyticsThresholdApply)))
+	router.HandleFunc("/htmx/analytics/thresholds/auto", server.authWrapper(
+		server.permissionWrapper(store.PermissionEditClients, server.htmxAnalyticsThresholdAuto)))
+
+	router.HandleFunc("/reports", server.authWrapper(
+		server.permissionWrapper(store.PermissionViewClients, server.dashboardReports)))
+	router.HandleFunc("/htmx/reports", server.authWrapper(
+		server.permissionWrapper(store.PermissionViewClients, server.htmxReportsPanel)))
+	router.HandleFunc("/htmx/reports/export", server.authWrapper(
+		server.permissionWrapper(store.PermissionViewClients, server.htmxReportsExport)))
+
+	router.HandleFunc("/assignments", server.authWrapper(
+		server.permissionWrapper(store.PermissionAssignClients, server.dashboardAssignments)))
+	router.HandleFunc("/htmx/assignments", server.authWrapper(
+		server.permissionWrapper(store.PermissionAssignClients, server.htmxAssignmentsHandler)))
+	router.HandleFunc("/htmx/assignments/bulk", server.authWrapper(
+		server.permissionWrapper(store.PermissionAssignClients, server.htmxAssignmentsBulk)))
 
-	router.HandleFunc("/clients", server.authWrapper(server.dashboardClients))
-	router.HandleFunc("/htmx/clients", server.authWrapper(server.htmxClients))
-	router.HandleFunc("/htmx/clients/new", server.authWrapper(server.htmxClientNewHandler))
+	router.HandleFunc("/shift", server.authWrapper(
+		server.permissionWrapper(store.PermissionTrack, server.dashboardShift)))
+	router.HandleFunc("/htmx/shift", server.authWrapper(
+		server.permissionWrapper(store.PermissionTrack, server.htmxShiftHandler)))
+	router.HandleFunc("/htmx/shift/handover", server.authWrapper(
+		server.permissionWrapper(store.PermissionTrack, server.htmxShiftHandover)))
 
-	router.HandleFunc("/accounts", server.authWrapper(server.dashboardAccounts))
-	router.HandleFunc("/htmx/accounts", server.authWrapper(server.htmxAccountsHandler))
-	router.HandleFunc("/htmx/accounts/edit", server.authWrapper(server.htmxAccountsEditHandler))
-	router.HandleFunc("/htmx/accounts/new", server.authWrapper(server.htmxAccountsNewHandler))
+	router.HandleFunc("/accounts", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageAccounts, server.dashboardAccounts)))
+	router.HandleFunc("/htmx/accounts", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageAccounts, server.htmxAccountsHandler)))
+	router.HandleFunc("/htmx/accounts/edit", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageAccounts, server.htmxAccountsEditHandler)))
+	router.HandleFunc("/htmx/accounts/new", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageAccounts, server.htmxAccountsNewHandler)))
+
+	router.HandleFunc("/toilets", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageToilets, server.dashboardToilets)))
+	router.HandleFunc("/htmx/toilets", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageToilets, server.htmxToiletsHandler)))
+	router.HandleFunc("/htmx/toilets/new", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageToilets, server.htmxToiletsNewHandler)))
+	router.HandleFunc("/htmx/toilets/edit", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageToilets, server.htmxToiletsEditHandler)))
+
+	router.HandleFunc("/api-keys", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageApiKeys, server.dashboardApiKeys)))
+	router.HandleFunc("/htmx/api-keys", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageApiKeys, server.htmxApiKeysHandler)))
+	router.HandleFunc("/htmx/api-keys/new", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageApiKeys, server.htmxApiKeysNewHandler)))
+	router.HandleFunc("/htmx/api-keys/edit", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageApiKeys, server.htmxApiKeysEditHandler)))
+
+	router.HandleFunc("/deliveries", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageDeliveries, server.dashboardDeliveries)))
+	router.HandleFunc("/htmx/deliveries", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageDeliveries, server.htmxDeliveriesHandler)))
+	router.HandleFunc("/htmx/deliveries/resend", server.authWrapper(
+		server.permissionWrapper(store.PermissionManageDeliveries, server.htmxDeliveriesResend)))
+
+	router.HandleFunc("/audit", server.authWrapper(
+		server.permissionWrapper(store.PermissionViewAudit, server.dashboardAudit)))
+	router.HandleFunc("/htmx/audit", server.authWrapper(
+		server.permissionWrapper(store.PermissionViewAudit, server.htmxAuditHandler)))
+	router.HandleFunc("/htmx/audit/export", server.authWrapper(
+		server.permissionWrapper(store.PermissionViewAudit, server.htmxAuditExport)))
 
 	router.HandleFunc("/settings", server.authWrapper(server.dashboardSettings))
 	router.HandleFunc("/htmx/settings", server.authWrapper(server.htmxSettingsHandler))
 	router.HandleFunc("/htmx/settings/password", server.authWrapper(server.htmxSettingsPasswordHandler))
+	router.HandleFunc("/htmx/settings/notifications", server.authWrapper(server.htmxSettingsNotificationsHandler))
 }
 
 // Starts the server
@@ -95,18 +201,33 @@ func (server *Server) Run() {
 					break
 				}
 			}
+			for _, prefix := range globals.UNPROTECTED_ROUTE_PREFIXES {
+				if !served && strings.HasPrefix(request.URL.Path, prefix) {
+					served = true
+					handler.ServeHTTP(writer, request)
+				}
+			}
 			if !served {
 				CSRF(handler).ServeHTTP(writer, request)
 			}
 		})
 	})
+
+	if globals.FLAG_BOT != "" {
+		server.startBot(globals.FLAG_BOT)
+	}
+	go server.runOutbound()
+	go server.runReminders()
+	go server.runAlertEscalations()
+	go server.runShifts()
 	http.ListenAndServe(server.listenAddr, server.router)
 }
 
 // Writes json to the writer
 func writeJson(writer http.ResponseWriter, statusCode int, value any) error {
+	// Headers need to be set before the status code is written
+	writer.Header().Set("Content-Type", "application/json")
 	writer.WriteHeader(statusCode)
-	writer.Header().Add("Content-Type", "application/json")
 
 	return json.NewEncoder(writer).Encode(value)
 }
@@ -116,67 +237,14 @@ func (server *Server) indexHandler(writer http.ResponseWriter,
 	request *http.Request) {
 	if server.isValidSession(request) {
 		http.Redirect(writer, request,
-			globals.DEFAULT_DASHBOARD_ROUTE, http.StatusSeeOther)
+			dashboardRoute(server.getTOFromCookie(request).UserType),
+			http.StatusSeeOther)
 	} else {
 		http.Redirect(writer, request,
 			"/login", http.StatusSeeOther)
 	}
 }
 
-// Sends telegram message to a specified chatId.
-// Accepts the template to apply
-func (server *Server) sendTeleTemplate(chatId string,
-	tmpl *template.Template) error {
-
-	var stringBuffer bytes.Buffer
-	err := tmpl.Execute(&stringBuffer, "")
-	if err != nil {
-		log.Println("sendTeleTemplate(), execute template")
-		log.Println(err)
-		return err
-	}
-
-	body, err := json.Marshal(
-		map[string]string{
-			"chat_id":    chatId,
-			"text":       stringBuffer.String(),
-			"parse_mode": "HTML",
-		},
-	)
-	if err != nil {
-		return err
-	}
-	response := bytes.NewBuffer(body)
-	_, err = http.Post(
-		server.telebotAddr,
-		"application/json",
-		response,
-	)
-	return err
-}
-
-// Sends telegram message to a specified chatId
-func (server *Server) sendTeleString(chatId string, message string, isSilent bool) error {
-	body, err := json.Marshal(
-		map[string]interface{}{
-			"chat_id":              chatId,
-			"text":                 message,
-			"parse_mode":           "HTML",
-			"disable_notification": isSilent,
-		},
-	)
-	if err != nil {
-		return err
-	}
-	response := bytes.NewBuffer(body)
-	_, err = http.Post(
-		server.telebotAddr,
-		"application/json",
-		response,
-	)
-	return err
-}
-
 // Serves static files
 func (server *Server) addFileServer() {
 	fileServer := http.FileServer(http.Dir("./static"))
@@ -204,6 +272,13 @@ func genericForbiddenReply(writer http.ResponseWriter) {
 	})
 }
 
+// Generic reply json for resources which do not exist
+func genericNotFoundReply(writer http.ResponseWriter) {
+	writeJson(writer, http.StatusNotFound, map[string]string{
+		"error": "Not found.",
+	})
+}
+
 // Generic reply json for unauthorized access
 func genericUnauthorizedReply(writer http.ResponseWriter) {
 	writeJson(writer, http.StatusUnauthorized, map[string]string{
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/genekkion/PottySenseServer/internal/globals"
+	"github.com/genekkion/PottySenseShared/store"
+)
+
+// Ends the shifts which are overdue every tick, so
+// that TOs who have gone home stop being notified
+func (server *Server) runShifts() {
+	ticker := time.NewTicker(globals.SHIFT_TICK * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		server.endShifts(now)
+	}
+}
+
+// Ends the shifts which are overdue at their planned end,
+// letting the TOs know of the clients they no longer track,
+// then removes any other tracking which has expired
+func (server *Server) endShifts(now time.Time) {
+	shifts, err := server.store.Shifts.ListOverdue(now)
+	if err != nil {
+		log.Println("endShifts() - db query")
+		log.Println(err)
+		return
+	}
+
+	for _, shift := range shifts {
+		clientIds, err := server.store.Track.ListClientIds(shift.ToId)
+		if err != nil {
+			log.Println("endShifts() - db query clients")
+			log.Println(err)
+			continue
+		}
+		err = server.store.Shifts.End(shift.Id, shift.EndsAt, 0)
+		if err != nil {
+			log.Println("endShifts() - db update")
+			log.Println(err)
+			continue
+		}
+		server.publishTrackingChanged(shift.ToId)
+
+		to, err := server.store.TOfficers.Get(shift.ToId)
+		if err != nil {
+			log.Println("endShifts() - db query officer")
+			log.Println(err)
+			continue
+		}
+		server.notifyTOs([]store.TO{to}, Notification{
+			Type:  "notification",
+			Title: "Shift ended",
+			Message: fmt.Sprintf("Your shift has ended, you are no "+
+				"longer tracking %d clients.", len(clientIds)),
+		})
+	}
+
+	expired, err := server.store.Track.RemoveExpired(now)
+	if err != nil {
+		log.Println("endShifts() - db delete expired")
+		log.Println(err)
+	} else if len(expired) > 0 {
+		log.Printf("endShifts() - removed %d expired tracking\n", len(expired))
+		var toIds []int
+		for _, assignment := range expired {
+			toIds = append(toIds, assignment.ToId)
+		}
+		server.publishTrackingChanged(toIds...)
+	}
+}
+
+// Formats the summary sent to the TO taking over the clients,
+// with the last record and the open alerts of each client
+func formatHandover(from string, clients []store.TrackedClient,
+	alerts []store.Alert) string {
+	if len(clients) == 0 {
+		return from + " has handed over to you, without any tracked clients."
+	}
+
+	alertsByClient := make(map[int][]string)
+	for _, alert := range alerts {
+		alertsByClient[alert.ClientId] = append(alertsByClient[alert.ClientId],
+			alert.Message)
+	}
+
+	lines := []string{fmt.Sprintf("%s has handed over %d clients to you:",
+		from, len(clients))}
+	for _, client := range clients {
+		line := "- " + client.FirstName + " " + client.LastName + ": "
+		if client.LastRecord.IsZero() {
+			line += "no toilet records"
+		} else {
+			line += "last used the toilet at " +
+				client.LastRecord.In(time.Local).Format("02/01 15:04")
+		}
+		if client.SessionPhase != 0 {
+			line += ", now at " + client.ToiletName
+		}
+
+		clientAlerts := alertsByClient[client.Id]
+		if len(clientAlerts) == 0 {
+			line += ", no open alerts."
+		} else {
+			line += ", open alerts: " + strings.Join(clientAlerts, "; ") + "."
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "Use /current to see your tracked clients.")
+	return strings.Join(lines, "\n")
+}
@@ -1,23 +1,32 @@
 package internal
 
-import "time"
+import (
+	"time"
+
+	"github.com/genekkion/PottySenseServer/internal/globals"
+	"github.com/genekkion/PottySenseServer/internal/utils"
+	"github.com/genekkion/PottySenseShared/store"
+)
 
 type Client struct {
-	Id               int
-	FirstName        string
-	LastName         string
-	Gender           string
-	Urination        int
-	Defecation       int
-	LastRecord       time.Time
+	store.Client
 	PrettyLastRecord string
 }
 
-type TO struct {
-	Id             int
-	Username       string
-	FirstName      string
-	LastName       string
-	TelegramChatId string
-	UserType       string
+// Adds the pretty last record to the client
+func newClient(client store.Client) Client {
+	prettyLastRecord := "nil"
+	if time.Since(client.LastRecord).Hours() < globals.LAST_RECORD_THRESHOLD {
+		prettyLastRecord = utils.GetTimeElapsedPretty(client.LastRecord)
+	}
+	return Client{
+		Client:           client,
+		PrettyLastRecord: prettyLastRecord,
+	}
 }
+
+type TO = store.TO
+
+type Toilet = store.Toilet
+
+type ToiletSession = store.Session
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"log"
+	"os"
+
+	"PottySenseTelebot/bot"
+
+	"github.com/genekkion/PottySenseServer/internal/globals"
+)
+
+// Runs the actions of the hosted bot directly, rather than
+// sending them to the /ext/bot routes over HTTP
+type botClient struct {
+	server *Server
+}
+
+func (client botClient) StartSession(clientId int, toiletId int,
+	toId int) error {
+	_, err := client.server.startBotSession(clientId, toiletId, toId)
+	return err
+}
+
+func (client botClient) CancelSession(clientId int, toId int,
+	reason string) error {
+	_, err := client.server.cancelBotSession(clientId, toId, reason)
+	return err
+}
+
+func (client botClient) RespondAlert(alertId int, toId int,
+	action string) error {
+	_, _, err := client.server.respondToAlert(alertId, toId, action)
+	return err
+}
+
+// Hosts the telegram bot in the server, sharing its database
+// and redis, in place of running the telebot on its own. In
+// webhook mode, the updates are received at the path of the
+// webhook url, which is not CSRF protected.
+func (server *Server) startBot(mode string) {
+	telegramBot := bot.NewBot(os.Getenv("TELEGRAM_BOT_TOKEN"),
+		server.db, server.redisStorage, botClient{server})
+
+	if mode != "webhook" {
+		go telegramBot.Run()
+		return
+	}
+
+	path, handler, err := telegramBot.StartWebhook(
+		os.Getenv("TELEGRAM_WEBHOOK_URL"), os.Getenv("TELEGRAM_WEBHOOK_SECRET"))
+	if err != nil {
+		log.Println("startBot() - set webhook")
+		log.Fatalln(err)
+	}
+	server.router.Handle(path, handler)
+	globals.UNPROTECTED_ROUTES = append(globals.UNPROTECTED_ROUTES, path)
+	log.Println("Telegram bot receiving updates at " + path)
+}
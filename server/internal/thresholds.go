@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"html/template"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/genekkion/PottySenseServer/internal/globals"
+	"github.com/genekkion/PottySenseShared/store"
+)
+
+// Business types which have a threshold
+var thresholdBusinessTypes = []string{"urination", "defecation"}
+
+// Suggested threshold of a business type for a client
+type ThresholdSuggestion struct {
+	BusinessType string
+	// Configured threshold, in seconds
+	Current int
+	// Suggested threshold, in seconds, 0 if there are
+	// not enough entries to suggest one
+	Suggested  int
+	SampleSize int
+}
+
+// Whether applying the suggestion would change the threshold
+func (suggestion ThresholdSuggestion) CanApply() bool {
+	return suggestion.Suggested != 0 &&
+		suggestion.Suggested != suggestion.Current
+}
+
+// Computes the suggested threshold from the durations,
+// 0 if there are too few. The percentile is used rather
+// than the maximum so that outliers, e.g. a client who
+// was left alone, do not raise the threshold. Zero
+// durations, of entries which were cancelled, timed out
+// or missing their timestamps, are left out.
+func suggestThreshold(durations []int) int {
+	var sorted []int
+	for _, duration := range durations {
+		if duration > 0 {
+			sorted = append(sorted, duration)
+		}
+	}
+	if len(sorted) < globals.THRESHOLD_MIN_SAMPLES {
+		return 0
+	}
+	sort.Ints(sorted)
+
+	// Linear interpolation between the closest ranks
+	rank := float64(globals.THRESHOLD_PERCENTILE) / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	percentile := float64(sorted[lower]) +
+		(rank-float64(lower))*float64(sorted[upper]-sorted[lower])
+
+	suggested := percentile * (1 + float64(globals.THRESHOLD_MARGIN)/100)
+	step := float64(globals.THRESHOLD_STEP)
+	rounded := int(math.Ceil(suggested/step) * step)
+	return min(max(rounded, globals.THRESHOLD_MIN), globals.THRESHOLD_MAX)
+}
+
+// Gets the suggested thresholds of the client, in the
+// order of thresholdBusinessTypes
+func (server *Server) getThresholdSuggestions(
+	client store.Client) ([]ThresholdSuggestion, error) {
+	var suggestions []ThresholdSuggestion
+	for _, businessType := range thresholdBusinessTypes {
+		durations, err := server.store.ToiletEntries.ListDurations(
+			client.Id, businessType, globals.THRESHOLD_SAMPLE_SIZE)
+		if err != nil {
+			return nil, err
+		}
+
+		current := client.Urination
+		if businessType == "defecation" {
+			current = client.Defecation
+		}
+		suggestions = append(suggestions, ThresholdSuggestion{
+			BusinessType: businessType,
+			Current:      current,
+			Suggested:    suggestThreshold(durations),
+			SampleSize:   len(durations),
+		})
+	}
+	return suggestions, nil
+}
+
+// Sets the threshold of the client to the suggestion,
+// recording the change. toId is 0 for automatic changes.
+func (server *Server) applyThreshold(clientId int,
+	suggestion ThresholdSuggestion, toId int) error {
+	source := store.ThresholdSourceManual
+	if toId == 0 {
+		source = store.ThresholdSourceAuto
+	}
+
+	tx, err := server.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	txStore := store.New(tx)
+
+	err = txStore.Clients.SetThreshold(clientId,
+		suggestion.BusinessType, suggestion.Suggested)
+	if err != nil {
+		return err
+	}
+	_, err = txStore.Thresholds.Create(store.ThresholdChange{
+		ClientId:     clientId,
+		BusinessType: suggestion.BusinessType,
+		OldValue:     suggestion.Current,
+		NewValue:     suggestion.Suggested,
+		Source:       source,
+		ToId:         toId,
+		SampleSize:   suggestion.SampleSize,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Applies the suggested thresholds of the client if the
+// client has them applied automatically. Called whenever
+// a new toilet entry is recorded for the client.
+func (server *Server) autoApplyThresholds(clientId int) {
+	client, err := server.store.Clients.Get(clientId)
+	if err != nil {
+		log.Println("autoApplyThresholds() - db query")
+		log.Println(err)
+		return
+	} else if !client.AutoThreshold {
+		return
+	}
+
+	suggestions, err := server.getThresholdSuggestions(client)
+	if err != nil {
+		log.Println("autoApplyThresholds() - get suggestions")
+		log.Println(err)
+		return
+	}
+	for _, suggestion := range suggestions {
+		if !suggestion.CanApply() {
+			continue
+		}
+		err = server.applyThreshold(clientId, suggestion, 0)
+		if err != nil {
+			log.Println("autoApplyThresholds() - apply threshold")
+			log.Println(err)
+		}
+	}
+}
+
+// Thresholds section of the Analytics tab for a client
+type ClientThresholds struct {
+	Client      store.Client
+	Suggestions []ThresholdSuggestion
+	Changes     []ThresholdChangeEntry
+	CsrfField   template.HTML
+	// Whether the TO can change the thresholds
+	CanEdit bool
+}
+
+// A threshold change along with who made it
+type ThresholdChangeEntry struct {
+	store.ThresholdChange
+	By string
+}
+
+// Gets the suggestions and latest changes of the client
+func (server *Server) getClientThresholds(client store.Client,
+	csrfField template.HTML) (ClientThresholds, error) {
+	suggestions, err := server.getThresholdSuggestions(client)
+	if err != nil {
+		return ClientThresholds{}, err
+	}
+	changes, err := server.store.Thresholds.ListByClient(client.Id, 10)
+	if err != nil {
+		return ClientThresholds{}, err
+	}
+
+	thresholds := ClientThresholds{
+		Client:      client,
+		Suggestions: suggestions,
+		CsrfField:   csrfField,
+	}
+	for _, change := range changes {
+		by := "automatic"
+		if change.ToId != 0 {
+			to, err := server.store.TOfficers.Get(change.ToId)
+			if err == nil {
+				by = to.Username
+			} else {
+				by = "deleted account"
+			}
+		}
+		thresholds.Changes = append(thresholds.Changes, ThresholdChangeEntry{
+			ThresholdChange: change,
+			By:              by,
+		})
+	}
+	return thresholds, nil
+}
@@ -0,0 +1,17 @@
+package internal
+
+import "github.com/genekkion/PottySenseShared/store"
+
+// Human readable description of the phase of a session
+func toiletSessionPhasePretty(phase int) string {
+	switch phase {
+	case store.SessionPhaseWaiting:
+		return "Waiting for client"
+	case store.SessionPhaseEntered:
+		return "In toilet"
+	case store.SessionPhaseFinished:
+		return "Finished business"
+	default:
+		return "nil"
+	}
+}
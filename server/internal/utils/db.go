@@ -6,6 +6,7 @@ import (
 	"log"
 	"strings"
 
+	"github.com/genekkion/PottySenseShared/store"
 	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -21,42 +22,27 @@ func NewSqliteStorage(filepath string) *sql.DB {
 		log.Panic(err)
 	}
 
-	testDB(db)
+	migrateDB(db)
 	log.Println("Sqlite connection successfully created.")
 
 	return db
 }
 
-func testDB(db *sql.DB) {
-	rows, err := db.Query("select * from Tofficers")
+// Applies any pending migrations, so that the schema
+// is up to date without wiping the existing data
+func migrateDB(db *sql.DB) {
+	err := store.Migrate(db)
 	if err != nil {
-		log.Println("query issues")
+		log.Println("db.go - migrateDB()")
 		log.Fatalln(err)
 	}
 
-	for rows.Next() {
-		var id int
-		var first_name string
-		var last_name string
-		var username string
-		var password string
-		var telegram string
-		var userType string
-		err = rows.Scan(
-			&id,
-			&first_name,
-			&last_name,
-			&username,
-			&password,
-			&telegram,
-			&userType,
-		)
-		if err != nil {
-			log.Fatalln(err)
-		}
+	version, err := store.SchemaVersion(db)
+	if err != nil {
+		log.Println("db.go - migrateDB()")
+		log.Fatalln(err)
 	}
-
-	rows.Close()
+	log.Printf("Database schema at version %d.\n", version)
 }
 
 // Adds the user into the DB
@@ -66,7 +52,7 @@ func CreateUser(db *sql.DB, firstName string,
 	userType = strings.ToLower(userType)
 	username = strings.ToLower(username)
 
-	if userType != "user" && userType != "admin" {
+	if !store.IsValidRole(userType) {
 		log.Println("Invalid userType, no account created.")
 		return errors.New("Invalid userType")
 	}
@@ -80,12 +66,8 @@ func CreateUser(db *sql.DB, firstName string,
 		return err
 	}
 
-	var id sql.NullInt32
-	err = db.QueryRow(
-		`SELECT id
-		FROM TOFFICERS
-		WHERE username = $1
-		`, username).Scan(&id)
+	tofficers := store.New(db).TOfficers
+	_, err = tofficers.GetByUsername(username)
 	if err == nil {
 		log.Println("Another account with this username already exists. Please use another username.")
 		return errors.New("Invalid username")
@@ -95,13 +77,12 @@ func CreateUser(db *sql.DB, firstName string,
 		return err
 	}
 
-	_, err = db.Exec(
-		`INSERT INTO TOfficers
-			(first_name, last_name,
-			username, password, type)
-		VALUES ($1, $2, $3, $4, $5)
-		`, firstName, lastName,
-		username, passwordHash, userType)
+	_, err = tofficers.Create(store.TO{
+		FirstName: firstName,
+		LastName:  lastName,
+		Username:  username,
+		UserType:  userType,
+	}, string(passwordHash))
 	if err != nil {
 		log.Println("Error creating user, please try again.")
 		log.Println(err)
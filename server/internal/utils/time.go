@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"database/sql"
 	"fmt"
 	"time"
 )
@@ -12,3 +13,18 @@ func GetTimeElapsedPretty(timeRecord time.Time) string {
 		int(elapsedTime.Minutes())%60,
 	)
 }
+
+// Converts unix seconds, as sent by the Pi, into a
+// time.Time. Non-positive values are treated as unset
+// and result in a null time.
+func UnixSecondsToNullTime(seconds float64) sql.NullTime {
+	if seconds <= 0 {
+		return sql.NullTime{}
+	}
+	whole := int64(seconds)
+	nanos := int64((seconds - float64(whole)) * float64(time.Second))
+	return sql.NullTime{
+		Time:  time.Unix(whole, nanos).UTC(),
+		Valid: true,
+	}
+}
@@ -20,7 +20,7 @@ var (
 		"TELEGRAM_BOT_TOKEN",
 		"REDIS_ADDR",
 		"REDIS_SECRET",
-		"SECRET_HEADER",
+		"API_SIGNING_SECRET",
 	}
 )
 
@@ -34,8 +34,6 @@ func main() {
 		}
 	}
 
-	// globals.TOILETS_URL[1] = os.Getenv("PI_ADDR")
-
 	redisSessionStore := utils.NewRedisSessionStore()
 	defer redisSessionStore.Close()
 
@@ -0,0 +1,5 @@
+module github.com/genekkion/PottySenseShared
+
+go 1.21.6
+
+require github.com/mattn/go-sqlite3 v1.14.20
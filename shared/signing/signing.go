@@ -0,0 +1,203 @@
+// Package signing signs requests to the /ext routes of the
+// server with HMAC-SHA256, so that captured requests cannot
+// be replayed or altered.
+//
+// The string signed is made up of the following, each on its
+// own line: method, path (including the query), unix timestamp
+// in seconds, nonce, and the hex SHA-256 of the body. The key
+// used is the signing secret issued along with the API key,
+// which the server derives from the key hash with a secret of
+// its own, see DeriveSecret. The signing secret therefore
+// cannot be worked out from the database alone, and neither
+// the API key nor the signing secret are ever sent.
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/genekkion/PottySenseShared/store"
+)
+
+// Headers of a signed request
+const (
+	HeaderKeyId     = "X-PS-Key-Id" // Prefix of the API key
+	HeaderTimestamp = "X-PS-Timestamp"
+	HeaderNonce     = "X-PS-Nonce"
+	HeaderSignature = "X-PS-Signature"
+)
+
+var (
+	ErrMissingSignature = errors.New("request is not signed")
+	ErrInvalidSignature = errors.New("invalid request signature")
+)
+
+// Builds the string which is signed
+func StringToSign(method string, path string,
+	timestamp string, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		timestamp,
+		nonce,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+}
+
+// Derives the signing secret of an API key from its hash, as
+// stored by the server, and the secret of the server. Keys
+// which are rotated get a new signing secret along with them.
+func DeriveSecret(serverSecret string, keyHash string) string {
+	mac := hmac.New(sha256.New, []byte(serverSecret))
+	mac.Write([]byte(keyHash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signs the string with the signing secret of the
+// API key, returning the signature as hex
+func Sign(secret string, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks the signature supplied in constant time
+func Verify(secret string, stringToSign string, signature string) bool {
+	return hmac.Equal(
+		[]byte(Sign(secret, stringToSign)),
+		[]byte(strings.ToLower(signature)),
+	)
+}
+
+// Generates a random nonce as hex
+func NewNonce() (string, error) {
+	nonceBytes := make([]byte, 16)
+	_, err := rand.Read(nonceBytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonceBytes), nil
+}
+
+// The headers of a signed request, see Parse
+type Signature struct {
+	KeyId     string
+	Timestamp time.Time
+	Nonce     string
+	Signature string
+}
+
+// Reads the signature headers of the request, returning
+// ErrMissingSignature if there are none at all
+func Parse(header http.Header) (Signature, error) {
+	signature := Signature{
+		KeyId:     header.Get(HeaderKeyId),
+		Nonce:     header.Get(HeaderNonce),
+		Signature: header.Get(HeaderSignature),
+	}
+	timestamp := header.Get(HeaderTimestamp)
+	if signature.KeyId == "" && timestamp == "" &&
+		signature.Nonce == "" && signature.Signature == "" {
+		return Signature{}, ErrMissingSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signature.KeyId == "" ||
+		signature.Nonce == "" || signature.Signature == "" {
+		return Signature{}, ErrInvalidSignature
+	}
+	signature.Timestamp = time.Unix(seconds, 0)
+	return signature, nil
+}
+
+// Signs the request with the signing secret of the API key
+// supplied. The body has to be passed in as well since it
+// is hashed.
+func SignRequest(request *http.Request, apiKey string,
+	secret string, body []byte) error {
+	prefix, err := store.ParseApiKeyPrefix(apiKey)
+	if err != nil {
+		return err
+	}
+	nonce, err := NewNonce()
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request.Header.Set(HeaderKeyId, prefix)
+	request.Header.Set(HeaderTimestamp, timestamp)
+	request.Header.Set(HeaderNonce, nonce)
+	request.Header.Set(HeaderSignature, Sign(
+		secret,
+		StringToSign(request.Method, request.URL.RequestURI(),
+			timestamp, nonce, body),
+	))
+	return nil
+}
+
+// Sends signed json requests to the server
+type Client struct {
+	baseUrl    string
+	apiKey     string
+	secret     string
+	httpClient *http.Client
+}
+
+// Creates a client for the server at the base url, e.g.
+// http://localhost:3000, signing with the secret of the key
+func NewClient(baseUrl string, apiKey string, secret string) *Client {
+	return &Client{
+		baseUrl: strings.TrimRight(baseUrl, "/"),
+		apiKey:  apiKey,
+		secret:  secret,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Sends the json body to the path supplied, e.g. /ext/bot
+func (client *Client) Do(method string, path string,
+	body []byte) (*http.Response, error) {
+	request, err := http.NewRequest(method, client.baseUrl+path,
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	err = SignRequest(request, client.apiKey, client.secret, body)
+	if err != nil {
+		return nil, err
+	}
+	return client.httpClient.Do(request)
+}
+
+// Reads the body of the request for verifying, replacing
+// it so that it can still be read by the handler
+func ReadBody(request *http.Request, limit int64) ([]byte, error) {
+	if request.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(request.Body, limit+1))
+	request.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errors.New("request body too large")
+	}
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
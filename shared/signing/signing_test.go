@@ -0,0 +1,127 @@
+package signing
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/genekkion/PottySenseShared/store"
+)
+
+func TestSignRequest(t *testing.T) {
+	apiKey, prefix, err := store.GenerateApiKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"clientId":1}`)
+	secret := DeriveSecret("server secret", store.HashApiKey(apiKey))
+
+	var received *http.Request
+	var receivedBody []byte
+	server := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			received = request
+			receivedBody, _ = ReadBody(request, 1024)
+			// The body can still be read after verifying
+			again, _ := io.ReadAll(request.Body)
+			if string(again) != string(receivedBody) {
+				t.Errorf("got body %q after reading, want %q", again, receivedBody)
+			}
+		}))
+	defer server.Close()
+
+	response, err := NewClient(server.URL+"/", apiKey, secret).
+		Do(http.MethodPost, "/ext/api?x=1", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	signature, err := Parse(received.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature.KeyId != prefix {
+		t.Errorf("got key id %q, want %q", signature.KeyId, prefix)
+	}
+	if time.Since(signature.Timestamp) > time.Minute {
+		t.Errorf("got timestamp %v, want now", signature.Timestamp)
+	}
+	if received.Header.Get("X-PS-Header") != "" {
+		t.Error("got the API key sent along with the signature")
+	}
+
+	stringToSign := StringToSign(received.Method, received.URL.RequestURI(),
+		received.Header.Get(HeaderTimestamp), signature.Nonce, receivedBody)
+	if !Verify(secret, stringToSign, signature.Signature) {
+		t.Error("got invalid signature, want valid")
+	}
+
+	// Any change to the request invalidates the signature
+	tampered := []string{
+		StringToSign(http.MethodPut, received.URL.RequestURI(),
+			received.Header.Get(HeaderTimestamp), signature.Nonce, receivedBody),
+		StringToSign(received.Method, "/ext/bot",
+			received.Header.Get(HeaderTimestamp), signature.Nonce, receivedBody),
+		StringToSign(received.Method, received.URL.RequestURI(),
+			"0", signature.Nonce, receivedBody),
+		StringToSign(received.Method, received.URL.RequestURI(),
+			received.Header.Get(HeaderTimestamp), "nonce", receivedBody),
+		StringToSign(received.Method, received.URL.RequestURI(),
+			received.Header.Get(HeaderTimestamp), signature.Nonce, []byte(`{"clientId":2}`)),
+	}
+	for _, tamperedString := range tampered {
+		if Verify(secret, tamperedString, signature.Signature) {
+			t.Errorf("got valid signature for %q, want invalid",
+				strings.ReplaceAll(tamperedString, "\n", " "))
+		}
+	}
+	if Verify(DeriveSecret("server secret", store.HashApiKey(apiKey+"0")),
+		stringToSign, signature.Signature) {
+		t.Error("got valid signature with another key, want invalid")
+	}
+	// The key hash alone, as stored by the server, cannot sign
+	if Verify(store.HashApiKey(apiKey), stringToSign, signature.Signature) {
+		t.Error("got valid signature with the key hash, want invalid")
+	}
+	if Verify(DeriveSecret("other secret", store.HashApiKey(apiKey)),
+		stringToSign, signature.Signature) {
+		t.Error("got valid signature with another server secret, want invalid")
+	}
+}
+
+func TestParse(t *testing.T) {
+	_, err := Parse(http.Header{})
+	if err != ErrMissingSignature {
+		t.Errorf("got %v without headers, want ErrMissingSignature", err)
+	}
+
+	header := http.Header{}
+	header.Set(HeaderKeyId, "abcd")
+	header.Set(HeaderTimestamp, "not a number")
+	header.Set(HeaderNonce, "nonce")
+	header.Set(HeaderSignature, "signature")
+	_, err = Parse(header)
+	if err != ErrInvalidSignature {
+		t.Errorf("got %v with invalid timestamp, want ErrInvalidSignature", err)
+	}
+
+	header.Set(HeaderTimestamp, "1700000000")
+	header.Del(HeaderNonce)
+	_, err = Parse(header)
+	if err != ErrInvalidSignature {
+		t.Errorf("got %v without nonce, want ErrInvalidSignature", err)
+	}
+}
+
+func TestReadBodyLimit(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/ext/api",
+		strings.NewReader("0123456789"))
+	_, err := ReadBody(request, 5)
+	if err == nil {
+		t.Error("got no error reading a body over the limit")
+	}
+}
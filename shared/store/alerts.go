@@ -0,0 +1,254 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrAlertAcknowledged = errors.New("alert has already been acknowledged")
+
+// Levels an alert is escalated through while it
+// is not acknowledged, in order
+const (
+	AlertLevelTracking   = 0 // Sent to the TOs tracking the client
+	AlertLevelRenotified = 1 // Sent to the tracking TOs again
+	AlertLevelAdmins     = 2 // Sent to all admins
+	AlertLevelFallback   = 3 // Sent to the fallback group chat
+)
+
+// Actions of the buttons sent with an alert. The callback
+// data of a button is the action followed by the id of the
+// alert, e.g. "ack:42".
+const (
+	AlertActionAcknowledge = "ack"
+	// The TO is on the way to the client
+	AlertActionOnMyWay = "omw"
+	// The TO has attended to the client
+	AlertActionResolved = "resolved"
+)
+
+// Gets the callback data of the button for the action on the alert
+func AlertCallbackData(action string, alertId int) string {
+	return action + ":" + strconv.Itoa(alertId)
+}
+
+// Gets the action and the id of the alert from the callback
+// data of the button, returning false if it is not an action
+// on an alert
+func ParseAlertCallbackData(data string) (string, int, bool) {
+	action, id, found := strings.Cut(data, ":")
+	if !found {
+		return "", 0, false
+	}
+	switch action {
+	case AlertActionAcknowledge, AlertActionOnMyWay, AlertActionResolved:
+	default:
+		return "", 0, false
+	}
+	alertId, err := strconv.Atoi(id)
+	return action, alertId, err == nil
+}
+
+type Alert struct {
+	Id              int       `json:"id"`
+	ClientId        int       `json:"clientId"`
+	Message         string    `json:"message"`
+	EscalationLevel int       `json:"escalationLevel"`
+	CreatedAt       time.Time `json:"createdAt"`
+	// When the alert was last sent
+	EscalatedAt    time.Time  `json:"escalatedAt"`
+	AcknowledgedAt *time.Time `json:"acknowledgedAt"`
+	// TO who acknowledged the alert, 0 if not acknowledged
+	AcknowledgedBy int `json:"acknowledgedBy"`
+}
+
+func (alert Alert) IsAcknowledged() bool {
+	return alert.AcknowledgedAt != nil
+}
+
+// A telegram message sent for an alert
+type AlertMessage struct {
+	AlertId   int    `json:"alertId"`
+	ChatId    string `json:"chatId"`
+	MessageId int    `json:"messageId"`
+}
+
+type AlertRepository struct {
+	db DBTX
+}
+
+const alertColumns = `id, client_id, message,
+	escalation_level, created_at, escalated_at,
+	acknowledged_at, COALESCE(acknowledged_by, 0)`
+
+func scanAlert(row interface{ Scan(...any) error }) (Alert, error) {
+	var alert Alert
+	var acknowledgedAt sql.NullTime
+	err := row.Scan(
+		&alert.Id, &alert.ClientId, &alert.Message,
+		&alert.EscalationLevel, &alert.CreatedAt, &alert.EscalatedAt,
+		&acknowledgedAt, &alert.AcknowledgedBy,
+	)
+	alert.AcknowledgedAt = nullTimePointer(acknowledgedAt)
+	return alert, err
+}
+
+// Gets the alert with the id supplied
+func (repository *AlertRepository) Get(id int) (Alert, error) {
+	return scanAlert(repository.db.QueryRow(
+		`SELECT `+alertColumns+`
+		FROM Alerts
+		WHERE id = $1
+		`, id))
+}
+
+// Lists the alerts which have not been acknowledged, oldest first
+func (repository *AlertRepository) ListUnacknowledged() ([]Alert, error) {
+	rows, err := repository.db.Query(
+		`SELECT ` + alertColumns + `
+		FROM Alerts
+		WHERE acknowledged_at IS NULL
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []Alert
+	for rows.Next() {
+		alert, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
+
+// Lists the alerts of the client created within [from, to),
+// oldest first
+func (repository *AlertRepository) ListByClient(clientId int,
+	from time.Time, to time.Time) ([]Alert, error) {
+	rows, err := repository.db.Query(
+		`SELECT `+alertColumns+`
+		FROM Alerts
+		WHERE client_id = $1
+			AND created_at >= $2
+			AND created_at < $3
+		ORDER BY created_at, id
+		`, clientId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []Alert
+	for rows.Next() {
+		alert, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
+
+// Records a new alert for the client, returning the id of the alert
+func (repository *AlertRepository) Create(clientId int,
+	message string) (int, error) {
+	result, err := repository.db.Exec(
+		`INSERT INTO Alerts
+			(client_id, message)
+		VALUES ($1, $2)
+		`, clientId, message)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Records that the alert was sent at the level supplied.
+// Alerts acknowledged in the meantime are not escalated,
+// returning sql.ErrNoRows instead.
+func (repository *AlertRepository) Escalate(id int, level int,
+	escalatedAt time.Time) error {
+	result, err := repository.db.Exec(
+		`UPDATE Alerts
+		SET escalation_level = $1,
+			escalated_at = $2
+		WHERE id = $3
+			AND acknowledged_at IS NULL
+		`, level, escalatedAt, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Acknowledges the alert on behalf of the TO. Alerts
+// can only be acknowledged once, returning
+// ErrAlertAcknowledged afterwards.
+func (repository *AlertRepository) Acknowledge(id int, toId int,
+	acknowledgedAt time.Time) error {
+	result, err := repository.db.Exec(
+		`UPDATE Alerts
+		SET acknowledged_at = $1,
+			acknowledged_by = $2
+		WHERE id = $3
+			AND acknowledged_at IS NULL
+		`, acknowledgedAt, toId, id)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if count > 0 {
+		return nil
+	}
+
+	_, err = repository.Get(id)
+	if err != nil {
+		return err
+	}
+	return ErrAlertAcknowledged
+}
+
+// Records a telegram message sent for the alert
+func (repository *AlertRepository) AddMessage(message AlertMessage) error {
+	_, err := repository.db.Exec(
+		`INSERT OR IGNORE
+		INTO AlertMessages (alert_id, chat_id, message_id)
+		VALUES ($1, $2, $3)
+		`, message.AlertId, message.ChatId, message.MessageId)
+	return err
+}
+
+// Lists the telegram messages sent for the alert
+func (repository *AlertRepository) ListMessages(alertId int) ([]AlertMessage, error) {
+	rows, err := repository.db.Query(
+		`SELECT alert_id, chat_id, message_id
+		FROM AlertMessages
+		WHERE alert_id = $1
+		ORDER BY rowid
+		`, alertId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []AlertMessage
+	for rows.Next() {
+		var message AlertMessage
+		err := rows.Scan(&message.AlertId, &message.ChatId, &message.MessageId)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestAlertRepository(t *testing.T) {
+	store := newTestStore(t)
+	clientId := createTestClient(t, store, "John", "Doe")
+	toId := createTestTO(t, store, "user")
+
+	alertId, err := store.Alerts.Create(clientId, "Client has been in the toilet too long")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherId, err := store.Alerts.Create(clientId, "Client has fallen")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	escalatedAt := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+	err = store.Alerts.Escalate(alertId, AlertLevelAdmins, escalatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alert, err := store.Alerts.Get(alertId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alert.EscalationLevel != AlertLevelAdmins || !alert.EscalatedAt.Equal(escalatedAt) ||
+		alert.IsAcknowledged() {
+		t.Errorf("got %+v, want escalated to the admins", alert)
+	}
+
+	// Alerts can only be acknowledged once
+	err = store.Alerts.Acknowledge(alertId, toId, escalatedAt.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Alerts.Acknowledge(alertId, toId, escalatedAt.Add(time.Hour))
+	if err != ErrAlertAcknowledged {
+		t.Errorf("got %v acknowledging again, want ErrAlertAcknowledged", err)
+	}
+	err = store.Alerts.Acknowledge(otherId+1, toId, escalatedAt)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v for missing alert, want sql.ErrNoRows", err)
+	}
+	alert, _ = store.Alerts.Get(alertId)
+	if !alert.IsAcknowledged() || alert.AcknowledgedBy != toId ||
+		!alert.AcknowledgedAt.Equal(escalatedAt.Add(time.Minute)) {
+		t.Errorf("got %+v, want acknowledged by %d", alert, toId)
+	}
+
+	// Acknowledged alerts are no longer escalated
+	err = store.Alerts.Escalate(alertId, AlertLevelFallback, escalatedAt)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v escalating acknowledged alert, want sql.ErrNoRows", err)
+	}
+	alerts, err := store.Alerts.ListUnacknowledged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 1 || alerts[0].Id != otherId {
+		t.Errorf("got %+v, want only alert %d", alerts, otherId)
+	}
+
+	now := time.Now()
+	alerts, err = store.Alerts.ListByClient(clientId, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 2 || alerts[0].Id != alertId || alerts[0].AcknowledgedBy != toId {
+		t.Errorf("got %+v, want both alerts of the client", alerts)
+	}
+	alerts, _ = store.Alerts.ListByClient(clientId, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if len(alerts) != 0 {
+		t.Errorf("got %+v, want no alerts before they were created", alerts)
+	}
+
+	messages := []AlertMessage{
+		{AlertId: alertId, ChatId: "100", MessageId: 1},
+		{AlertId: alertId, ChatId: "200", MessageId: 5},
+		{AlertId: otherId, ChatId: "100", MessageId: 2},
+	}
+	for _, message := range messages {
+		err = store.Alerts.AddMessage(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	listed, err := store.Alerts.ListMessages(alertId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 || listed[1] != messages[1] {
+		t.Errorf("got %+v, want the messages of alert %d", listed, alertId)
+	}
+
+	// Alerts are removed along with the client
+	err = store.Clients.Delete(clientId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Alerts.Get(alertId)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v after deleting the client, want sql.ErrNoRows", err)
+	}
+	listed, _ = store.Alerts.ListMessages(otherId)
+	if len(listed) != 0 {
+		t.Errorf("got %+v after deleting the client, want no messages", listed)
+	}
+}
+
+func TestAlertCallbackData(t *testing.T) {
+	for _, action := range []string{
+		AlertActionAcknowledge, AlertActionOnMyWay, AlertActionResolved,
+	} {
+		parsed, alertId, ok := ParseAlertCallbackData(AlertCallbackData(action, 42))
+		if !ok || parsed != action || alertId != 42 {
+			t.Errorf("got %q, %d, %v, want %q, 42, true", parsed, alertId, ok, action)
+		}
+	}
+	for _, data := range []string{"", "ack", "ack:", "ack:x", "start:42"} {
+		_, _, ok := ParseAlertCallbackData(data)
+		if ok {
+			t.Errorf("got %q parsed, want not an action on an alert", data)
+		}
+	}
+}
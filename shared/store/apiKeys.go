@@ -0,0 +1,258 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Scopes which can be granted to an API key
+const (
+	ScopeSendNotification = "send-notification" // /ext/api
+	ScopeReportSession    = "report-session"    // /ext/session, /ext/session-result
+	ScopeStartSession     = "start-session"     // /ext/bot
+	ScopeApi              = "api"               // /api/v1
+)
+
+var (
+	ApiKeyScopes = []string{
+		ScopeSendNotification,
+		ScopeReportSession,
+		ScopeStartSession,
+		ScopeApi,
+	}
+
+	ErrInvalidApiKey = errors.New("invalid api key")
+)
+
+// An API key issued to a device. The key itself is only
+// shown once when issued, with just its hash being kept.
+type ApiKey struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  int        `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RotatedAt  *time.Time `json:"rotatedAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+func (apiKey ApiKey) IsRevoked() bool {
+	return apiKey.RevokedAt != nil
+}
+
+func (apiKey ApiKey) HasScope(scope string) bool {
+	for _, keyScope := range apiKey.Scopes {
+		if keyScope == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the key supplied against the stored hash
+// in constant time
+func (apiKey ApiKey) Matches(key string) bool {
+	return subtle.ConstantTimeCompare(
+		[]byte(HashApiKey(key)),
+		[]byte(apiKey.KeyHash),
+	) == 1
+}
+
+// Generates a new key of the form ps_<prefix>_<secret>,
+// returning the key along with its prefix
+func GenerateApiKey() (string, string, error) {
+	prefixBytes := make([]byte, 4)
+	_, err := rand.Read(prefixBytes)
+	if err != nil {
+		return "", "", err
+	}
+	secretBytes := make([]byte, 32)
+	_, err = rand.Read(secretBytes)
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix := hex.EncodeToString(prefixBytes)
+	return "ps_" + prefix + "_" + hex.EncodeToString(secretBytes), prefix, nil
+}
+
+// Gets the prefix of the key used to look it up,
+// returning ErrInvalidApiKey if the key is malformed
+func ParseApiKeyPrefix(key string) (string, error) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != "ps" ||
+		parts[1] == "" || parts[2] == "" {
+		return "", ErrInvalidApiKey
+	}
+	return parts[1], nil
+}
+
+// SHA-256 of the key, as hex. The keys are random so
+// a slow password hash is not needed.
+func HashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// Only keeps the known scopes, in the order of ApiKeyScopes
+func CleanApiKeyScopes(scopes []string) []string {
+	var cleaned []string
+	for _, scope := range ApiKeyScopes {
+		for _, other := range scopes {
+			if scope == other {
+				cleaned = append(cleaned, scope)
+				break
+			}
+		}
+	}
+	return cleaned
+}
+
+type ApiKeyRepository struct {
+	db DBTX
+}
+
+const apiKeyColumns = `id, name, prefix, key_hash,
+	scopes, COALESCE(created_by, 0), created_at,
+	rotated_at, last_used_at, revoked_at`
+
+func scanApiKey(row interface{ Scan(...any) error }) (ApiKey, error) {
+	var apiKey ApiKey
+	var scopes string
+	var rotatedAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&apiKey.Id, &apiKey.Name, &apiKey.Prefix, &apiKey.KeyHash,
+		&scopes, &apiKey.CreatedBy, &apiKey.CreatedAt,
+		&rotatedAt, &lastUsedAt, &revokedAt,
+	)
+	if scopes != "" {
+		apiKey.Scopes = strings.Split(scopes, ",")
+	}
+	apiKey.RotatedAt = nullTimePointer(rotatedAt)
+	apiKey.LastUsedAt = nullTimePointer(lastUsedAt)
+	apiKey.RevokedAt = nullTimePointer(revokedAt)
+	return apiKey, err
+}
+
+func (repository *ApiKeyRepository) query(query string,
+	args ...any) ([]ApiKey, error) {
+	rows, err := repository.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var apiKeys []ApiKey
+	for rows.Next() {
+		apiKey, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	return apiKeys, rows.Err()
+}
+
+// Gets the key with the id supplied
+func (repository *ApiKeyRepository) Get(id int) (ApiKey, error) {
+	return scanApiKey(repository.db.QueryRow(
+		`SELECT `+apiKeyColumns+`
+		FROM ApiKeys
+		WHERE id = $1
+		`, id))
+}
+
+// Gets the key with the prefix supplied, including revoked keys
+func (repository *ApiKeyRepository) GetByPrefix(prefix string) (ApiKey, error) {
+	return scanApiKey(repository.db.QueryRow(
+		`SELECT `+apiKeyColumns+`
+		FROM ApiKeys
+		WHERE prefix = $1
+		`, prefix))
+}
+
+// Lists all keys, with the revoked keys last
+func (repository *ApiKeyRepository) List() ([]ApiKey, error) {
+	return repository.query(
+		`SELECT ` + apiKeyColumns + `
+		FROM ApiKeys
+		ORDER BY revoked_at IS NOT NULL, id`)
+}
+
+// Adds a new key, returning the id of the key.
+// The prefix and key hash have to be set.
+func (repository *ApiKeyRepository) Create(apiKey ApiKey) (int, error) {
+	var createdBy sql.NullInt64
+	if apiKey.CreatedBy != 0 {
+		createdBy = sql.NullInt64{Int64: int64(apiKey.CreatedBy), Valid: true}
+	}
+
+	result, err := repository.db.Exec(
+		`INSERT INTO ApiKeys
+			(name, prefix, key_hash,
+			scopes, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		`, apiKey.Name, apiKey.Prefix, apiKey.KeyHash,
+		strings.Join(apiKey.Scopes, ","), createdBy)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Replaces the key of an unrevoked key, so that
+// the previous key stops working immediately
+func (repository *ApiKeyRepository) Rotate(id int,
+	prefix string, keyHash string) error {
+	result, err := repository.db.Exec(
+		`UPDATE ApiKeys SET
+			prefix = $1,
+			key_hash = $2,
+			rotated_at = current_timestamp,
+			last_used_at = NULL
+		WHERE id = $3
+			AND revoked_at IS NULL
+		`, prefix, keyHash, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Revokes the key. Revoked keys are kept so that
+// their usage can still be looked up.
+func (repository *ApiKeyRepository) Revoke(id int) error {
+	result, err := repository.db.Exec(
+		`UPDATE ApiKeys SET
+			revoked_at = current_timestamp
+		WHERE id = $1
+			AND revoked_at IS NULL
+		`, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Records that the key was just used. Only written
+// once a minute to avoid a write on every request.
+func (repository *ApiKeyRepository) Touch(id int) error {
+	_, err := repository.db.Exec(
+		`UPDATE ApiKeys SET
+			last_used_at = current_timestamp
+		WHERE id = $1
+			AND (last_used_at IS NULL
+				OR last_used_at < datetime('now', '-1 minute'))
+		`, id)
+	return err
+}
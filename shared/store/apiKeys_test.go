@@ -0,0 +1,120 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestApiKeyFormat(t *testing.T) {
+	key, prefix, err := GenerateApiKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseApiKeyPrefix(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != prefix {
+		t.Errorf("got prefix %q, want %q", parsed, prefix)
+	}
+
+	for _, invalid := range []string{"", "ps_", "ps__abc", "xx_abc_def", "ps_abc_def_ghi"} {
+		_, err := ParseApiKeyPrefix(invalid)
+		if err != ErrInvalidApiKey {
+			t.Errorf("got %v parsing %q, want ErrInvalidApiKey", err, invalid)
+		}
+	}
+
+	scopes := CleanApiKeyScopes([]string{"api", "unknown", ScopeSendNotification})
+	if len(scopes) != 2 || scopes[0] != ScopeSendNotification || scopes[1] != ScopeApi {
+		t.Errorf("got scopes %v, want known scopes in order", scopes)
+	}
+}
+
+func TestApiKeyRepository(t *testing.T) {
+	store := newTestStore(t)
+	toId := createTestTO(t, store, "admin")
+
+	key, prefix, _ := GenerateApiKey()
+	id, err := store.ApiKeys.Create(ApiKey{
+		Name:      "Level 1 pi",
+		Prefix:    prefix,
+		KeyHash:   HashApiKey(key),
+		Scopes:    []string{ScopeSendNotification, ScopeReportSession},
+		CreatedBy: toId,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiKey, err := store.ApiKeys.GetByPrefix(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apiKey.Id != id || apiKey.CreatedBy != toId || !apiKey.Matches(key) {
+		t.Errorf("got %+v, want the key created", apiKey)
+	}
+	if !apiKey.HasScope(ScopeReportSession) || apiKey.HasScope(ScopeStartSession) {
+		t.Errorf("got scopes %v, want the scopes granted", apiKey.Scopes)
+	}
+	if apiKey.Matches(key+"0") || apiKey.LastUsedAt != nil {
+		t.Errorf("got %+v, want unused key matching only itself", apiKey)
+	}
+
+	err = store.ApiKeys.Touch(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey, _ = store.ApiKeys.Get(id)
+	if apiKey.LastUsedAt == nil {
+		t.Error("got no last used time after touching the key")
+	}
+
+	// Rotating replaces the key
+	newKey, newPrefix, _ := GenerateApiKey()
+	err = store.ApiKeys.Rotate(id, newPrefix, HashApiKey(newKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.ApiKeys.GetByPrefix(prefix)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v getting the rotated key, want sql.ErrNoRows", err)
+	}
+	apiKey, _ = store.ApiKeys.GetByPrefix(newPrefix)
+	if !apiKey.Matches(newKey) || apiKey.Matches(key) || apiKey.RotatedAt == nil {
+		t.Errorf("got %+v, want only the new key to match", apiKey)
+	}
+
+	// Revoked keys are kept but cannot be rotated or revoked again
+	err = store.ApiKeys.Revoke(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey, _ = store.ApiKeys.Get(id)
+	if !apiKey.IsRevoked() {
+		t.Errorf("got %+v, want a revoked key", apiKey)
+	}
+	err = store.ApiKeys.Revoke(id)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v revoking twice, want sql.ErrNoRows", err)
+	}
+	err = store.ApiKeys.Rotate(id, prefix, HashApiKey(key))
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v rotating a revoked key, want sql.ErrNoRows", err)
+	}
+
+	secondId, _ := store.ApiKeys.Create(ApiKey{
+		Name:    "Telebot",
+		Prefix:  prefix,
+		KeyHash: HashApiKey(key),
+		Scopes:  []string{ScopeStartSession},
+	})
+	apiKeys, err := store.ApiKeys.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apiKeys) != 2 || apiKeys[0].Id != secondId {
+		t.Errorf("got %+v, want the revoked key last", apiKeys)
+	}
+}
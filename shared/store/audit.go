@@ -0,0 +1,213 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Where the changes recorded in the audit log were made
+const (
+	AuditSourceWeb = "web"
+	AuditSourceBot = "bot"
+	AuditSourceApi = "api"
+)
+
+// Changes recorded in the audit log
+const (
+	AuditActionCreate  = "create"
+	AuditActionUpdate  = "update"
+	AuditActionDelete  = "delete"
+	AuditActionTrack   = "track"
+	AuditActionUntrack = "untrack"
+	AuditActionStart   = "start"
+	AuditActionCancel  = "cancel"
+	AuditActionFinish  = "finish"
+	// Response to an alert, e.g. acknowledging it
+	AuditActionRespond = "respond"
+	AuditActionRotate  = "rotate"
+	AuditActionRevoke  = "revoke"
+)
+
+// What the changes recorded in the audit log were made to
+const (
+	AuditTargetAccount   = "account"
+	AuditTargetClient    = "client"
+	AuditTargetThreshold = "threshold"
+	AuditTargetReminder  = "reminder"
+	AuditTargetSession   = "session"
+	AuditTargetEntry     = "entry" // toilet entry
+	AuditTargetAlert     = "alert"
+	AuditTargetToilet    = "toilet"
+	AuditTargetApiKey    = "apiKey"
+	AuditTargetShift     = "shift"
+)
+
+// A change recorded in the audit log
+type AuditEntry struct {
+	Id int `json:"id"`
+	// TO making the change, 0 for API keys
+	ActorId int `json:"actorId"`
+	// Username of the TO, or name of the API key
+	Actor      string `json:"actor"`
+	Source     string `json:"source"`
+	Action     string `json:"action"`
+	TargetType string `json:"targetType"`
+	TargetId   int    `json:"targetId"`
+	// json of the values before and after the change,
+	// empty if there are none, see AuditValue
+	Before    string    `json:"before"`
+	After     string    `json:"after"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Filters for listing the audit log, empty fields are ignored
+type AuditFilter struct {
+	Actor      string
+	Source     string
+	Action     string
+	TargetType string
+	TargetId   int
+	// Entries created from From, and before To
+	From time.Time
+	To   time.Time
+	Page
+}
+
+// Gets the json of the value recorded before or after
+// a change, empty for nil
+func AuditValue(value any) string {
+	if value == nil {
+		return ""
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+type AuditRepository struct {
+	db DBTX
+}
+
+const auditColumns = `id, actor_id, actor,
+	source, action, target_type,
+	target_id, before_value, after_value,
+	created_at`
+
+func scanAuditEntry(row interface{ Scan(...any) error }) (AuditEntry, error) {
+	var entry AuditEntry
+	err := row.Scan(
+		&entry.Id, &entry.ActorId, &entry.Actor,
+		&entry.Source, &entry.Action, &entry.TargetType,
+		&entry.TargetId, &entry.Before, &entry.After,
+		&entry.CreatedAt,
+	)
+	return entry, err
+}
+
+func (repository *AuditRepository) query(query string,
+	args ...any) ([]AuditEntry, error) {
+	rows, err := repository.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Records the change, returning the id of the entry.
+// The time of the entry is set to now.
+func (repository *AuditRepository) Record(entry AuditEntry) (int, error) {
+	result, err := repository.db.Exec(
+		`INSERT INTO AuditLog
+			(actor_id, actor, source,
+			action, target_type, target_id,
+			before_value, after_value, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, entry.ActorId, entry.Actor, entry.Source,
+		entry.Action, entry.TargetType, entry.TargetId,
+		entry.Before, entry.After, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Lists the entries matching the filter, newest first,
+// along with the total number of matching entries
+func (repository *AuditRepository) Find(filter AuditFilter) ([]AuditEntry, int, error) {
+	var where whereClause
+	if filter.Actor != "" {
+		where.add("actor = %[1]s", filter.Actor)
+	}
+	if filter.Source != "" {
+		where.add("source = %[1]s", filter.Source)
+	}
+	if filter.Action != "" {
+		where.add("action = %[1]s", filter.Action)
+	}
+	if filter.TargetType != "" {
+		where.add("target_type = %[1]s", filter.TargetType)
+	}
+	if filter.TargetId != 0 {
+		where.add("target_id = %[1]s", filter.TargetId)
+	}
+	if !filter.From.IsZero() {
+		where.add("created_at >= %[1]s", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		where.add("created_at < %[1]s", filter.To.UTC())
+	}
+
+	var total int
+	err := repository.db.QueryRow(
+		`SELECT COUNT(*)
+		FROM AuditLog
+		`+where.String(), where.args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pageClause, args := where.page(filter.Page)
+	entries, err := repository.query(
+		`SELECT `+auditColumns+`
+		FROM AuditLog
+		`+where.String()+`
+		ORDER BY id DESC
+		`+pageClause, args...)
+	return entries, total, err
+}
+
+// Lists the names of everyone who has made a change, in order
+func (repository *AuditRepository) ListActors() ([]string, error) {
+	rows, err := repository.db.Query(
+		`SELECT DISTINCT actor
+		FROM AuditLog
+		ORDER BY actor`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actors []string
+	for rows.Next() {
+		var actor string
+		err = rows.Scan(&actor)
+		if err != nil {
+			return nil, err
+		}
+		actors = append(actors, actor)
+	}
+	return actors, rows.Err()
+}
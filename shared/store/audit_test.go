@@ -0,0 +1,80 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditRepository(t *testing.T) {
+	store := newTestStore(t)
+	toId := createTestTO(t, store, "admin")
+
+	before := time.Now().Add(-time.Second)
+	for _, entry := range []AuditEntry{
+		{ActorId: toId, Actor: "admin", Source: AuditSourceWeb,
+			Action: AuditActionCreate, TargetType: AuditTargetClient, TargetId: 1,
+			After: AuditValue(Client{FirstName: "John"})},
+		{ActorId: toId, Actor: "admin", Source: AuditSourceBot,
+			Action: AuditActionTrack, TargetType: AuditTargetClient, TargetId: 1},
+		{Actor: "pi", Source: AuditSourceApi,
+			Action: AuditActionStart, TargetType: AuditTargetSession, TargetId: 2},
+	} {
+		_, err := store.Audit.Record(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, total, err := store.Audit.Find(AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(entries) != 3 || entries[0].Actor != "pi" {
+		t.Fatalf("got %+v, want all 3 entries newest first", entries)
+	}
+	if entries[2].After == "" || entries[2].Before != "" {
+		t.Errorf("got before %q and after %q, want only after", entries[2].Before, entries[2].After)
+	}
+
+	for _, test := range []struct {
+		filter AuditFilter
+		want   int
+	}{
+		{AuditFilter{Actor: "admin"}, 2},
+		{AuditFilter{Source: AuditSourceBot}, 1},
+		{AuditFilter{Action: AuditActionStart}, 1},
+		{AuditFilter{TargetType: AuditTargetClient, TargetId: 1}, 2},
+		{AuditFilter{From: before}, 3},
+		{AuditFilter{To: before}, 0},
+		{AuditFilter{Page: Page{Limit: 1}}, 3},
+	} {
+		entries, total, err := store.Audit.Find(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != test.want {
+			t.Errorf("got %d entries for %+v, want %d", total, test.filter, test.want)
+		}
+		if test.filter.Limit == 1 && len(entries) != 1 {
+			t.Errorf("got %d entries listed, want 1", len(entries))
+		}
+	}
+
+	actors, err := store.Audit.ListActors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actors) != 2 || actors[0] != "admin" || actors[1] != "pi" {
+		t.Errorf("got actors %v, want [admin pi]", actors)
+	}
+
+	// Entries cannot be changed or removed
+	_, err = store.Audit.db.Exec(`UPDATE AuditLog SET actor = 'someone'`)
+	if err == nil {
+		t.Error("updated the audit log, want an error")
+	}
+	_, err = store.Audit.db.Exec(`DELETE FROM AuditLog`)
+	if err == nil {
+		t.Error("deleted from the audit log, want an error")
+	}
+}
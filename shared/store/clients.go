@@ -0,0 +1,265 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrClientInSession = errors.New("client has an active session")
+
+// Filters for listing clients, empty fields are ignored
+type ClientFilter struct {
+	// Start of the first or last name
+	Search string
+	Gender string
+	Page
+}
+
+type Client struct {
+	Id        int    `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Gender    string `json:"gender"`
+	// Target durations, in seconds
+	Urination  int       `json:"urination"`
+	Defecation int       `json:"defecation"`
+	LastRecord time.Time `json:"lastRecord"`
+	// Whether suggested thresholds are applied automatically.
+	// Only changed through SetAutoThreshold.
+	AutoThreshold bool `json:"autoThreshold"`
+}
+
+type ClientRepository struct {
+	db DBTX
+}
+
+const clientColumns = `Clients.id, Clients.first_name,
+	Clients.last_name, Clients.gender,
+	Clients.urination, Clients.defecation,
+	Clients.last_record, Clients.auto_threshold`
+
+func scanClient(row interface{ Scan(...any) error }) (Client, error) {
+	var client Client
+	err := row.Scan(
+		&client.Id, &client.FirstName,
+		&client.LastName, &client.Gender,
+		&client.Urination, &client.Defecation,
+		&client.LastRecord, &client.AutoThreshold,
+	)
+	return client, err
+}
+
+func (repository *ClientRepository) query(query string,
+	args ...any) ([]Client, error) {
+	rows, err := repository.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []Client
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+// Gets the client with the id supplied
+func (repository *ClientRepository) Get(id int) (Client, error) {
+	return scanClient(repository.db.QueryRow(
+		`SELECT `+clientColumns+`
+		FROM Clients
+		WHERE id = $1
+		`, id))
+}
+
+// Lists all clients, ordered by id
+func (repository *ClientRepository) List() ([]Client, error) {
+	return repository.query(
+		`SELECT ` + clientColumns + `
+		FROM Clients
+		ORDER BY id`)
+}
+
+// Searches for clients with a first name, last name or full
+// name starting with the name supplied, ignoring case
+func (repository *ClientRepository) Search(name string) ([]Client, error) {
+	// Add wildcard for autocomplete
+	return repository.query(
+		`SELECT `+clientColumns+`
+		FROM Clients
+		WHERE first_name LIKE $1 COLLATE NOCASE
+			OR last_name LIKE $1 COLLATE NOCASE
+			OR first_name || ' ' || last_name LIKE $1 COLLATE NOCASE
+		ORDER BY id
+		`, name+"%")
+}
+
+// Lists the clients matching the filter, ordered by id,
+// along with the total number of matching clients
+func (repository *ClientRepository) Find(filter ClientFilter) ([]Client, int, error) {
+	var where whereClause
+	if filter.Search != "" {
+		where.add(`(first_name LIKE %[1]s COLLATE NOCASE
+			OR last_name LIKE %[1]s COLLATE NOCASE)`, filter.Search+"%")
+	}
+	if filter.Gender != "" {
+		where.add("gender = %[1]s", filter.Gender)
+	}
+
+	var total int
+	err := repository.db.QueryRow(
+		`SELECT COUNT(*)
+		FROM Clients
+		`+where.String(), where.args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pageClause, args := where.page(filter.Page)
+	clients, err := repository.query(
+		`SELECT `+clientColumns+`
+		FROM Clients
+		`+where.String()+`
+		ORDER BY id
+		`+pageClause, args...)
+	return clients, total, err
+}
+
+// Adds a new client, returning the id of the client.
+// The last record of the client starts as now.
+func (repository *ClientRepository) Create(client Client) (int, error) {
+	result, err := repository.db.Exec(
+		`INSERT INTO Clients
+			(first_name, last_name,
+			gender, urination, defecation)
+		VALUES ($1, $2, $3, $4, $5)
+		`, client.FirstName, client.LastName,
+		client.Gender, client.Urination,
+		client.Defecation)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Updates the details of the client, excluding the
+// last record and whether thresholds are auto applied
+func (repository *ClientRepository) Update(client Client) error {
+	result, err := repository.db.Exec(
+		`UPDATE Clients SET
+			first_name = $1,
+			last_name = $2,
+			gender = $3,
+			urination = $4,
+			defecation = $5
+		WHERE id = $6
+		`, client.FirstName, client.LastName,
+		client.Gender, client.Urination,
+		client.Defecation, client.Id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Removes the client along with their tracking, toilet
+// entries, past sessions and alerts. Clients with an active
+// session cannot be removed, returning ErrClientInSession instead.
+// Should be used within a transaction.
+func (repository *ClientRepository) Delete(id int) error {
+	var count int
+	err := repository.db.QueryRow(
+		`SELECT COUNT(*)
+		FROM Sessions
+		WHERE client_id = $1
+			AND status = $2
+		`, id, SessionStatusActive).Scan(&count)
+	if err != nil {
+		return err
+	} else if count > 0 {
+		return ErrClientInSession
+	}
+
+	_, err = repository.db.Exec(
+		`DELETE FROM AlertMessages
+		WHERE alert_id IN (
+			SELECT id
+			FROM Alerts
+			WHERE client_id = $1
+		)`, id)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"Track", "ToiletEntries", "Sessions",
+		"ThresholdChanges", "ClientReminders", "Alerts"} {
+		_, err = repository.db.Exec(
+			`DELETE FROM `+table+`
+			WHERE client_id = $1
+			`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := repository.db.Exec(
+		`DELETE FROM Clients
+		WHERE id = $1
+		`, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Updates when the client last used the toilet
+func (repository *ClientRepository) SetLastRecord(id int,
+	lastRecord time.Time) error {
+	result, err := repository.db.Exec(
+		`UPDATE Clients
+		SET last_record = $1
+		WHERE id = $2
+		`, lastRecord, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Sets the target duration of the business type,
+// either urination or defecation
+func (repository *ClientRepository) SetThreshold(id int,
+	businessType string, seconds int) error {
+	column := "urination"
+	if businessType == "defecation" {
+		column = "defecation"
+	}
+	result, err := repository.db.Exec(
+		`UPDATE Clients
+		SET `+column+` = $1
+		WHERE id = $2
+		`, seconds, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Sets whether suggested thresholds are applied automatically
+func (repository *ClientRepository) SetAutoThreshold(id int,
+	autoThreshold bool) error {
+	result, err := repository.db.Exec(
+		`UPDATE Clients
+		SET auto_threshold = $1
+		WHERE id = $2
+		`, autoThreshold, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
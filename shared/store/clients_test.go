@@ -0,0 +1,160 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestClientRepository(t *testing.T) {
+	store := newTestStore(t)
+	johnId := createTestClient(t, store, "John", "Doe")
+	createTestClient(t, store, "Jane", "Doe")
+	createTestClient(t, store, "Adam", "Johnson")
+
+	client, err := store.Clients.Get(johnId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.FirstName != "John" || client.Urination != 300 {
+		t.Errorf("got %+v", client)
+	}
+
+	_, err = store.Clients.Get(9999)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v for missing client, want sql.ErrNoRows", err)
+	}
+
+	clients, err := store.Clients.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 3 || clients[0].Id != johnId {
+		t.Errorf("got %+v, want 3 clients ordered by id", clients)
+	}
+
+	lastRecord := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = store.Clients.SetLastRecord(johnId, lastRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ = store.Clients.Get(johnId)
+	if !client.LastRecord.Equal(lastRecord) {
+		t.Errorf("got last record %v, want %v", client.LastRecord, lastRecord)
+	}
+
+	err = store.Clients.SetLastRecord(9999, lastRecord)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v for missing client, want sql.ErrNoRows", err)
+	}
+}
+
+func TestClientSearch(t *testing.T) {
+	store := newTestStore(t)
+	createTestClient(t, store, "John", "Doe")
+	createTestClient(t, store, "Jane", "Doe")
+	createTestClient(t, store, "Adam", "Johnson")
+
+	tests := []struct {
+		search string
+		want   int
+	}{
+		// Matches the start of either name, ignoring case
+		{"jo", 2},
+		{"DOE", 2},
+		{"ja", 1},
+		{"oh", 0},
+		{"", 3},
+		// Or the start of the full name
+		{"john doe", 1},
+		{"Jane D", 1},
+		{"doe john", 0},
+	}
+	for _, test := range tests {
+		clients, err := store.Clients.Search(test.search)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(clients) != test.want {
+			t.Errorf("Search(%q) got %d clients, want %d",
+				test.search, len(clients), test.want)
+		}
+	}
+}
+
+func TestClientFind(t *testing.T) {
+	store := newTestStore(t)
+	createTestClient(t, store, "John", "Doe")
+	janeId := createTestClient(t, store, "Jane", "Doe")
+	createTestClient(t, store, "Adam", "Johnson")
+
+	clients, total, err := store.Clients.Find(ClientFilter{
+		Search: "doe",
+		Page:   Page{Limit: 1, Offset: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(clients) != 1 || clients[0].Id != janeId {
+		t.Errorf("got %+v of %d, want Jane of 2", clients, total)
+	}
+
+	clients, total, err = store.Clients.Find(ClientFilter{
+		Gender: "female",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 || len(clients) != 0 {
+		t.Errorf("got %+v of %d, want none", clients, total)
+	}
+
+	_, total, _ = store.Clients.Find(ClientFilter{})
+	if total != 3 {
+		t.Errorf("got total %d, want 3", total)
+	}
+}
+
+func TestClientUpdateAndDelete(t *testing.T) {
+	db := newTestDB(t)
+	store := New(db)
+	johnId := createTestClient(t, store, "John", "Doe")
+	janeId := createTestClient(t, store, "Jane", "Doe")
+	toId := createTestTO(t, store, "alice")
+	store.Track.Add(toId, johnId)
+
+	err := store.Clients.Update(Client{
+		Id:         johnId,
+		FirstName:  "Johnny",
+		LastName:   "Doe",
+		Gender:     "male",
+		Urination:  120,
+		Defecation: 240,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := store.Clients.Get(johnId)
+	if client.FirstName != "Johnny" || client.Urination != 120 {
+		t.Errorf("got %+v after update", client)
+	}
+
+	startTestSession(t, db, janeId, createTestToilet(t, store, "Toilet A"))
+	err = store.Clients.Delete(janeId)
+	if err != ErrClientInSession {
+		t.Errorf("got %v deleting client in session, want ErrClientInSession", err)
+	}
+
+	err = store.Clients.Delete(johnId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Clients.Get(johnId)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v for deleted client, want sql.ErrNoRows", err)
+	}
+	clientIds, _ := store.Track.ListClientIds(toId)
+	if len(clientIds) != 0 {
+		t.Errorf("got tracking %v for deleted client, want none", clientIds)
+	}
+}
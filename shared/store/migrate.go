@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migrations are named <version>_<name>.sql, e.g. 0001_baseline.sql,
+// and are applied in order of version. Applied migrations must never
+// be edited, add a new migration instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	query   string
+}
+
+// Brings the database up to the latest schema version. Each
+// migration is applied at most once, in its own transaction,
+// and recorded in the schema_version table. Safe to call from
+// both the server and the telebot at the same time.
+func Migrate(db *sql.DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	// A single connection is needed for BEGIN IMMEDIATE
+	// and the pragma to apply to the statements that follow
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Waits for the other process if it is migrating as well
+	_, err = conn.ExecContext(ctx, "PRAGMA busy_timeout = 10000")
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME NOT NULL DEFAULT current_timestamp
+		)`)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		err = applyMigration(ctx, conn, migration)
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %w",
+				migration.version, migration.name, err)
+		}
+	}
+	return nil
+}
+
+// Applies the migration unless it has already been applied.
+// The version is checked within the transaction so that only
+// one process applies it.
+func applyMigration(ctx context.Context, conn *sql.Conn,
+	migration migration) (err error) {
+	_, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			conn.ExecContext(ctx, "ROLLBACK")
+		}
+	}()
+
+	var count int
+	err = conn.QueryRowContext(ctx,
+		`SELECT COUNT(*)
+		FROM schema_version
+		WHERE version = $1
+		`, migration.version).Scan(&count)
+	if err != nil {
+		return err
+	} else if count > 0 {
+		_, err = conn.ExecContext(ctx, "COMMIT")
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx, migration.query)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx,
+		`INSERT INTO schema_version
+			(version, name)
+		VALUES ($1, $2)
+		`, migration.version, migration.name)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx, "COMMIT")
+	return err
+}
+
+// Gets the current schema version, 0 if no
+// migrations have been applied yet
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRow(
+		`SELECT MAX(version)
+		FROM schema_version`).Scan(&version)
+	return int(version.Int64), err
+}
+
+// Reads the embedded migrations, sorted by version
+func loadMigrations() ([]migration, error) {
+	filenames, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, filename := range filenames {
+		base := strings.TrimSuffix(strings.TrimPrefix(filename, "migrations/"), ".sql")
+		versionString, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionString)
+		if !found || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration filename %q", filename)
+		}
+
+		query, err := migrationFiles.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{
+			version: version,
+			name:    name,
+			query:   string(query),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("duplicate migration version %d",
+				migrations[i].version)
+		}
+	}
+	return migrations, nil
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	db := newTestDB(t)
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := migrations[len(migrations)-1].version
+	if version != latest {
+		t.Fatalf("got version %d, want %d", version, latest)
+	}
+
+	err = Migrate(db)
+	if err != nil {
+		t.Fatalf("migrating twice: %v", err)
+	}
+	again, _ := SchemaVersion(db)
+	if again != version {
+		t.Fatalf("got version %d after migrating twice, want %d", again, version)
+	}
+}
+
+// Databases created with the old schema.sql, before versioned
+// migrations, need to be upgraded without losing data
+func TestMigrateKeepsExistingData(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	_, err = db.Exec(
+		`CREATE TABLE Clients (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			first_name TEXT NOT NULL,
+			last_name TEXT NOT NULL,
+			gender TEXT NOT NULL,
+			urination INTEGER NOT NULL DEFAULT 300,
+			defecation INTEGER NOT NULL DEFAULT 600,
+			last_record DATETIME NOT NULL DEFAULT current_timestamp
+		);
+		CREATE TABLE ToiletEntries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			client_id INTEGER,
+			business_type TEXT NOT NULL,
+			duration INTEGER NOT NULL
+		);
+		INSERT INTO Clients (first_name, last_name, gender)
+		VALUES ('John', 'Doe', 'male');
+		INSERT INTO ToiletEntries (client_id, business_type, duration)
+		VALUES (1, 'urination', 42);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := New(db)
+	client, err := store.Clients.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.FirstName != "John" {
+		t.Errorf("got first name %q, want John", client.FirstName)
+	}
+
+	entry, err := store.ToiletEntries.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Duration != 42 || entry.Outcome != "complete" {
+		t.Errorf("got entry %+v, want duration 42 and outcome complete", entry)
+	}
+}
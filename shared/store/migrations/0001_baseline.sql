@@ -1,5 +1,6 @@
-DROP TABLE IF EXISTS TOfficers;
-CREATE TABLE TOfficers (
+-- Schema before versioned migrations were introduced. Tables
+-- are only created if missing, so existing databases are kept.
+CREATE TABLE IF NOT EXISTS TOfficers (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     username TEXT NOT NULL,
     first_name TEXT DEFAULT '',
@@ -9,8 +10,7 @@ CREATE TABLE TOfficers (
     type TEXT NOT NULL DEFAULT 'user'
 );
 
-DROP TABLE IF EXISTS Clients;
-CREATE TABLE Clients (
+CREATE TABLE IF NOT EXISTS Clients (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     first_name TEXT NOT NULL,
     last_name TEXT NOT NULL,
@@ -20,8 +20,7 @@ CREATE TABLE Clients (
     last_record DATETIME NOT NULL DEFAULT current_timestamp
 );
 
-DROP TABLE IF EXISTS Track;
-CREATE TABLE Track (
+CREATE TABLE IF NOT EXISTS Track (
     to_id  INTEGER,
     client_id INTEGER,
     FOREIGN KEY (to_id) REFERENCES TOfficers (id),
@@ -29,17 +28,15 @@ CREATE TABLE Track (
     UNIQUE (to_id, client_id)
 );
 
-DROP TABLE IF EXISTS ToiletEntries;
-CREATE TABLE ToiletEntries (
+CREATE TABLE IF NOT EXISTS ToiletEntries (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     client_id INTEGER,
     business_type TEXT NOT NULL,
     duration INTEGER NOT NULL,
     FOREIGN KEY (client_id) REFERENCES Clients (id)
-)
+);
 
-DROP TABLE IF EXISTS Toilets;
-CREATE TABLE Toilets (
+CREATE TABLE IF NOT EXISTS Toilets (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     name TEXT NOT NULL,
     location TEXT NOT NULL
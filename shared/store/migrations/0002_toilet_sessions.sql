@@ -0,0 +1,49 @@
+-- Registered toilets are contacted directly by the server
+ALTER TABLE Toilets ADD COLUMN base_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE Toilets ADD COLUMN secret TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE Sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    client_id INTEGER NOT NULL,
+    toilet_id INTEGER NOT NULL,
+    to_id INTEGER,
+    phase INTEGER NOT NULL DEFAULT 1,
+    status TEXT NOT NULL DEFAULT 'active',
+    started_at DATETIME NOT NULL DEFAULT current_timestamp,
+    entered_at DATETIME,
+    finished_at DATETIME,
+    ended_at DATETIME,
+    cancel_reason TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (client_id) REFERENCES Clients (id),
+    FOREIGN KEY (toilet_id) REFERENCES Toilets (id),
+    FOREIGN KEY (to_id) REFERENCES TOfficers (id)
+);
+-- Only one active session per toilet and per client
+CREATE UNIQUE INDEX SessionsActiveToilet
+    ON Sessions (toilet_id) WHERE status = 'active';
+CREATE UNIQUE INDEX SessionsActiveClient
+    ON Sessions (client_id) WHERE status = 'active';
+
+-- Columns with a non-constant default cannot be added with
+-- ALTER TABLE, so the table is rebuilt with the existing rows.
+CREATE TABLE ToiletEntriesNew (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    client_id INTEGER,
+    session_id INTEGER,
+    business_type TEXT NOT NULL,
+    duration INTEGER NOT NULL,
+    outcome TEXT NOT NULL DEFAULT 'complete',
+    start_time DATETIME,
+    enter_time DATETIME,
+    finish_time DATETIME,
+    exit_time DATETIME,
+    created_at DATETIME NOT NULL DEFAULT current_timestamp,
+    FOREIGN KEY (client_id) REFERENCES Clients (id),
+    FOREIGN KEY (session_id) REFERENCES Sessions (id)
+);
+INSERT INTO ToiletEntriesNew
+    (id, client_id, business_type, duration)
+SELECT id, client_id, business_type, duration
+FROM ToiletEntries;
+DROP TABLE ToiletEntries;
+ALTER TABLE ToiletEntriesNew RENAME TO ToiletEntries;
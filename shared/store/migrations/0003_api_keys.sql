@@ -0,0 +1,17 @@
+-- Keys used by devices (pi, telebot, ...) to call the /ext
+-- and /api/v1 routes. Only the SHA-256 of the key is kept,
+-- the prefix is stored in plain to look the key up.
+CREATE TABLE ApiKeys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL UNIQUE,
+    key_hash TEXT NOT NULL,
+    -- Comma separated, e.g. "send-notification,report-session"
+    scopes TEXT NOT NULL DEFAULT '',
+    created_by INTEGER,
+    created_at DATETIME NOT NULL DEFAULT current_timestamp,
+    rotated_at DATETIME,
+    last_used_at DATETIME,
+    revoked_at DATETIME,
+    FOREIGN KEY (created_by) REFERENCES TOfficers (id)
+);
@@ -0,0 +1,23 @@
+-- Whether suggested thresholds are applied automatically
+ALTER TABLE Clients ADD COLUMN auto_threshold INTEGER NOT NULL DEFAULT 0;
+
+-- Audit trail of changes to the urination and
+-- defecation thresholds of the clients
+CREATE TABLE ThresholdChanges (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    client_id INTEGER NOT NULL,
+    business_type TEXT NOT NULL,
+    old_value INTEGER NOT NULL,
+    new_value INTEGER NOT NULL,
+    -- Either auto or manual
+    source TEXT NOT NULL,
+    -- TO who applied the change, null if automatic
+    to_id INTEGER,
+    -- Number of entries the suggestion was computed from
+    sample_size INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT current_timestamp,
+    FOREIGN KEY (client_id) REFERENCES Clients (id),
+    FOREIGN KEY (to_id) REFERENCES TOfficers (id)
+);
+CREATE INDEX ThresholdChangesClient
+    ON ThresholdChanges (client_id, created_at);
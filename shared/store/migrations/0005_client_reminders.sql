@@ -0,0 +1,15 @@
+-- Toileting reminders sent to the TOs tracking the client,
+-- either every interval since the last record, or at fixed
+-- times of the day, except during the quiet hours
+CREATE TABLE ClientReminders (
+    client_id INTEGER PRIMARY KEY,
+    -- 0 for no interval
+    interval_minutes INTEGER NOT NULL DEFAULT 0,
+    -- Comma separated HH:MM, e.g. "08:00,12:00,16:00"
+    schedule TEXT NOT NULL DEFAULT '',
+    -- HH:MM, both empty for no quiet hours
+    quiet_start TEXT NOT NULL DEFAULT '',
+    quiet_end TEXT NOT NULL DEFAULT '',
+    last_reminded_at DATETIME,
+    FOREIGN KEY (client_id) REFERENCES Clients (id)
+);
@@ -0,0 +1,28 @@
+-- Alerts sent to the TOs tracking a client, escalated
+-- until one of the TOs acknowledges the alert
+CREATE TABLE Alerts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    client_id INTEGER NOT NULL,
+    message TEXT NOT NULL,
+    -- 0 tracking TOs, 1 tracking TOs again, 2 admins,
+    -- 3 fallback group chat
+    escalation_level INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT current_timestamp,
+    escalated_at DATETIME NOT NULL DEFAULT current_timestamp,
+    acknowledged_at DATETIME,
+    acknowledged_by INTEGER,
+    FOREIGN KEY (client_id) REFERENCES Clients (id),
+    FOREIGN KEY (acknowledged_by) REFERENCES TOfficers (id)
+);
+
+CREATE INDEX alerts_acknowledged_at ON Alerts (acknowledged_at);
+
+-- Telegram messages sent for an alert, so that they
+-- can be updated once the alert is acknowledged
+CREATE TABLE AlertMessages (
+    alert_id INTEGER NOT NULL,
+    chat_id TEXT NOT NULL,
+    message_id INTEGER NOT NULL,
+    PRIMARY KEY (alert_id, chat_id, message_id),
+    FOREIGN KEY (alert_id) REFERENCES Alerts (id)
+);
@@ -0,0 +1,13 @@
+-- Channels each TO is notified through, TOs without
+-- any are notified through telegram
+CREATE TABLE NotificationChannels (
+    to_id INTEGER NOT NULL,
+    -- telegram, email, webhook or ntfy
+    channel TEXT NOT NULL,
+    -- Email address, webhook URL or ntfy topic,
+    -- empty for telegram which uses the chat id
+    address TEXT NOT NULL DEFAULT '',
+    enabled INTEGER NOT NULL DEFAULT 1,
+    PRIMARY KEY (to_id, channel),
+    FOREIGN KEY (to_id) REFERENCES TOfficers (id)
+);
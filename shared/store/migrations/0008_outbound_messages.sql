@@ -0,0 +1,22 @@
+-- Notifications waiting to be sent, or which could not be
+-- sent, through the channels of the TOs. Failed sends are
+-- retried with backoff until they are dead-lettered.
+CREATE TABLE OutboundMessages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- Recipient, NULL for the fallback group chat
+    to_id INTEGER,
+    channel TEXT NOT NULL,
+    address TEXT NOT NULL,
+    -- json of the notification
+    payload TEXT NOT NULL,
+    -- pending, sending, sent or dead
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL DEFAULT current_timestamp,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT current_timestamp,
+    sent_at DATETIME,
+    FOREIGN KEY (to_id) REFERENCES TOfficers (id)
+);
+
+CREATE INDEX outbound_messages_status ON OutboundMessages (status, next_attempt_at);
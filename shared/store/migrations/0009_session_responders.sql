@@ -0,0 +1,4 @@
+-- TO who is on the way to the client during a session,
+-- after responding to an alert of the client
+ALTER TABLE Sessions ADD COLUMN responder_id INTEGER REFERENCES TOfficers (id);
+ALTER TABLE Sessions ADD COLUMN responded_at DATETIME;
@@ -0,0 +1,37 @@
+-- Append-only record of the changes made through the
+-- dashboard, the telegram bot and the external API.
+-- Entries are never updated or removed.
+CREATE TABLE AuditLog (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- TO making the change, 0 for API keys. Not a foreign
+    -- key, so that entries are kept when the TO is removed.
+    actor_id INTEGER NOT NULL DEFAULT 0,
+    -- Username of the TO, or name of the API key
+    actor TEXT NOT NULL,
+    -- web, bot or api
+    source TEXT NOT NULL,
+    -- e.g. create, update, track
+    action TEXT NOT NULL,
+    -- e.g. account, client, session
+    target_type TEXT NOT NULL,
+    target_id INTEGER NOT NULL DEFAULT 0,
+    -- json of the values before and after the change,
+    -- empty if there are none
+    before_value TEXT NOT NULL DEFAULT '',
+    after_value TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX audit_log_created_at ON AuditLog (created_at);
+
+CREATE TRIGGER audit_log_no_update
+BEFORE UPDATE ON AuditLog
+BEGIN
+    SELECT RAISE(ABORT, 'AuditLog is append-only');
+END;
+
+CREATE TRIGGER audit_log_no_delete
+BEFORE DELETE ON AuditLog
+BEGIN
+    SELECT RAISE(ABORT, 'AuditLog is append-only');
+END;
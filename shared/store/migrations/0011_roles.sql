@@ -0,0 +1,4 @@
+-- Accounts which are not admins were of type "user",
+-- which is now the officer role. New accounts are
+-- always created with their role.
+UPDATE TOfficers SET type = 'officer' WHERE type = 'user';
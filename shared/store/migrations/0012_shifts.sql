@@ -0,0 +1,21 @@
+-- Shifts of the TOs. Clients tracked by a TO on shift
+-- are only tracked until the end of the shift.
+CREATE TABLE Shifts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    to_id INTEGER NOT NULL,
+    started_at DATETIME NOT NULL,
+    -- Planned end of the shift
+    ends_at DATETIME NOT NULL,
+    -- When the shift actually ended, NULL while on shift
+    ended_at DATETIME,
+    -- TO the tracked clients were handed over to, if any
+    handover_to_id INTEGER,
+    FOREIGN KEY (to_id) REFERENCES TOfficers (id),
+    FOREIGN KEY (handover_to_id) REFERENCES TOfficers (id)
+);
+
+CREATE INDEX shifts_ended_at ON Shifts (ended_at);
+
+-- When the tracking ends, NULL for tracking
+-- outside of shifts which never expires
+ALTER TABLE Track ADD COLUMN expires_at DATETIME;
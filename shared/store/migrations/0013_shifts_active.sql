@@ -0,0 +1,14 @@
+-- Only one shift per TO at a time. Any shifts started twice
+-- by concurrent requests are ended, keeping the first one.
+UPDATE Shifts
+SET ended_at = started_at
+WHERE ended_at IS NULL
+    AND id NOT IN (
+        SELECT MIN(id)
+        FROM Shifts
+        WHERE ended_at IS NULL
+        GROUP BY to_id
+    );
+
+CREATE UNIQUE INDEX ShiftsActiveTO
+    ON Shifts (to_id) WHERE ended_at IS NULL;
@@ -0,0 +1,80 @@
+package store
+
+// Channels the TOs can be notified through
+const (
+	ChannelTelegram = "telegram"
+	ChannelEmail    = "email"
+	ChannelWebhook  = "webhook"
+	ChannelNtfy     = "ntfy"
+)
+
+// All the channels, in the order they are shown
+var NotificationChannelNames = []string{
+	ChannelTelegram, ChannelEmail, ChannelWebhook, ChannelNtfy,
+}
+
+// A channel chosen by a TO
+type NotificationChannel struct {
+	ToId    int    `json:"toId"`
+	Channel string `json:"channel"`
+	// Email address, webhook URL or ntfy topic,
+	// empty for telegram which uses the chat id
+	Address string `json:"address"`
+	Enabled bool   `json:"enabled"`
+}
+
+type NotificationChannelRepository struct {
+	db DBTX
+}
+
+// Lists the channels chosen by the TO, in the
+// order of NotificationChannelNames
+func (repository *NotificationChannelRepository) ListByTO(
+	toId int) ([]NotificationChannel, error) {
+	rows, err := repository.db.Query(
+		`SELECT to_id, channel, address, enabled
+		FROM NotificationChannels
+		WHERE to_id = $1
+		`, toId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byName := map[string]NotificationChannel{}
+	for rows.Next() {
+		var channel NotificationChannel
+		err := rows.Scan(&channel.ToId, &channel.Channel,
+			&channel.Address, &channel.Enabled)
+		if err != nil {
+			return nil, err
+		}
+		byName[channel.Channel] = channel
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var channels []NotificationChannel
+	for _, name := range NotificationChannelNames {
+		if channel, ok := byName[name]; ok {
+			channels = append(channels, channel)
+		}
+	}
+	return channels, nil
+}
+
+// Sets the channel of the TO, replacing the current one
+func (repository *NotificationChannelRepository) Set(
+	channel NotificationChannel) error {
+	_, err := repository.db.Exec(
+		`INSERT INTO NotificationChannels
+			(to_id, channel, address, enabled)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (to_id, channel) DO UPDATE
+		SET address = excluded.address,
+			enabled = excluded.enabled
+		`, channel.ToId, channel.Channel,
+		channel.Address, channel.Enabled)
+	return err
+}
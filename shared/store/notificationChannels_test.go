@@ -0,0 +1,49 @@
+package store
+
+import "testing"
+
+func TestNotificationChannelRepository(t *testing.T) {
+	store := newTestStore(t)
+	toId := createTestTO(t, store, "user")
+	otherId := createTestTO(t, store, "other")
+
+	channels, err := store.NotificationChannels.ListByTO(toId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(channels) != 0 {
+		t.Errorf("got %+v, want no channels chosen", channels)
+	}
+
+	for _, channel := range []NotificationChannel{
+		{ToId: toId, Channel: ChannelNtfy, Address: "topic", Enabled: true},
+		{ToId: toId, Channel: ChannelTelegram, Enabled: true},
+		{ToId: toId, Channel: ChannelEmail, Address: "old@example.com", Enabled: true},
+		{ToId: otherId, Channel: ChannelWebhook, Address: "http://example.com", Enabled: true},
+		// Replaces the email above
+		{ToId: toId, Channel: ChannelEmail, Address: "new@example.com", Enabled: false},
+	} {
+		err = store.NotificationChannels.Set(channel)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	channels, err = store.NotificationChannels.ListByTO(toId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []NotificationChannel{
+		{ToId: toId, Channel: ChannelTelegram, Enabled: true},
+		{ToId: toId, Channel: ChannelEmail, Address: "new@example.com", Enabled: false},
+		{ToId: toId, Channel: ChannelNtfy, Address: "topic", Enabled: true},
+	}
+	if len(channels) != len(want) {
+		t.Fatalf("got %+v, want %+v", channels, want)
+	}
+	for i := range want {
+		if channels[i] != want[i] {
+			t.Errorf("got %+v at %d, want %+v", channels[i], i, want[i])
+		}
+	}
+}
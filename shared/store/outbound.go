@@ -0,0 +1,277 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Statuses of an outbound message
+const (
+	OutboundStatusPending = "pending"
+	OutboundStatusSending = "sending"
+	OutboundStatusSent    = "sent"
+	// Failed too many times, or could never be sent
+	OutboundStatusDead = "dead"
+)
+
+// A notification to send through a channel
+type OutboundMessage struct {
+	Id int `json:"id"`
+	// Recipient, 0 for the fallback group chat
+	ToId    int    `json:"toId"`
+	Channel string `json:"channel"`
+	Address string `json:"address"`
+	// json of the notification
+	Payload       string     `json:"payload"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt"`
+	LastError     string     `json:"lastError"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	SentAt        *time.Time `json:"sentAt"`
+}
+
+// Filters for listing outbound messages, empty fields are ignored
+type OutboundFilter struct {
+	Status string
+	Page
+}
+
+type OutboundRepository struct {
+	db DBTX
+}
+
+const outboundColumns = `id, COALESCE(to_id, 0), channel,
+	address, payload, status,
+	attempts, next_attempt_at, last_error,
+	created_at, sent_at`
+
+func scanOutbound(row interface{ Scan(...any) error }) (OutboundMessage, error) {
+	var message OutboundMessage
+	var sentAt sql.NullTime
+	err := row.Scan(
+		&message.Id, &message.ToId, &message.Channel,
+		&message.Address, &message.Payload, &message.Status,
+		&message.Attempts, &message.NextAttemptAt, &message.LastError,
+		&message.CreatedAt, &sentAt,
+	)
+	message.SentAt = nullTimePointer(sentAt)
+	return message, err
+}
+
+func (repository *OutboundRepository) query(query string,
+	args ...any) ([]OutboundMessage, error) {
+	rows, err := repository.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []OutboundMessage
+	for rows.Next() {
+		message, err := scanOutbound(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// Gets the message with the id supplied
+func (repository *OutboundRepository) Get(id int) (OutboundMessage, error) {
+	return scanOutbound(repository.db.QueryRow(
+		`SELECT `+outboundColumns+`
+		FROM OutboundMessages
+		WHERE id = $1
+		`, id))
+}
+
+// Lists the messages matching the filter, newest first,
+// along with the total number of matching messages
+func (repository *OutboundRepository) Find(
+	filter OutboundFilter) ([]OutboundMessage, int, error) {
+	var where whereClause
+	if filter.Status != "" {
+		where.add("status = %[1]s", filter.Status)
+	}
+
+	var total int
+	err := repository.db.QueryRow(
+		`SELECT COUNT(*)
+		FROM OutboundMessages
+		`+where.String(), where.args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pageClause, args := where.page(filter.Page)
+	messages, err := repository.query(
+		`SELECT `+outboundColumns+`
+		FROM OutboundMessages
+		`+where.String()+`
+		ORDER BY id DESC
+		`+pageClause, args...)
+	return messages, total, err
+}
+
+// Queues the message to be sent as soon as possible,
+// returning the id of the message
+func (repository *OutboundRepository) Enqueue(message OutboundMessage) (int, error) {
+	var toId sql.NullInt64
+	if message.ToId != 0 {
+		toId = sql.NullInt64{Int64: int64(message.ToId), Valid: true}
+	}
+
+	result, err := repository.db.Exec(
+		`INSERT INTO OutboundMessages
+			(to_id, channel, address,
+			payload, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5)
+		`, toId, message.Channel, message.Address,
+		message.Payload, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Marks up to the limit of pending messages which are due
+// as sending, oldest first, returning them. Messages are
+// only claimed once, even by concurrent callers.
+func (repository *OutboundRepository) Claim(now time.Time,
+	limit int) ([]OutboundMessage, error) {
+	rows, err := repository.db.Query(
+		`UPDATE OutboundMessages
+		SET status = $1
+		WHERE id IN (
+			SELECT id
+			FROM OutboundMessages
+			WHERE status = $2
+				AND next_attempt_at <= $3
+			ORDER BY next_attempt_at, id
+			LIMIT $4
+		)
+		RETURNING id
+		`, OutboundStatusSending, OutboundStatusPending, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var messages []OutboundMessage
+	for _, id := range ids {
+		message, err := repository.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// Records that the message was sent
+func (repository *OutboundRepository) MarkSent(id int, sentAt time.Time) error {
+	result, err := repository.db.Exec(
+		`UPDATE OutboundMessages
+		SET status = $1,
+			attempts = attempts + 1,
+			sent_at = $2,
+			last_error = ''
+		WHERE id = $3
+		`, OutboundStatusSent, sentAt.UTC(), id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Records that sending the message failed, to be retried later
+func (repository *OutboundRepository) Retry(id int, lastError string,
+	nextAttemptAt time.Time) error {
+	result, err := repository.db.Exec(
+		`UPDATE OutboundMessages
+		SET status = $1,
+			attempts = attempts + 1,
+			next_attempt_at = $2,
+			last_error = $3
+		WHERE id = $4
+		`, OutboundStatusPending, nextAttemptAt.UTC(), lastError, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Records that sending the message failed for the last time
+func (repository *OutboundRepository) MarkDead(id int, lastError string) error {
+	result, err := repository.db.Exec(
+		`UPDATE OutboundMessages
+		SET status = $1,
+			attempts = attempts + 1,
+			last_error = $2
+		WHERE id = $3
+		`, OutboundStatusDead, lastError, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Queues a dead message to be sent again as soon as possible,
+// with its attempts reset. Returns sql.ErrNoRows if there is
+// no such dead message.
+func (repository *OutboundRepository) Resend(id int) error {
+	result, err := repository.db.Exec(
+		`UPDATE OutboundMessages
+		SET status = $1,
+			attempts = 0,
+			next_attempt_at = $2
+		WHERE id = $3
+			AND status = $4
+		`, OutboundStatusPending, time.Now().UTC(), id, OutboundStatusDead)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Returns the messages which were being sent to pending,
+// e.g. after the server stopped while sending them.
+// Returns the number of messages returned.
+func (repository *OutboundRepository) ResetSending() (int, error) {
+	result, err := repository.db.Exec(
+		`UPDATE OutboundMessages
+		SET status = $1
+		WHERE status = $2
+		`, OutboundStatusPending, OutboundStatusSending)
+	if err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+// Removes the messages sent before the time supplied
+func (repository *OutboundRepository) DeleteSentBefore(before time.Time) error {
+	_, err := repository.db.Exec(
+		`DELETE FROM OutboundMessages
+		WHERE status = $1
+			AND sent_at < $2
+		`, OutboundStatusSent, before.UTC())
+	return err
+}
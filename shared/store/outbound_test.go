@@ -0,0 +1,109 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestOutboundRepository(t *testing.T) {
+	store := newTestStore(t)
+	toId := createTestTO(t, store, "user")
+
+	var ids []int
+	for _, message := range []OutboundMessage{
+		{ToId: toId, Channel: ChannelTelegram, Address: "100", Payload: `{"message":"first"}`},
+		{ToId: toId, Channel: ChannelEmail, Address: "user@example.com", Payload: `{"message":"second"}`},
+		{Channel: ChannelTelegram, Address: "-200", Payload: `{"message":"fallback"}`},
+	} {
+		id, err := store.Outbound.Enqueue(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	now := time.Now().Add(time.Second)
+	claimed, err := store.Outbound.Claim(now, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 2 || claimed[0].Id != ids[0] || claimed[1].Id != ids[1] ||
+		claimed[0].Status != OutboundStatusSending || claimed[0].ToId != toId {
+		t.Fatalf("got %+v, want the first 2 messages claimed", claimed)
+	}
+
+	// Claimed messages are not claimed again
+	claimed, _ = store.Outbound.Claim(now, 10)
+	if len(claimed) != 1 || claimed[0].Id != ids[2] || claimed[0].ToId != 0 {
+		t.Fatalf("got %+v, want only the fallback message", claimed)
+	}
+
+	err = store.Outbound.MarkSent(ids[0], now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Outbound.Retry(ids[1], "connection refused", now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Outbound.MarkDead(ids[2], "chat not found")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Retried messages are only claimed once due
+	claimed, _ = store.Outbound.Claim(now, 10)
+	if len(claimed) != 0 {
+		t.Errorf("got %+v, want none due", claimed)
+	}
+	claimed, _ = store.Outbound.Claim(now.Add(2*time.Minute), 10)
+	if len(claimed) != 1 || claimed[0].Id != ids[1] ||
+		claimed[0].Attempts != 1 || claimed[0].LastError != "connection refused" {
+		t.Errorf("got %+v, want the retried message", claimed)
+	}
+
+	// Messages left sending are returned to pending
+	count, err := store.Outbound.ResetSending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got %d reset, want 1", count)
+	}
+
+	dead, total, err := store.Outbound.Find(OutboundFilter{Status: OutboundStatusDead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(dead) != 1 || dead[0].Id != ids[2] {
+		t.Errorf("got %+v, want the dead message", dead)
+	}
+
+	// Only dead messages can be resent
+	err = store.Outbound.Resend(ids[0])
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v resending a sent message, want sql.ErrNoRows", err)
+	}
+	err = store.Outbound.Resend(ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, _ := store.Outbound.Get(ids[2])
+	if message.Status != OutboundStatusPending || message.Attempts != 0 {
+		t.Errorf("got %+v, want pending with attempts reset", message)
+	}
+
+	sent, _ := store.Outbound.Get(ids[0])
+	if sent.SentAt == nil || sent.Status != OutboundStatusSent {
+		t.Errorf("got %+v, want sent", sent)
+	}
+	err = store.Outbound.DeleteSentBefore(now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, total, _ = store.Outbound.Find(OutboundFilter{})
+	if total != 2 {
+		t.Errorf("got %d messages, want 2 after removing the sent message", total)
+	}
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits the rows listed. A limit of 0 lists all rows.
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// Builds the WHERE clause of a query from optional
+// conditions, numbering the parameters in order
+type whereClause struct {
+	conditions []string
+	args       []any
+}
+
+// Adds a condition, with %[1]s as the placeholder
+// for the argument, e.g. "name LIKE %[1]s"
+func (where *whereClause) add(condition string, arg any) {
+	where.args = append(where.args, arg)
+	placeholder := "$" + strconv.Itoa(len(where.args))
+	where.conditions = append(where.conditions,
+		fmt.Sprintf(condition, placeholder))
+}
+
+func (where *whereClause) String() string {
+	if len(where.conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(where.conditions, "\n\t\tAND ")
+}
+
+// Gets the LIMIT and OFFSET clause for the page,
+// along with all the arguments of the query
+func (where *whereClause) page(page Page) (string, []any) {
+	limit := page.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	offset := page.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	count := len(where.args)
+	clause := fmt.Sprintf("LIMIT $%d OFFSET $%d", count+1, count+2)
+	return clause, append(where.args[:count:count], limit, offset)
+}
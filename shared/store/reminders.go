@@ -0,0 +1,164 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Toileting reminders of a client, sent to the TOs tracking
+// the client. Times of the day are HH:MM, in the local time
+// of the server.
+type ClientReminder struct {
+	ClientId int `json:"clientId"`
+	// Minutes since the last record after which the client
+	// is due, 0 for no interval
+	IntervalMinutes int `json:"intervalMinutes"`
+	// Times of the day at which the client is due
+	Schedule []string `json:"schedule"`
+	// No reminders are sent from the start to the end,
+	// both empty for no quiet hours
+	QuietStart     string     `json:"quietStart"`
+	QuietEnd       string     `json:"quietEnd"`
+	LastRemindedAt *time.Time `json:"lastRemindedAt"`
+}
+
+// Whether any reminders are configured
+func (reminder ClientReminder) IsEnabled() bool {
+	return reminder.IntervalMinutes > 0 || len(reminder.Schedule) > 0
+}
+
+// A client with reminders configured, along with
+// their active session, if any
+type ActiveReminder struct {
+	ClientReminder
+	Client TrackedClient
+}
+
+type ReminderRepository struct {
+	db DBTX
+}
+
+const reminderColumns = `ClientReminders.client_id,
+	ClientReminders.interval_minutes, ClientReminders.schedule,
+	ClientReminders.quiet_start, ClientReminders.quiet_end,
+	ClientReminders.last_reminded_at`
+
+func reminderScanArgs(reminder *ClientReminder, schedule *string,
+	lastRemindedAt *sql.NullTime) []any {
+	return []any{
+		&reminder.ClientId,
+		&reminder.IntervalMinutes, schedule,
+		&reminder.QuietStart, &reminder.QuietEnd,
+		lastRemindedAt,
+	}
+}
+
+func setReminderScanned(reminder *ClientReminder, schedule string,
+	lastRemindedAt sql.NullTime) {
+	if schedule != "" {
+		reminder.Schedule = strings.Split(schedule, ",")
+	}
+	reminder.LastRemindedAt = nullTimePointer(lastRemindedAt)
+}
+
+// Gets the reminders of the client, which are all
+// disabled if none have been configured
+func (repository *ReminderRepository) Get(clientId int) (ClientReminder, error) {
+	var reminder ClientReminder
+	var schedule string
+	var lastRemindedAt sql.NullTime
+	err := repository.db.QueryRow(
+		`SELECT `+reminderColumns+`
+		FROM ClientReminders
+		WHERE client_id = $1
+		`, clientId).
+		Scan(reminderScanArgs(&reminder, &schedule, &lastRemindedAt)...)
+	if err == sql.ErrNoRows {
+		return ClientReminder{ClientId: clientId}, nil
+	} else if err != nil {
+		return ClientReminder{}, err
+	}
+	setReminderScanned(&reminder, schedule, lastRemindedAt)
+	return reminder, nil
+}
+
+// Sets the reminders of the client. When the reminders
+// were last sent is kept.
+func (repository *ReminderRepository) Set(reminder ClientReminder) error {
+	_, err := repository.db.Exec(
+		`INSERT INTO ClientReminders
+			(client_id, interval_minutes, schedule,
+			quiet_start, quiet_end)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (client_id) DO UPDATE
+		SET interval_minutes = excluded.interval_minutes,
+			schedule = excluded.schedule,
+			quiet_start = excluded.quiet_start,
+			quiet_end = excluded.quiet_end
+		`, reminder.ClientId, reminder.IntervalMinutes,
+		strings.Join(reminder.Schedule, ","),
+		reminder.QuietStart, reminder.QuietEnd)
+	return err
+}
+
+// Lists the clients with reminders configured, ordered by id
+func (repository *ReminderRepository) ListActive() ([]ActiveReminder, error) {
+	rows, err := repository.db.Query(
+		`SELECT ` + reminderColumns + `,
+			` + clientColumns + `,
+			` + trackedClientSessionColumns + `
+		FROM ClientReminders
+		INNER JOIN Clients
+			ON ClientReminders.client_id = Clients.id
+		` + trackedClientJoins + `
+		WHERE ClientReminders.interval_minutes > 0
+			OR ClientReminders.schedule != ''
+		ORDER BY Clients.id
+		`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reminders []ActiveReminder
+	for rows.Next() {
+		var reminder ActiveReminder
+		var schedule string
+		var lastRemindedAt sql.NullTime
+		var phase sql.NullInt32
+		var toiletName, responder sql.NullString
+		client := &reminder.Client
+		err := rows.Scan(append(
+			reminderScanArgs(&reminder.ClientReminder, &schedule, &lastRemindedAt),
+			&client.Id, &client.FirstName,
+			&client.LastName, &client.Gender,
+			&client.Urination, &client.Defecation,
+			&client.LastRecord, &client.AutoThreshold,
+			&phase, &toiletName, &responder,
+		)...)
+		if err != nil {
+			return nil, err
+		}
+		setReminderScanned(&reminder.ClientReminder, schedule, lastRemindedAt)
+		client.SessionPhase = int(phase.Int32)
+		client.ToiletName = toiletName.String
+		client.Responder = responder.String
+		reminders = append(reminders, reminder)
+	}
+	return reminders, rows.Err()
+}
+
+// Records when a reminder was last sent for the client
+func (repository *ReminderRepository) SetLastReminded(clientId int,
+	remindedAt time.Time) error {
+	result, err := repository.db.Exec(
+		`UPDATE ClientReminders
+		SET last_reminded_at = $1
+		WHERE client_id = $2
+		`, remindedAt, clientId)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
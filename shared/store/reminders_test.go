@@ -0,0 +1,81 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestReminderRepository(t *testing.T) {
+	db := newTestDB(t)
+	store := New(db)
+	clientId := createTestClient(t, store, "John", "Doe")
+	otherId := createTestClient(t, store, "Jane", "Doe")
+	toiletId := createTestToilet(t, store, "Toilet")
+
+	// Clients without reminders have them all disabled
+	reminder, err := store.Reminders.Get(clientId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reminder.ClientId != clientId || reminder.IsEnabled() {
+		t.Errorf("got %+v, want disabled reminders", reminder)
+	}
+
+	err = store.Reminders.Set(ClientReminder{
+		ClientId:        clientId,
+		IntervalMinutes: 120,
+		Schedule:        []string{"08:00", "12:30"},
+		QuietStart:      "22:00",
+		QuietEnd:        "07:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Reminders.Set(ClientReminder{ClientId: otherId})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remindedAt := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+	err = store.Reminders.SetLastReminded(clientId, remindedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Reminders.SetLastReminded(clientId+100, remindedAt)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v for missing reminders, want sql.ErrNoRows", err)
+	}
+
+	// Setting the reminders again keeps when they were last sent
+	err = store.Reminders.Set(ClientReminder{
+		ClientId:        clientId,
+		IntervalMinutes: 90,
+		Schedule:        []string{"08:00", "12:30"},
+		QuietStart:      "22:00",
+		QuietEnd:        "07:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reminder, _ = store.Reminders.Get(clientId)
+	if reminder.IntervalMinutes != 90 || len(reminder.Schedule) != 2 ||
+		reminder.Schedule[1] != "12:30" || reminder.QuietStart != "22:00" ||
+		reminder.LastRemindedAt == nil || !reminder.LastRemindedAt.Equal(remindedAt) {
+		t.Errorf("got %+v, want the updated reminders", reminder)
+	}
+
+	// Only clients with reminders enabled are listed,
+	// along with their active session
+	startTestSession(t, db, clientId, toiletId)
+	reminders, err := store.Reminders.ListActive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reminders) != 1 || reminders[0].Client.Id != clientId ||
+		reminders[0].Client.SessionPhase != 2 ||
+		reminders[0].Client.ToiletName != "Toilet" ||
+		reminders[0].IntervalMinutes != 90 {
+		t.Errorf("got %+v, want the reminders of client %d", reminders, clientId)
+	}
+}
@@ -0,0 +1,93 @@
+package store
+
+// Roles of the TOs, stored as the type of the TO
+const (
+	RoleAdmin      = "admin"
+	RoleSupervisor = "supervisor"
+	RoleOfficer    = "officer"
+	RoleViewer     = "viewer"
+)
+
+// Permissions granted to the roles
+const (
+	PermissionTrack            = "track"             // tracking clients and their sessions
+	PermissionViewClients      = "view-clients"      // clients and their analytics
+	PermissionEditClients      = "edit-clients"      // new clients, thresholds and reminders
+	PermissionAssignClients    = "assign-clients"    // tracking of clients by other TOs
+	PermissionBroadcast        = "broadcast"         // messages to all TOs
+	PermissionViewAudit        = "view-audit"        // audit log
+	PermissionManageAccounts   = "manage-accounts"   // TO accounts
+	PermissionManageToilets    = "manage-toilets"    // toilets
+	PermissionManageApiKeys    = "manage-api-keys"   // API keys
+	PermissionManageDeliveries = "manage-deliveries" // failed notifications
+)
+
+var (
+	// From the most to the least access
+	Roles = []string{
+		RoleAdmin,
+		RoleSupervisor,
+		RoleOfficer,
+		RoleViewer,
+	}
+
+	rolePermissions = map[string][]string{
+		RoleAdmin: {
+			PermissionTrack,
+			PermissionViewClients,
+			PermissionEditClients,
+			PermissionAssignClients,
+			PermissionBroadcast,
+			PermissionViewAudit,
+			PermissionManageAccounts,
+			PermissionManageToilets,
+			PermissionManageApiKeys,
+			PermissionManageDeliveries,
+		},
+		RoleSupervisor: {
+			PermissionTrack,
+			PermissionViewClients,
+			PermissionEditClients,
+			PermissionAssignClients,
+			PermissionBroadcast,
+			PermissionViewAudit,
+		},
+		RoleOfficer: {
+			PermissionTrack,
+			PermissionViewClients,
+		},
+		RoleViewer: {
+			PermissionViewClients,
+		},
+	}
+)
+
+// Type of the accounts which were not admins before the roles,
+// migrated to RoleOfficer but still held by older sessions
+const legacyRoleUser = "user"
+
+// Gets the role of the type of a TO, mapping the legacy
+// "user" type to RoleOfficer
+func RoleOf(userType string) string {
+	if userType == legacyRoleUser {
+		return RoleOfficer
+	}
+	return userType
+}
+
+// Whether the role is one of Roles
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Whether the role is granted the permission. Unknown
+// roles are not granted any permissions.
+func HasPermission(role string, permission string) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package store
+
+import "testing"
+
+func TestHasPermission(t *testing.T) {
+	for _, test := range []struct {
+		role       string
+		permission string
+		want       bool
+	}{
+		{RoleAdmin, PermissionManageAccounts, true},
+		{RoleSupervisor, PermissionManageAccounts, false},
+		{RoleSupervisor, PermissionAssignClients, true},
+		{RoleOfficer, PermissionTrack, true},
+		{RoleOfficer, PermissionEditClients, false},
+		{RoleViewer, PermissionViewClients, true},
+		{RoleViewer, PermissionTrack, false},
+		// Accounts from before roles were introduced
+		{"user", PermissionViewClients, false},
+	} {
+		got := HasPermission(test.role, test.permission)
+		if got != test.want {
+			t.Errorf("got %v for %s %s, want %v", got, test.role, test.permission, test.want)
+		}
+	}
+
+	for _, role := range Roles {
+		if !IsValidRole(role) {
+			t.Errorf("got %s invalid, want valid", role)
+		}
+		if !HasPermission(role, PermissionViewClients) {
+			t.Errorf("got %s without %s, want every role to have it", role, PermissionViewClients)
+		}
+	}
+	if IsValidRole("user") {
+		t.Error("got user valid, want invalid")
+	}
+}
+
+func TestRoleOf(t *testing.T) {
+	for _, test := range []struct {
+		userType string
+		want     string
+	}{
+		{RoleAdmin, RoleAdmin},
+		{RoleViewer, RoleViewer},
+		// Sessions from before roles were introduced
+		{"user", RoleOfficer},
+	} {
+		got := RoleOf(test.userType)
+		if got != test.want {
+			t.Errorf("got %s for %s, want %s", got, test.userType, test.want)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Phases of an active session, which only move forward
+const (
+	SessionPhaseWaiting  = 1 // Waiting for the client to enter
+	SessionPhaseEntered  = 2 // Client is in the toilet
+	SessionPhaseFinished = 3 // Client has finished their business
+)
+
+// Status of a session, only one session may be active
+// per client and per toilet
+const (
+	SessionStatusActive    = "active"
+	SessionStatusCompleted = "completed"
+	SessionStatusCancelled = "cancelled"
+)
+
+var ErrSessionPhase = errors.New("invalid session phase")
+
+// A visit of a client to a toilet, from when it is started by
+// a TO until the toilet reports the result or it is cancelled
+type Session struct {
+	Id       int `json:"id"`
+	ClientId int `json:"clientId"`
+	ToiletId int `json:"toiletId"`
+	// TO who started the session, nil if unknown
+	ToId         *int       `json:"toId"`
+	Phase        int        `json:"phase"`
+	Status       string     `json:"status"`
+	StartedAt    time.Time  `json:"startedAt"`
+	EnteredAt    *time.Time `json:"enteredAt"`
+	FinishedAt   *time.Time `json:"finishedAt"`
+	EndedAt      *time.Time `json:"endedAt"`
+	CancelReason string     `json:"cancelReason"`
+	// TO on the way to the client after an alert, nil if none
+	ResponderId *int       `json:"responderId"`
+	RespondedAt *time.Time `json:"respondedAt"`
+}
+
+type SessionRepository struct {
+	db DBTX
+}
+
+const sessionColumns = `id, client_id, toilet_id,
+	to_id, phase, status,
+	started_at, entered_at, finished_at,
+	ended_at, cancel_reason, responder_id,
+	responded_at`
+
+func scanSession(row interface{ Scan(...any) error }) (Session, error) {
+	var session Session
+	err := row.Scan(
+		&session.Id, &session.ClientId, &session.ToiletId,
+		&session.ToId, &session.Phase, &session.Status,
+		&session.StartedAt, &session.EnteredAt, &session.FinishedAt,
+		&session.EndedAt, &session.CancelReason, &session.ResponderId,
+		&session.RespondedAt,
+	)
+	return session, err
+}
+
+// Records a new active session for the client at the toilet,
+// returning ErrClientInSession or ErrToiletInUse if either
+// already has one. toId is the TO starting the session, 0 if
+// unknown. Should be used within a transaction.
+func (repository *SessionRepository) Start(clientId int,
+	toiletId int, toId int) (Session, error) {
+	var count int
+	err := repository.db.QueryRow(
+		`SELECT COUNT(*)
+		FROM Sessions
+		WHERE client_id = $1
+			AND status = $2
+		`, clientId, SessionStatusActive).Scan(&count)
+	if err != nil {
+		return Session{}, err
+	} else if count > 0 {
+		return Session{}, ErrClientInSession
+	}
+
+	err = repository.db.QueryRow(
+		`SELECT COUNT(*)
+		FROM Sessions
+		WHERE toilet_id = $1
+			AND status = $2
+		`, toiletId, SessionStatusActive).Scan(&count)
+	if err != nil {
+		return Session{}, err
+	} else if count > 0 {
+		return Session{}, ErrToiletInUse
+	}
+
+	var initiator *int
+	if toId != 0 {
+		initiator = &toId
+	}
+
+	result, err := repository.db.Exec(
+		`INSERT INTO Sessions
+			(client_id, toilet_id, to_id,
+			phase, status, started_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		`, clientId, toiletId, initiator,
+		SessionPhaseWaiting, SessionStatusActive,
+		time.Now().UTC())
+	if err != nil {
+		// Backstop for concurrent requests, the unique
+		// indexes only allow one active session
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) &&
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return Session{}, ErrToiletInUse
+		}
+		return Session{}, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Session{}, err
+	}
+	return repository.Get(int(id))
+}
+
+// Gets the session regardless of its status
+func (repository *SessionRepository) Get(id int) (Session, error) {
+	return scanSession(repository.db.QueryRow(
+		`SELECT `+sessionColumns+`
+		FROM Sessions
+		WHERE id = $1
+		`, id))
+}
+
+// Gets the active session of the client
+func (repository *SessionRepository) GetActiveByClient(clientId int) (Session, error) {
+	return scanSession(repository.db.QueryRow(
+		`SELECT `+sessionColumns+`
+		FROM Sessions
+		WHERE client_id = $1
+			AND status = $2
+		`, clientId, SessionStatusActive))
+}
+
+// Gets the client of the session regardless of its status
+func (repository *SessionRepository) GetClientId(id int) (int, error) {
+	var clientId int
+	err := repository.db.QueryRow(
+		`SELECT client_id
+		FROM Sessions
+		WHERE id = $1
+		`, id).Scan(&clientId)
+	return clientId, err
+}
+
+// Lists the active sessions, oldest first
+func (repository *SessionRepository) ListActive() ([]Session, error) {
+	rows, err := repository.db.Query(
+		`SELECT `+sessionColumns+`
+		FROM Sessions
+		WHERE status = $1
+		ORDER BY started_at
+		`, SessionStatusActive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// Moves the active session forward to the phase supplied,
+// returning ErrSessionPhase for any other phase
+func (repository *SessionRepository) SetPhase(id int,
+	phase int) (Session, error) {
+	var timestampColumn string
+	switch phase {
+	case SessionPhaseEntered:
+		timestampColumn = "entered_at"
+	case SessionPhaseFinished:
+		timestampColumn = "finished_at"
+	default:
+		return Session{}, ErrSessionPhase
+	}
+
+	result, err := repository.db.Exec(
+		`UPDATE Sessions SET
+			phase = $1,
+			`+timestampColumn+` = $2
+		WHERE id = $3
+			AND status = $4
+			AND phase < $1
+		`, phase, time.Now().UTC(),
+		id, SessionStatusActive)
+	if err != nil {
+		return Session{}, err
+	}
+
+	err = checkRowsAffected(result)
+	if err != nil {
+		// Either the session is no longer active,
+		// or is already past the phase
+		session, getErr := repository.Get(id)
+		if getErr != nil || session.Status != SessionStatusActive {
+			return Session{}, err
+		}
+		return session, ErrSessionPhase
+	}
+	return repository.Get(id)
+}
+
+// Records that the TO is on the way to the client
+// of the active session
+func (repository *SessionRepository) SetResponder(id int,
+	toId int) (Session, error) {
+	result, err := repository.db.Exec(
+		`UPDATE Sessions SET
+			responder_id = $1,
+			responded_at = $2
+		WHERE id = $3
+			AND status = $4
+		`, toId, time.Now().UTC(),
+		id, SessionStatusActive)
+	if err != nil {
+		return Session{}, err
+	}
+	err = checkRowsAffected(result)
+	if err != nil {
+		return Session{}, err
+	}
+	return repository.Get(id)
+}
+
+// Ends the active session with the status supplied. The
+// cancel reason is only relevant for cancelled sessions.
+func (repository *SessionRepository) End(id int, status string,
+	cancelReason string) error {
+	result, err := repository.db.Exec(
+		`UPDATE Sessions SET
+			status = $1,
+			ended_at = $2,
+			cancel_reason = $3
+		WHERE id = $4
+			AND status = $5
+		`, status, time.Now().UTC(), cancelReason,
+		id, SessionStatusActive)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestSessionRepository(t *testing.T) {
+	store := newTestStore(t)
+	toId := createTestTO(t, store, "officer")
+	johnId := createTestClient(t, store, "John", "Doe")
+	janeId := createTestClient(t, store, "Jane", "Doe")
+	toiletId := createTestToilet(t, store, "Level 1 Male")
+	otherToiletId := createTestToilet(t, store, "Level 2 Female")
+
+	session, err := store.Sessions.Start(johnId, toiletId, toId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Phase != SessionPhaseWaiting || session.Status != SessionStatusActive ||
+		session.ToId == nil || *session.ToId != toId {
+		t.Errorf("got %+v, want an active waiting session started by the TO", session)
+	}
+
+	_, err = store.Sessions.Start(johnId, otherToiletId, 0)
+	if err != ErrClientInSession {
+		t.Errorf("got %v starting second session of client, want ErrClientInSession", err)
+	}
+	_, err = store.Sessions.Start(janeId, toiletId, 0)
+	if err != ErrToiletInUse {
+		t.Errorf("got %v starting session at toilet in use, want ErrToiletInUse", err)
+	}
+
+	active, err := store.Sessions.GetActiveByClient(johnId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active.Id != session.Id {
+		t.Errorf("got session %d, want %d", active.Id, session.Id)
+	}
+	_, err = store.Sessions.GetActiveByClient(janeId)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v for client without session, want sql.ErrNoRows", err)
+	}
+
+	// Phases only move forward
+	session, err = store.Sessions.SetPhase(session.Id, SessionPhaseEntered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Phase != SessionPhaseEntered || session.EnteredAt == nil {
+		t.Errorf("got %+v, want entered session", session)
+	}
+	_, err = store.Sessions.SetPhase(session.Id, SessionPhaseEntered)
+	if err != ErrSessionPhase {
+		t.Errorf("got %v repeating phase, want ErrSessionPhase", err)
+	}
+	_, err = store.Sessions.SetPhase(session.Id, SessionPhaseWaiting)
+	if err != ErrSessionPhase {
+		t.Errorf("got %v moving phase back, want ErrSessionPhase", err)
+	}
+
+	session, err = store.Sessions.SetResponder(session.Id, toId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ResponderId == nil || *session.ResponderId != toId ||
+		session.RespondedAt == nil {
+		t.Errorf("got %+v, want the TO responding", session)
+	}
+
+	otherSession, err := store.Sessions.Start(janeId, otherToiletId, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherSession.ToId != nil {
+		t.Errorf("got TO %d, want none", *otherSession.ToId)
+	}
+	sessions, err := store.Sessions.ListActive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].Id != session.Id {
+		t.Errorf("got %+v, want both sessions oldest first", sessions)
+	}
+
+	err = store.Sessions.End(session.Id, SessionStatusCancelled, "Cancelled by officer.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Sessions.End(session.Id, SessionStatusCompleted, "")
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v ending ended session, want sql.ErrNoRows", err)
+	}
+	_, err = store.Sessions.SetPhase(session.Id, SessionPhaseFinished)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v moving phase of ended session, want sql.ErrNoRows", err)
+	}
+	session, err = store.Sessions.Get(session.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Status != SessionStatusCancelled || session.EndedAt == nil ||
+		session.CancelReason != "Cancelled by officer." {
+		t.Errorf("got %+v, want cancelled session", session)
+	}
+
+	clientId, err := store.Sessions.GetClientId(session.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientId != johnId {
+		t.Errorf("got client %d, want %d", clientId, johnId)
+	}
+	_, err = store.Sessions.GetClientId(9999)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v for missing session, want sql.ErrNoRows", err)
+	}
+
+	sessions, _ = store.Sessions.ListActive()
+	if len(sessions) != 1 || sessions[0].Id != otherSession.Id {
+		t.Errorf("got %+v, want only the other session", sessions)
+	}
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var ErrOnShift = errors.New("TO is already on shift")
+
+// A shift of a TO. The clients tracked by the TO
+// stop being tracked once the shift ends.
+type Shift struct {
+	Id        int       `json:"id"`
+	ToId      int       `json:"toId"`
+	Username  string    `json:"username"`
+	StartedAt time.Time `json:"startedAt"`
+	// Planned end of the shift
+	EndsAt time.Time `json:"endsAt"`
+	// nil while on shift
+	EndedAt *time.Time `json:"endedAt"`
+	// TO the tracked clients were handed over to, 0 if none
+	HandoverToId int `json:"handoverToId"`
+}
+
+type ShiftRepository struct {
+	db DBTX
+}
+
+const shiftColumns = `Shifts.id, Shifts.to_id, TOfficers.username,
+	Shifts.started_at, Shifts.ends_at, Shifts.ended_at,
+	Shifts.handover_to_id`
+
+const shiftJoins = `INNER JOIN TOfficers
+		ON TOfficers.id = Shifts.to_id`
+
+func scanShift(row interface{ Scan(...any) error }) (Shift, error) {
+	var shift Shift
+	var endedAt sql.NullTime
+	var handoverToId sql.NullInt64
+	err := row.Scan(
+		&shift.Id, &shift.ToId, &shift.Username,
+		&shift.StartedAt, &shift.EndsAt, &endedAt,
+		&handoverToId,
+	)
+	shift.EndedAt = nullTimePointer(endedAt)
+	shift.HandoverToId = int(handoverToId.Int64)
+	return shift, err
+}
+
+func (repository *ShiftRepository) query(query string,
+	args ...any) ([]Shift, error) {
+	rows, err := repository.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shifts []Shift
+	for rows.Next() {
+		shift, err := scanShift(rows)
+		if err != nil {
+			return nil, err
+		}
+		shifts = append(shifts, shift)
+	}
+	return shifts, rows.Err()
+}
+
+// Starts a shift for the TO until endsAt, after which the
+// clients the TO is tracking are no longer tracked. Returns
+// ErrOnShift if the TO is already on shift, else the id of
+// the shift. Should be run in a transaction, so that the
+// tracking is only set to expire along with the shift.
+func (repository *ShiftRepository) Start(toId int, startedAt time.Time,
+	endsAt time.Time) (int, error) {
+	_, err := repository.GetActive(toId)
+	if err == nil {
+		return 0, ErrOnShift
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	result, err := repository.db.Exec(
+		`INSERT INTO Shifts
+			(to_id, started_at, ends_at)
+		VALUES ($1, $2, $3)
+		`, toId, startedAt.UTC(), endsAt.UTC())
+	if err != nil {
+		// Backstop for concurrent requests, the unique
+		// index only allows one shift per TO at a time
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) &&
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, ErrOnShift
+		}
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = repository.db.Exec(
+		`UPDATE Track
+		SET expires_at = $1
+		WHERE to_id = $2
+		`, endsAt.UTC(), toId)
+	return int(id), err
+}
+
+// Gets the shift the TO is on, sql.ErrNoRows if none
+func (repository *ShiftRepository) GetActive(toId int) (Shift, error) {
+	return scanShift(repository.db.QueryRow(
+		`SELECT `+shiftColumns+`
+		FROM Shifts
+		`+shiftJoins+`
+		WHERE Shifts.to_id = $1
+			AND Shifts.ended_at IS NULL
+		`, toId))
+}
+
+// Lists the TOs on shift, ordered by the end of their shifts
+func (repository *ShiftRepository) ListActive() ([]Shift, error) {
+	return repository.query(
+		`SELECT ` + shiftColumns + `
+		FROM Shifts
+		` + shiftJoins + `
+		WHERE Shifts.ended_at IS NULL
+		ORDER BY Shifts.ends_at, Shifts.id`)
+}
+
+// Lists the shifts which have not ended by their planned end
+func (repository *ShiftRepository) ListOverdue(now time.Time) ([]Shift, error) {
+	return repository.query(
+		`SELECT `+shiftColumns+`
+		FROM Shifts
+		`+shiftJoins+`
+		WHERE Shifts.ended_at IS NULL
+			AND Shifts.ends_at <= $1
+		ORDER BY Shifts.id
+		`, now.UTC())
+}
+
+// Ends the shift, recording the TO the tracked clients
+// were handed over to, 0 if none. The clients the TO
+// is still tracking during the shift are no longer
+// tracked. Returns sql.ErrNoRows if the shift is not
+// found or has already ended.
+func (repository *ShiftRepository) End(id int, endedAt time.Time,
+	handoverToId int) error {
+	result, err := repository.db.Exec(
+		`UPDATE Shifts
+		SET ended_at = $1,
+			handover_to_id = $2
+		WHERE id = $3
+			AND ended_at IS NULL
+		`, endedAt.UTC(), sql.NullInt64{
+			Int64: int64(handoverToId),
+			Valid: handoverToId != 0,
+		}, id)
+	if err != nil {
+		return err
+	}
+	err = checkRowsAffected(result)
+	if err != nil {
+		return err
+	}
+
+	_, err = repository.db.Exec(
+		`DELETE FROM Track
+		WHERE to_id = (
+				SELECT to_id
+				FROM Shifts
+				WHERE id = $1
+			)
+			AND expires_at IS NOT NULL
+		`, id)
+	return err
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestShiftRepository(t *testing.T) {
+	store := newTestStore(t)
+	toId := createTestTO(t, store, "alice")
+	otherToId := createTestTO(t, store, "bob")
+	johnId := createTestClient(t, store, "John", "Doe")
+	janeId := createTestClient(t, store, "Jane", "Doe")
+
+	startedAt := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+	endsAt := startedAt.Add(8 * time.Hour)
+
+	// Tracking from before the shift expires with the shift
+	store.Track.Add(toId, johnId)
+	shiftId, err := store.Shifts.Start(toId, startedAt, endsAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Shifts.Start(toId, startedAt, endsAt)
+	if err != ErrOnShift {
+		t.Errorf("got %v starting again, want ErrOnShift", err)
+	}
+	// Concurrent starts both finding no shift are refused
+	_, err = store.Shifts.db.Exec(
+		`INSERT INTO Shifts (to_id, started_at, ends_at)
+		VALUES ($1, $2, $3)`, toId, startedAt, endsAt)
+	if err == nil {
+		t.Error("inserted a second shift for alice, want it refused")
+	}
+	store.Track.Add(toId, janeId)
+	// Tracking outside of shifts never expires
+	store.Track.Add(otherToId, janeId)
+
+	shift, err := store.Shifts.GetActive(toId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shift.Id != shiftId || shift.Username != "alice" ||
+		!shift.EndsAt.Equal(endsAt) || shift.EndedAt != nil {
+		t.Errorf("got %+v, want alice on shift", shift)
+	}
+	_, err = store.Shifts.GetActive(otherToId)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v for bob, want sql.ErrNoRows", err)
+	}
+
+	shifts, err := store.Shifts.ListOverdue(endsAt.Add(-time.Minute))
+	if err != nil || len(shifts) != 0 {
+		t.Errorf("got %+v (%v), want no overdue shifts", shifts, err)
+	}
+	shifts, err = store.Shifts.ListOverdue(endsAt)
+	if err != nil || len(shifts) != 1 || shifts[0].Id != shiftId {
+		t.Errorf("got %+v (%v), want the shift overdue", shifts, err)
+	}
+
+	expired, err := store.Track.RemoveExpired(endsAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Assignment{{toId, johnId}, {toId, janeId}}
+	if len(expired) != len(want) {
+		t.Fatalf("got %+v, want %+v", expired, want)
+	}
+	for _, assignment := range want {
+		found := false
+		for _, other := range expired {
+			found = found || other == assignment
+		}
+		if !found {
+			t.Errorf("got %+v, want %+v", expired, want)
+		}
+	}
+	clientIds, _ := store.Track.ListClientIds(otherToId)
+	if len(clientIds) != 1 {
+		t.Errorf("got %v, want bob still tracking Jane", clientIds)
+	}
+
+	// Ending the shift stops the tracking during the shift
+	store.Track.Add(toId, johnId)
+	err = store.Shifts.End(shiftId, endsAt, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Shifts.End(shiftId, endsAt, 0)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v ending again, want sql.ErrNoRows", err)
+	}
+	clientIds, _ = store.Track.ListClientIds(toId)
+	if len(clientIds) != 0 {
+		t.Errorf("got %v, want alice tracking no clients", clientIds)
+	}
+	shifts, err = store.Shifts.ListActive()
+	if err != nil || len(shifts) != 0 {
+		t.Errorf("got %+v (%v), want no TOs on shift", shifts, err)
+	}
+}
+
+func TestTrackTransfer(t *testing.T) {
+	store := newTestStore(t)
+	fromToId := createTestTO(t, store, "alice")
+	toToId := createTestTO(t, store, "bob")
+	johnId := createTestClient(t, store, "John", "Doe")
+	janeId := createTestClient(t, store, "Jane", "Doe")
+
+	startedAt := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+	store.Shifts.Start(fromToId, startedAt, startedAt.Add(8*time.Hour))
+	shiftId, _ := store.Shifts.Start(toToId, startedAt.Add(8*time.Hour),
+		startedAt.Add(16*time.Hour))
+	store.Track.Add(fromToId, johnId)
+	store.Track.Add(fromToId, janeId)
+	store.Track.Add(toToId, janeId)
+
+	clientIds, err := store.Track.Transfer(fromToId, toToId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clientIds) != 2 || clientIds[0] != johnId || clientIds[1] != janeId {
+		t.Errorf("got %v, want both clients handed over", clientIds)
+	}
+	clientIds, _ = store.Track.ListClientIds(fromToId)
+	if len(clientIds) != 0 {
+		t.Errorf("got %v, want alice tracking no clients", clientIds)
+	}
+
+	// The clients are tracked until the end of bob's shift
+	expired, _ := store.Track.RemoveExpired(startedAt.Add(8 * time.Hour))
+	if len(expired) != 0 {
+		t.Errorf("got %+v, want no tracking expired", expired)
+	}
+	err = store.Shifts.End(shiftId, startedAt.Add(16*time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientIds, _ = store.Track.ListClientIds(toToId)
+	if len(clientIds) != 0 {
+		t.Errorf("got %v, want bob tracking no clients", clientIds)
+	}
+}
@@ -0,0 +1,90 @@
+// Package store holds the database code shared by
+// the server and the telegram bot.
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Implemented by both *sql.DB and *sql.Tx, so that the
+// repositories can also be used within a transaction
+type DBTX interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Errors returned by the repositories. Rows which are
+// not found are reported with sql.ErrNoRows.
+var (
+	ErrToiletInUse = errors.New("toilet has an active session")
+)
+
+// Repositories for each of the tables
+type Store struct {
+	TOfficers            *TOfficerRepository
+	Clients              *ClientRepository
+	Track                *TrackRepository
+	ToiletEntries        *ToiletEntryRepository
+	Toilets              *ToiletRepository
+	ApiKeys              *ApiKeyRepository
+	Thresholds           *ThresholdChangeRepository
+	Reminders            *ReminderRepository
+	Alerts               *AlertRepository
+	NotificationChannels *NotificationChannelRepository
+	Outbound             *OutboundRepository
+	Audit                *AuditRepository
+	Shifts               *ShiftRepository
+	Sessions             *SessionRepository
+}
+
+// Creates the repositories using the db supplied, which
+// can be either the db itself or a transaction
+func New(db DBTX) *Store {
+	return &Store{
+		TOfficers:            &TOfficerRepository{db: db},
+		Clients:              &ClientRepository{db: db},
+		Track:                &TrackRepository{db: db},
+		ToiletEntries:        &ToiletEntryRepository{db: db},
+		Toilets:              &ToiletRepository{db: db},
+		ApiKeys:              &ApiKeyRepository{db: db},
+		Thresholds:           &ThresholdChangeRepository{db: db},
+		Reminders:            &ReminderRepository{db: db},
+		Alerts:               &AlertRepository{db: db},
+		NotificationChannels: &NotificationChannelRepository{db: db},
+		Outbound:             &OutboundRepository{db: db},
+		Audit:                &AuditRepository{db: db},
+		Shifts:               &ShiftRepository{db: db},
+		Sessions:             &SessionRepository{db: db},
+	}
+}
+
+// Converts a nullable time into a pointer, nil if null
+func nullTimePointer(nullTime sql.NullTime) *time.Time {
+	if !nullTime.Valid {
+		return nil
+	}
+	return &nullTime.Time
+}
+
+// Converts a pointer into a nullable time
+func pointerNullTime(timePointer *time.Time) sql.NullTime {
+	if timePointer == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *timePointer, Valid: true}
+}
+
+// Checks that an update or delete affected a row,
+// returning sql.ErrNoRows otherwise
+func checkRowsAffected(result sql.Result) error {
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Opens a migrated in-memory database. A single connection
+// is used since each connection gets its own database.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	return New(newTestDB(t))
+}
+
+func createTestClient(t *testing.T, store *Store,
+	firstName string, lastName string) int {
+	t.Helper()
+
+	id, err := store.Clients.Create(Client{
+		FirstName:  firstName,
+		LastName:   lastName,
+		Gender:     "male",
+		Urination:  300,
+		Defecation: 600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func createTestTO(t *testing.T, store *Store, username string) int {
+	t.Helper()
+
+	id, err := store.TOfficers.Create(TO{
+		Username:  username,
+		FirstName: "First " + username,
+		LastName:  "Last " + username,
+		UserType:  RoleOfficer,
+	}, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func createTestToilet(t *testing.T, store *Store, name string) int {
+	t.Helper()
+
+	id, err := store.Toilets.Create(Toilet{
+		Name:     name,
+		Location: "Level 1",
+		BaseUrl:  "http://127.0.0.1:5000",
+		Secret:   "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// Starts a session directly in the entered phase
+func startTestSession(t *testing.T, db *sql.DB,
+	clientId int, toiletId int) {
+	t.Helper()
+
+	_, err := db.Exec(
+		`INSERT INTO Sessions
+			(client_id, toilet_id, phase, status)
+		VALUES ($1, $2, 2, 'active')
+		`, clientId, toiletId)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Sources of a threshold change
+const (
+	ThresholdSourceAuto   = "auto"
+	ThresholdSourceManual = "manual"
+)
+
+// A change to the urination or defecation threshold of a client
+type ThresholdChange struct {
+	Id           int    `json:"id"`
+	ClientId     int    `json:"clientId"`
+	BusinessType string `json:"businessType"`
+	OldValue     int    `json:"oldValue"`
+	NewValue     int    `json:"newValue"`
+	Source       string `json:"source"`
+	// TO who applied the change, 0 if automatic
+	ToId       int       `json:"toId"`
+	SampleSize int       `json:"sampleSize"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type ThresholdChangeRepository struct {
+	db DBTX
+}
+
+const thresholdChangeColumns = `id, client_id, business_type,
+	old_value, new_value, source,
+	COALESCE(to_id, 0), sample_size, created_at`
+
+func scanThresholdChange(row interface{ Scan(...any) error }) (ThresholdChange, error) {
+	var change ThresholdChange
+	err := row.Scan(
+		&change.Id, &change.ClientId, &change.BusinessType,
+		&change.OldValue, &change.NewValue, &change.Source,
+		&change.ToId, &change.SampleSize, &change.CreatedAt,
+	)
+	return change, err
+}
+
+// Records a change, returning the id of the change
+func (repository *ThresholdChangeRepository) Create(change ThresholdChange) (int, error) {
+	var toId sql.NullInt64
+	if change.ToId != 0 {
+		toId = sql.NullInt64{Int64: int64(change.ToId), Valid: true}
+	}
+
+	result, err := repository.db.Exec(
+		`INSERT INTO ThresholdChanges
+			(client_id, business_type,
+			old_value, new_value,
+			source, to_id, sample_size)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, change.ClientId, change.BusinessType,
+		change.OldValue, change.NewValue,
+		change.Source, toId, change.SampleSize)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Lists the latest changes of the client, newest first.
+// A limit of 0 lists all changes.
+func (repository *ThresholdChangeRepository) ListByClient(clientId int,
+	limit int) ([]ThresholdChange, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := repository.db.Query(
+		`SELECT `+thresholdChangeColumns+`
+		FROM ThresholdChanges
+		WHERE client_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+		`, clientId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []ThresholdChange
+	for rows.Next() {
+		change, err := scanThresholdChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
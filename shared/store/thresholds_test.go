@@ -0,0 +1,140 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestClientThresholds(t *testing.T) {
+	store := newTestStore(t)
+	clientId := createTestClient(t, store, "John", "Doe")
+
+	err := store.Clients.SetThreshold(clientId, "defecation", 420)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Clients.SetAutoThreshold(clientId, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := store.Clients.Get(clientId)
+	if client.Urination != 300 || client.Defecation != 420 || !client.AutoThreshold {
+		t.Errorf("got %+v, want defecation 420 with auto threshold", client)
+	}
+
+	// Updating the details keeps the auto threshold
+	client.FirstName = "Johnny"
+	err = store.Clients.Update(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ = store.Clients.Get(clientId)
+	if !client.AutoThreshold {
+		t.Errorf("got %+v after update, want auto threshold kept", client)
+	}
+
+	err = store.Clients.SetThreshold(clientId+1, "urination", 100)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v for missing client, want sql.ErrNoRows", err)
+	}
+}
+
+func TestToiletEntryListDurations(t *testing.T) {
+	store := newTestStore(t)
+	clientId := createTestClient(t, store, "John", "Doe")
+
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	entries := []struct {
+		businessType string
+		outcome      string
+		duration     int
+	}{
+		{"urination", "complete", 100},
+		{"urination", "timeout", 900},
+		{"defecation", "complete", 400},
+		{"urination", "complete", 120},
+		{"urination", "cancelled", 0},
+		{"urination", "timeout", 0},
+		{"urination", "complete", 140},
+		// Completed without the times of entering or finishing
+		{"urination", "complete", 0},
+	}
+	for i, entry := range entries {
+		startTime := day.Add(time.Duration(i) * time.Hour)
+		_, err := store.ToiletEntries.Create(ToiletEntry{
+			ClientId:     clientId,
+			BusinessType: entry.businessType,
+			Outcome:      entry.outcome,
+			Duration:     entry.duration,
+			StartTime:    &startTime,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	durations, err := store.ToiletEntries.ListDurations(clientId, "urination", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(durations) != 2 || durations[0] != 140 || durations[1] != 120 {
+		t.Errorf("got %v, want the latest 2 completed non-zero durations", durations)
+	}
+
+	durations, _ = store.ToiletEntries.ListDurations(clientId, "urination", 0)
+	if len(durations) != 3 {
+		t.Errorf("got %v, want all 3 completed non-zero durations", durations)
+	}
+}
+
+func TestThresholdChangeRepository(t *testing.T) {
+	store := newTestStore(t)
+	clientId := createTestClient(t, store, "John", "Doe")
+	toId := createTestTO(t, store, "user")
+
+	changes := []ThresholdChange{
+		{
+			ClientId:     clientId,
+			BusinessType: "urination",
+			OldValue:     300,
+			NewValue:     240,
+			Source:       ThresholdSourceAuto,
+			SampleSize:   10,
+		},
+		{
+			ClientId:     clientId,
+			BusinessType: "defecation",
+			OldValue:     600,
+			NewValue:     480,
+			Source:       ThresholdSourceManual,
+			ToId:         toId,
+			SampleSize:   12,
+		},
+	}
+	for _, change := range changes {
+		_, err := store.Thresholds.Create(change)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listed, err := store.Thresholds.ListByClient(clientId, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 || listed[0].ToId != toId ||
+		listed[0].NewValue != 480 || listed[1].ToId != 0 {
+		t.Errorf("got %+v, want both changes newest first", listed)
+	}
+
+	// Changes are removed along with the client
+	err = store.Clients.Delete(clientId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed, _ = store.Thresholds.ListByClient(clientId, 0)
+	if len(listed) != 0 {
+		t.Errorf("got %+v, want no changes after deleting the client", listed)
+	}
+}
@@ -0,0 +1,231 @@
+package store
+
+// A trainer officer (TO) account. The password
+// hash is only read when logging in.
+type TO struct {
+	Id             int    `json:"id"`
+	Username       string `json:"username"`
+	FirstName      string `json:"firstName"`
+	LastName       string `json:"lastName"`
+	TelegramChatId string `json:"-"`
+	UserType       string `json:"userType"`
+}
+
+// Filters for listing TOs, empty fields are ignored
+type TOFilter struct {
+	// Start of the first name, last name or username
+	Search   string
+	UserType string
+	Page
+}
+
+type TOfficerRepository struct {
+	db DBTX
+}
+
+const toColumns = `id, username,
+	first_name, last_name,
+	telegram_chat_id, type`
+
+func scanTO(row interface{ Scan(...any) error }) (TO, error) {
+	var to TO
+	err := row.Scan(
+		&to.Id, &to.Username,
+		&to.FirstName, &to.LastName,
+		&to.TelegramChatId, &to.UserType,
+	)
+	return to, err
+}
+
+func (repository *TOfficerRepository) query(query string,
+	args ...any) ([]TO, error) {
+	rows, err := repository.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tos []TO
+	for rows.Next() {
+		to, err := scanTO(rows)
+		if err != nil {
+			return nil, err
+		}
+		tos = append(tos, to)
+	}
+	return tos, rows.Err()
+}
+
+// Gets the TO with the id supplied
+func (repository *TOfficerRepository) Get(id int) (TO, error) {
+	return scanTO(repository.db.QueryRow(
+		`SELECT `+toColumns+`
+		FROM TOfficers
+		WHERE id = $1
+		`, id))
+}
+
+// Gets the TO who registered the telegram chat with the bot
+func (repository *TOfficerRepository) GetByTelegramChatId(chatId string) (TO, error) {
+	return scanTO(repository.db.QueryRow(
+		`SELECT `+toColumns+`
+		FROM TOfficers
+		WHERE telegram_chat_id = $1
+			AND telegram_chat_id != ''
+		`, chatId))
+}
+
+// Gets the TO with the username supplied
+func (repository *TOfficerRepository) GetByUsername(username string) (TO, error) {
+	return scanTO(repository.db.QueryRow(
+		`SELECT `+toColumns+`
+		FROM TOfficers
+		WHERE username = $1
+		`, username))
+}
+
+// Gets the password hash of the TO
+func (repository *TOfficerRepository) GetPasswordHash(id int) (string, error) {
+	var passwordHash string
+	err := repository.db.QueryRow(
+		`SELECT password
+		FROM TOfficers
+		WHERE id = $1
+		`, id).Scan(&passwordHash)
+	return passwordHash, err
+}
+
+// Lists all TOs, ordered by id
+func (repository *TOfficerRepository) List() ([]TO, error) {
+	return repository.query(
+		`SELECT ` + toColumns + `
+		FROM TOfficers
+		ORDER BY id`)
+}
+
+// Lists the TOs matching the filter, ordered by id,
+// along with the total number of matching TOs
+func (repository *TOfficerRepository) Find(filter TOFilter) ([]TO, int, error) {
+	var where whereClause
+	if filter.Search != "" {
+		where.add(`(first_name LIKE %[1]s COLLATE NOCASE
+			OR last_name LIKE %[1]s COLLATE NOCASE
+			OR username LIKE %[1]s COLLATE NOCASE)`, filter.Search+"%")
+	}
+	if filter.UserType != "" {
+		where.add("type = %[1]s", filter.UserType)
+	}
+
+	var total int
+	err := repository.db.QueryRow(
+		`SELECT COUNT(*)
+		FROM TOfficers
+		`+where.String(), where.args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pageClause, args := where.page(filter.Page)
+	tos, err := repository.query(
+		`SELECT `+toColumns+`
+		FROM TOfficers
+		`+where.String()+`
+		ORDER BY id
+		`+pageClause, args...)
+	return tos, total, err
+}
+
+// Searches for TOs with a first name, last name or username
+// starting with the search query, excluding the TO with
+// the id supplied, e.g. the TO searching
+func (repository *TOfficerRepository) Search(search string,
+	excludeId int) ([]TO, error) {
+	// Add wildcard for autocomplete
+	return repository.query(
+		`SELECT `+toColumns+`
+		FROM TOfficers
+		WHERE id != $1
+			AND (first_name LIKE $2 COLLATE NOCASE
+				OR last_name LIKE $2 COLLATE NOCASE
+				OR username LIKE $2 COLLATE NOCASE)
+		ORDER BY id
+		`, excludeId, search+"%")
+}
+
+// Adds a new TO, returning the id of the TO
+func (repository *TOfficerRepository) Create(to TO,
+	passwordHash string) (int, error) {
+	result, err := repository.db.Exec(
+		`INSERT INTO TOfficers
+			(first_name, last_name,
+			username, password, type)
+		VALUES ($1, $2, $3, $4, $5)
+		`, to.FirstName, to.LastName,
+		to.Username, passwordHash, to.UserType)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Updates the details of the TO, excluding the
+// password and telegram chat
+func (repository *TOfficerRepository) Update(to TO) error {
+	result, err := repository.db.Exec(
+		`UPDATE TOfficers SET
+			first_name = $1,
+			last_name = $2,
+			username = $3,
+			type = $4
+		WHERE id = $5
+		`, to.FirstName, to.LastName,
+		to.Username, to.UserType, to.Id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Updates the name of the TO
+func (repository *TOfficerRepository) UpdateName(id int,
+	firstName string, lastName string) error {
+	result, err := repository.db.Exec(
+		`UPDATE TOfficers SET
+			first_name = $1,
+			last_name = $2
+		WHERE id = $3
+		`, firstName, lastName, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Updates the password hash of the TO
+func (repository *TOfficerRepository) SetPasswordHash(id int,
+	passwordHash string) error {
+	result, err := repository.db.Exec(
+		`UPDATE TOfficers SET
+			password = $1
+		WHERE id = $2
+		`, passwordHash, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Links the telegram chat to the TO
+func (repository *TOfficerRepository) SetTelegramChatId(id int,
+	chatId string) error {
+	result, err := repository.db.Exec(
+		`UPDATE TOfficers SET
+			telegram_chat_id = $1
+		WHERE id = $2
+		`, chatId, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
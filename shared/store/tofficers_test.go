@@ -0,0 +1,91 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestTOfficerRepository(t *testing.T) {
+	store := newTestStore(t)
+	aliceId := createTestTO(t, store, "alice")
+	bobId := createTestTO(t, store, "bob")
+
+	to, err := store.TOfficers.GetByUsername("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to.Id != aliceId || to.UserType != RoleOfficer {
+		t.Errorf("got %+v, want id %d of type officer", to, aliceId)
+	}
+
+	hash, err := store.TOfficers.GetPasswordHash(aliceId)
+	if err != nil || hash != "hash" {
+		t.Errorf("got hash %q (%v), want hash", hash, err)
+	}
+
+	err = store.TOfficers.SetTelegramChatId(bobId, "12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err = store.TOfficers.GetByTelegramChatId("12345")
+	if err != nil || to.Id != bobId {
+		t.Errorf("got %+v (%v), want id %d", to, err, bobId)
+	}
+
+	// TOs without a telegram chat should not be matched
+	_, err = store.TOfficers.GetByTelegramChatId("")
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v for empty chat id, want sql.ErrNoRows", err)
+	}
+
+	tos, err := store.TOfficers.Search("B", aliceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tos) != 1 || tos[0].Id != bobId {
+		t.Errorf("got %+v, want only bob", tos)
+	}
+	tos, _ = store.TOfficers.Search("alice", aliceId)
+	if len(tos) != 0 {
+		t.Errorf("got %+v, want the searching TO excluded", tos)
+	}
+
+	to.FirstName = "Robert"
+	to.UserType = "admin"
+	err = store.TOfficers.Update(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, _ = store.TOfficers.Get(bobId)
+	if to.FirstName != "Robert" || to.UserType != "admin" {
+		t.Errorf("got %+v after update", to)
+	}
+
+	err = store.TOfficers.SetPasswordHash(9999, "hash")
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v for missing TO, want sql.ErrNoRows", err)
+	}
+}
+
+func TestTOfficerFind(t *testing.T) {
+	store := newTestStore(t)
+	createTestTO(t, store, "alice")
+	bobId := createTestTO(t, store, "bob")
+	createTestTO(t, store, "bobby")
+
+	tos, total, err := store.TOfficers.Find(TOFilter{
+		Search: "bob",
+		Page:   Page{Limit: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(tos) != 1 || tos[0].Id != bobId {
+		t.Errorf("got %+v of %d, want bob of 2", tos, total)
+	}
+
+	_, total, _ = store.TOfficers.Find(TOFilter{UserType: "admin"})
+	if total != 0 {
+		t.Errorf("got %d admins, want 0", total)
+	}
+}
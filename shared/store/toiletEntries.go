@@ -0,0 +1,231 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// A single use of the toilet by a client, as reported
+// by the toilet at the end of a session
+type ToiletEntry struct {
+	Id       int `json:"id"`
+	ClientId int `json:"clientId"`
+	// Session the entry was recorded for, nil if none
+	SessionId *int `json:"sessionId"`
+	// Either urination or defecation
+	BusinessType string `json:"businessType"`
+	// Duration of the business itself, in seconds
+	Duration int `json:"duration"`
+	// Either complete, cancelled or timeout
+	Outcome    string     `json:"outcome"`
+	StartTime  *time.Time `json:"startTime"`
+	EnterTime  *time.Time `json:"enterTime"`
+	FinishTime *time.Time `json:"finishTime"`
+	ExitTime   *time.Time `json:"exitTime"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// When the entry started. Entries recorded without a
+// start time are taken to start when recorded.
+func (entry ToiletEntry) StartedAt() time.Time {
+	if entry.StartTime != nil {
+		return *entry.StartTime
+	}
+	return entry.CreatedAt
+}
+
+// Filters for listing toilet entries, empty fields are ignored
+type ToiletEntryFilter struct {
+	ClientId     int
+	BusinessType string
+	Outcome      string
+	// Entries started within [From, To)
+	From time.Time
+	To   time.Time
+	Page
+}
+
+type ToiletEntryRepository struct {
+	db DBTX
+}
+
+const toiletEntryColumns = `id, client_id, session_id,
+	business_type, duration, outcome,
+	start_time, enter_time, finish_time,
+	exit_time, created_at`
+
+func scanToiletEntry(row interface{ Scan(...any) error }) (ToiletEntry, error) {
+	var entry ToiletEntry
+	var sessionId sql.NullInt32
+	var startTime, enterTime, finishTime, exitTime sql.NullTime
+	err := row.Scan(
+		&entry.Id, &entry.ClientId, &sessionId,
+		&entry.BusinessType, &entry.Duration, &entry.Outcome,
+		&startTime, &enterTime, &finishTime,
+		&exitTime, &entry.CreatedAt,
+	)
+	if sessionId.Valid {
+		id := int(sessionId.Int32)
+		entry.SessionId = &id
+	}
+	entry.StartTime = nullTimePointer(startTime)
+	entry.EnterTime = nullTimePointer(enterTime)
+	entry.FinishTime = nullTimePointer(finishTime)
+	entry.ExitTime = nullTimePointer(exitTime)
+	return entry, err
+}
+
+// Records a new entry, returning the id of the entry
+func (repository *ToiletEntryRepository) Create(entry ToiletEntry) (int, error) {
+	var sessionId sql.NullInt32
+	if entry.SessionId != nil {
+		sessionId = sql.NullInt32{Int32: int32(*entry.SessionId), Valid: true}
+	}
+
+	result, err := repository.db.Exec(
+		`INSERT INTO ToiletEntries
+			(client_id, session_id,
+			business_type, duration,
+			outcome, start_time,
+			enter_time, finish_time,
+			exit_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, entry.ClientId, sessionId,
+		entry.BusinessType, entry.Duration,
+		entry.Outcome, pointerNullTime(entry.StartTime),
+		pointerNullTime(entry.EnterTime), pointerNullTime(entry.FinishTime),
+		pointerNullTime(entry.ExitTime))
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Gets the entry with the id supplied
+func (repository *ToiletEntryRepository) Get(id int) (ToiletEntry, error) {
+	return scanToiletEntry(repository.db.QueryRow(
+		`SELECT `+toiletEntryColumns+`
+		FROM ToiletEntries
+		WHERE id = $1
+		`, id))
+}
+
+// Lists the entries matching the filter, newest first, along
+// with the total number of matching entries. Entries recorded
+// without a start time are taken to start when recorded.
+func (repository *ToiletEntryRepository) Find(filter ToiletEntryFilter) ([]ToiletEntry, int, error) {
+	var where whereClause
+	if filter.ClientId != 0 {
+		where.add("client_id = %[1]s", filter.ClientId)
+	}
+	if filter.BusinessType != "" {
+		where.add("business_type = %[1]s", filter.BusinessType)
+	}
+	if filter.Outcome != "" {
+		where.add("outcome = %[1]s", filter.Outcome)
+	}
+	if !filter.From.IsZero() {
+		where.add("COALESCE(start_time, created_at) >= %[1]s", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		where.add("COALESCE(start_time, created_at) < %[1]s", filter.To.UTC())
+	}
+
+	var total int
+	err := repository.db.QueryRow(
+		`SELECT COUNT(*)
+		FROM ToiletEntries
+		`+where.String(), where.args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pageClause, args := where.page(filter.Page)
+	rows, err := repository.db.Query(
+		`SELECT `+toiletEntryColumns+`
+		FROM ToiletEntries
+		`+where.String()+`
+		ORDER BY COALESCE(start_time, created_at) DESC, id DESC
+		`+pageClause, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var entries []ToiletEntry
+	for rows.Next() {
+		entry, err := scanToiletEntry(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, total, rows.Err()
+}
+
+// Lists the latest entries of the client, newest first.
+// A limit of 0 lists all entries.
+func (repository *ToiletEntryRepository) ListByClient(clientId int,
+	limit int) ([]ToiletEntry, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := repository.db.Query(
+		`SELECT `+toiletEntryColumns+`
+		FROM ToiletEntries
+		WHERE client_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+		`, clientId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ToiletEntry
+	for rows.Next() {
+		entry, err := scanToiletEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Lists the durations of the latest completed entries
+// of the business type for the client, newest first.
+// Entries without a duration, e.g. missing the times
+// of entering or finishing, are left out.
+func (repository *ToiletEntryRepository) ListDurations(clientId int,
+	businessType string, limit int) ([]int, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := repository.db.Query(
+		`SELECT duration
+		FROM ToiletEntries
+		WHERE client_id = $1
+			AND business_type = $2
+			AND outcome = 'complete'
+			AND duration > 0
+		ORDER BY COALESCE(start_time, created_at) DESC, id DESC
+		LIMIT $3
+		`, clientId, businessType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var durations []int
+	for rows.Next() {
+		var duration int
+		err := rows.Scan(&duration)
+		if err != nil {
+			return nil, err
+		}
+		durations = append(durations, duration)
+	}
+	return durations, rows.Err()
+}
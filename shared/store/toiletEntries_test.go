@@ -0,0 +1,130 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestToiletEntryRepository(t *testing.T) {
+	store := newTestStore(t)
+	clientId := createTestClient(t, store, "John", "Doe")
+
+	startTime := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	enterTime := startTime.Add(time.Minute)
+	sessionId := 7
+	firstId, err := store.ToiletEntries.Create(ToiletEntry{
+		ClientId:     clientId,
+		SessionId:    &sessionId,
+		BusinessType: "urination",
+		Duration:     90,
+		Outcome:      "complete",
+		StartTime:    &startTime,
+		EnterTime:    &enterTime,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondId, err := store.ToiletEntries.Create(ToiletEntry{
+		ClientId:     clientId,
+		BusinessType: "defecation",
+		Outcome:      "timeout",
+		StartTime:    &startTime,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := store.ToiletEntries.Get(firstId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.SessionId == nil || *entry.SessionId != sessionId {
+		t.Errorf("got session %v, want %d", entry.SessionId, sessionId)
+	}
+	if entry.EnterTime == nil || !entry.EnterTime.Equal(enterTime) {
+		t.Errorf("got enter time %v, want %v", entry.EnterTime, enterTime)
+	}
+	if entry.FinishTime != nil {
+		t.Errorf("got finish time %v, want nil", entry.FinishTime)
+	}
+
+	entries, err := store.ToiletEntries.ListByClient(clientId, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Id != secondId {
+		t.Errorf("got %+v, want 2 entries newest first", entries)
+	}
+
+	entries, _ = store.ToiletEntries.ListByClient(clientId, 1)
+	if len(entries) != 1 {
+		t.Errorf("got %d entries, want 1", len(entries))
+	}
+}
+
+func TestToiletEntryFind(t *testing.T) {
+	store := newTestStore(t)
+	johnId := createTestClient(t, store, "John", "Doe")
+	janeId := createTestClient(t, store, "Jane", "Doe")
+
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	for i, clientId := range []int{johnId, johnId, johnId, janeId} {
+		startTime := day.Add(time.Duration(i) * 24 * time.Hour)
+		businessType := "urination"
+		if i == 1 {
+			businessType = "defecation"
+		}
+		_, err := store.ToiletEntries.Create(ToiletEntry{
+			ClientId:     clientId,
+			BusinessType: businessType,
+			Outcome:      "complete",
+			StartTime:    &startTime,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter ToiletEntryFilter
+		want   int
+	}{
+		{"client", ToiletEntryFilter{ClientId: johnId}, 3},
+		{"business type", ToiletEntryFilter{ClientId: johnId, BusinessType: "urination"}, 2},
+		{"outcome", ToiletEntryFilter{Outcome: "timeout"}, 0},
+		{"from", ToiletEntryFilter{From: day.Add(24 * time.Hour)}, 3},
+		{"range", ToiletEntryFilter{From: day, To: day.Add(48 * time.Hour)}, 2},
+	}
+	for _, test := range tests {
+		entries, total, err := store.ToiletEntries.Find(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != test.want || len(entries) != test.want {
+			t.Errorf("%s: got %d entries of %d, want %d",
+				test.name, len(entries), total, test.want)
+		}
+	}
+
+	entries, total, err := store.ToiletEntries.Find(ToiletEntryFilter{
+		ClientId: johnId,
+		Page:     Page{Limit: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(entries) != 2 ||
+		!entries[0].StartTime.Equal(day.Add(48*time.Hour)) {
+		t.Errorf("got %+v of %d, want the latest 2 of 3", entries, total)
+	}
+
+	if !entries[0].StartedAt().Equal(*entries[0].StartTime) {
+		t.Errorf("got started at %v, want the start time", entries[0].StartedAt())
+	}
+	entries[0].StartTime = nil
+	if !entries[0].StartedAt().Equal(entries[0].CreatedAt) {
+		t.Errorf("got started at %v without a start time, want the created time",
+			entries[0].StartedAt())
+	}
+}
@@ -0,0 +1,139 @@
+package store
+
+// A toilet fitted with the PottySense pi. The secret is
+// sent as the X-PS-Header when contacting the toilet.
+type Toilet struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	Location string `json:"location"`
+	BaseUrl  string `json:"baseUrl"`
+	Secret   string `json:"-"`
+}
+
+type ToiletRepository struct {
+	db DBTX
+}
+
+const toiletColumns = `id, name, location,
+	base_url, secret`
+
+func scanToilet(row interface{ Scan(...any) error }) (Toilet, error) {
+	var toilet Toilet
+	err := row.Scan(
+		&toilet.Id, &toilet.Name, &toilet.Location,
+		&toilet.BaseUrl, &toilet.Secret,
+	)
+	return toilet, err
+}
+
+func (repository *ToiletRepository) query(query string,
+	args ...any) ([]Toilet, error) {
+	rows, err := repository.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var toilets []Toilet
+	for rows.Next() {
+		toilet, err := scanToilet(rows)
+		if err != nil {
+			return nil, err
+		}
+		toilets = append(toilets, toilet)
+	}
+	return toilets, rows.Err()
+}
+
+// Gets the toilet with the id supplied
+func (repository *ToiletRepository) Get(id int) (Toilet, error) {
+	return scanToilet(repository.db.QueryRow(
+		`SELECT `+toiletColumns+`
+		FROM Toilets
+		WHERE id = $1
+		`, id))
+}
+
+// Lists all toilets, ordered by id
+func (repository *ToiletRepository) List() ([]Toilet, error) {
+	return repository.query(
+		`SELECT ` + toiletColumns + `
+		FROM Toilets
+		ORDER BY id`)
+}
+
+// Searches for toilets with a name or location
+// starting with the search query, ignoring case
+func (repository *ToiletRepository) Search(search string) ([]Toilet, error) {
+	// Add wildcard for autocomplete
+	return repository.query(
+		`SELECT `+toiletColumns+`
+		FROM Toilets
+		WHERE name LIKE $1 COLLATE NOCASE
+			OR location LIKE $1 COLLATE NOCASE
+		ORDER BY id
+		`, search+"%")
+}
+
+// Adds a new toilet, returning the id of the toilet
+func (repository *ToiletRepository) Create(toilet Toilet) (int, error) {
+	result, err := repository.db.Exec(
+		`INSERT INTO Toilets
+			(name, location,
+			base_url, secret)
+		VALUES ($1, $2, $3, $4)
+		`, toilet.Name, toilet.Location,
+		toilet.BaseUrl, toilet.Secret)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Updates the details of the toilet. An empty
+// secret keeps the current secret.
+func (repository *ToiletRepository) Update(toilet Toilet) error {
+	result, err := repository.db.Exec(
+		`UPDATE Toilets SET
+			name = $1,
+			location = $2,
+			base_url = $3,
+			secret = CASE WHEN $4 = '' THEN secret ELSE $4 END
+		WHERE id = $5
+		`, toilet.Name, toilet.Location,
+		toilet.BaseUrl, toilet.Secret,
+		toilet.Id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Removes the toilet. Toilets with an active session
+// cannot be removed, returning ErrToiletInUse instead.
+func (repository *ToiletRepository) Delete(id int) error {
+	result, err := repository.db.Exec(
+		`DELETE FROM Toilets
+		WHERE id = $1
+			AND NOT EXISTS (
+				SELECT 1
+				FROM Sessions
+				WHERE toilet_id = $1
+					AND status = $2)
+		`, id, SessionStatusActive)
+	if err != nil {
+		return err
+	}
+
+	err = checkRowsAffected(result)
+	if err != nil {
+		// Either the toilet does not exist, or is in use
+		_, getErr := repository.Get(id)
+		if getErr == nil {
+			return ErrToiletInUse
+		}
+		return getErr
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestToiletRepository(t *testing.T) {
+	db := newTestDB(t)
+	store := New(db)
+	firstId := createTestToilet(t, store, "Level 1 Male")
+	secondId := createTestToilet(t, store, "Level 2 Female")
+
+	toilets, err := store.Toilets.Search("level 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toilets) != 1 || toilets[0].Id != secondId {
+		t.Errorf("got %+v, want the second toilet", toilets)
+	}
+
+	// An empty secret keeps the current secret
+	err = store.Toilets.Update(Toilet{
+		Id:       firstId,
+		Name:     "Level 1",
+		Location: "Lobby",
+		BaseUrl:  "http://10.0.0.2:5000",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	toilet, err := store.Toilets.Get(firstId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toilet.Name != "Level 1" || toilet.Secret != "secret" {
+		t.Errorf("got %+v, want updated name and unchanged secret", toilet)
+	}
+
+	clientId := createTestClient(t, store, "John", "Doe")
+	startTestSession(t, db, clientId, firstId)
+
+	err = store.Toilets.Delete(firstId)
+	if err != ErrToiletInUse {
+		t.Errorf("got %v deleting toilet in use, want ErrToiletInUse", err)
+	}
+	err = store.Toilets.Delete(secondId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Toilets.Delete(secondId)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v deleting missing toilet, want sql.ErrNoRows", err)
+	}
+
+	toilets, _ = store.Toilets.List()
+	if len(toilets) != 1 || toilets[0].Id != firstId {
+		t.Errorf("got %+v, want only the first toilet", toilets)
+	}
+}
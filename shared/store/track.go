@@ -0,0 +1,259 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// A client tracked by a TO, along with the active
+// session of the client, if any
+type TrackedClient struct {
+	Client
+	// 0 if the client has no active session
+	SessionPhase int `json:"sessionPhase"`
+	// Toilet of the active session, if any
+	ToiletName string `json:"toiletName"`
+	// Username of the TO on the way to the client
+	// during the active session, if any
+	Responder string `json:"responder"`
+}
+
+// A client tracked by a TO
+type Assignment struct {
+	ToId     int `json:"toId"`
+	ClientId int `json:"clientId"`
+}
+
+// Which clients each TO is tracking, i.e.
+// receives notifications for
+type TrackRepository struct {
+	db DBTX
+}
+
+const trackedClientJoins = `LEFT JOIN Sessions
+		ON Sessions.client_id = Clients.id
+			AND Sessions.status = '` + SessionStatusActive + `'
+	LEFT JOIN Toilets
+		ON Toilets.id = Sessions.toilet_id
+	LEFT JOIN TOfficers AS Responders
+		ON Responders.id = Sessions.responder_id`
+
+// Columns of the active session selected with trackedClientJoins
+const trackedClientSessionColumns = `Sessions.phase, Toilets.name,
+	Responders.username`
+
+func scanTrackedClient(row interface{ Scan(...any) error }) (TrackedClient, error) {
+	var client TrackedClient
+	var phase sql.NullInt32
+	var toiletName, responder sql.NullString
+	err := row.Scan(
+		&client.Id, &client.FirstName,
+		&client.LastName, &client.Gender,
+		&client.Urination, &client.Defecation,
+		&client.LastRecord, &client.AutoThreshold,
+		&phase, &toiletName, &responder,
+	)
+	client.SessionPhase = int(phase.Int32)
+	client.ToiletName = toiletName.String
+	client.Responder = responder.String
+	return client, err
+}
+
+// Planned end of the shift the TO is on, NULL if none
+const shiftExpiry = `(
+			SELECT ends_at
+			FROM Shifts
+			WHERE to_id = $1
+				AND ended_at IS NULL
+		)`
+
+// Starts tracking the client for the TO, until the end of
+// the shift if the TO is on shift. Tracking a client
+// already tracked does nothing.
+func (repository *TrackRepository) Add(toId int, clientId int) error {
+	_, err := repository.db.Exec(
+		`INSERT OR IGNORE
+		INTO Track (to_id, client_id, expires_at)
+		VALUES ($1, $2, `+shiftExpiry+`)
+		`, toId, clientId)
+	return err
+}
+
+// Stops tracking the client for the TO
+func (repository *TrackRepository) Remove(toId int, clientId int) error {
+	_, err := repository.db.Exec(
+		`DELETE FROM Track
+		WHERE to_id = $1
+			AND client_id = $2
+		`, toId, clientId)
+	return err
+}
+
+// Lists the clients tracked by the TO, ordered by id
+func (repository *TrackRepository) ListClients(toId int) ([]TrackedClient, error) {
+	rows, err := repository.db.Query(
+		`SELECT `+clientColumns+`,
+			`+trackedClientSessionColumns+`
+		FROM Track
+		INNER JOIN Clients
+			ON Track.client_id = Clients.id
+		`+trackedClientJoins+`
+		WHERE Track.to_id = $1
+		ORDER BY Clients.id
+		`, toId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []TrackedClient
+	for rows.Next() {
+		client, err := scanTrackedClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+// Gets the client with the id supplied, along with
+// their active session. The client need not be tracked.
+func (repository *TrackRepository) GetClient(clientId int) (TrackedClient, error) {
+	return scanTrackedClient(repository.db.QueryRow(
+		`SELECT `+clientColumns+`,
+			`+trackedClientSessionColumns+`
+		FROM Clients
+		`+trackedClientJoins+`
+		WHERE Clients.id = $1
+		`, clientId))
+}
+
+// Lists the ids of the clients tracked by the TO
+func (repository *TrackRepository) ListClientIds(toId int) ([]int, error) {
+	rows, err := repository.db.Query(
+		`SELECT client_id
+		FROM Track
+		WHERE to_id = $1
+		ORDER BY client_id
+		`, toId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clientIds []int
+	for rows.Next() {
+		var clientId int
+		err := rows.Scan(&clientId)
+		if err != nil {
+			return nil, err
+		}
+		clientIds = append(clientIds, clientId)
+	}
+	return clientIds, rows.Err()
+}
+
+// Lists the TOs tracking the client, ordered by id
+func (repository *TrackRepository) ListTOs(clientId int) ([]TO, error) {
+	rows, err := repository.db.Query(
+		`SELECT TOfficers.id, TOfficers.username,
+			TOfficers.first_name, TOfficers.last_name,
+			TOfficers.telegram_chat_id, TOfficers.type
+		FROM TOfficers
+		INNER JOIN Track
+			ON TOfficers.id = Track.to_id
+		WHERE Track.client_id = $1
+		ORDER BY TOfficers.id
+		`, clientId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tos []TO
+	for rows.Next() {
+		to, err := scanTO(rows)
+		if err != nil {
+			return nil, err
+		}
+		tos = append(tos, to)
+	}
+	return tos, rows.Err()
+}
+
+// Lists which clients every TO is tracking,
+// ordered by client and then by TO
+func (repository *TrackRepository) List() ([]Assignment, error) {
+	rows, err := repository.db.Query(
+		`SELECT to_id, client_id
+		FROM Track
+		ORDER BY client_id, to_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assignments []Assignment
+	for rows.Next() {
+		var assignment Assignment
+		err := rows.Scan(&assignment.ToId, &assignment.ClientId)
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, rows.Err()
+}
+
+// Hands the clients tracked by one TO over to another, who
+// tracks them until the end of their own shift, if on shift.
+// Returns the ids of the clients handed over, ordered by id.
+func (repository *TrackRepository) Transfer(fromToId int,
+	toToId int) ([]int, error) {
+	clientIds, err := repository.ListClientIds(fromToId)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = repository.db.Exec(
+		`INSERT OR IGNORE
+		INTO Track (to_id, client_id, expires_at)
+		SELECT $1, client_id, `+shiftExpiry+`
+		FROM Track
+		WHERE to_id = $2
+		`, toToId, fromToId)
+	if err != nil {
+		return nil, err
+	}
+	_, err = repository.db.Exec(
+		`DELETE FROM Track
+		WHERE to_id = $1
+		`, fromToId)
+	return clientIds, err
+}
+
+// Stops the tracking which has expired by now,
+// returning the clients no longer tracked
+func (repository *TrackRepository) RemoveExpired(now time.Time) ([]Assignment, error) {
+	rows, err := repository.db.Query(
+		`DELETE FROM Track
+		WHERE expires_at <= $1
+		RETURNING to_id, client_id
+		`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assignments []Assignment
+	for rows.Next() {
+		var assignment Assignment
+		err := rows.Scan(&assignment.ToId, &assignment.ClientId)
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, rows.Err()
+}
@@ -0,0 +1,96 @@
+package store
+
+import "testing"
+
+func TestTrackRepository(t *testing.T) {
+	db := newTestDB(t)
+	store := New(db)
+	toId := createTestTO(t, store, "alice")
+	otherToId := createTestTO(t, store, "bob")
+	johnId := createTestClient(t, store, "John", "Doe")
+	janeId := createTestClient(t, store, "Jane", "Doe")
+	toiletId := createTestToilet(t, store, "Toilet A")
+
+	for _, clientId := range []int{johnId, janeId} {
+		err := store.Track.Add(toId, clientId)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Tracking twice does nothing
+	err := store.Track.Add(toId, johnId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Track.Add(otherToId, johnId)
+
+	startTestSession(t, db, janeId, toiletId)
+
+	clients, err := store.Track.ListClients(toId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 2 {
+		t.Fatalf("got %+v, want 2 clients", clients)
+	}
+	if clients[0].Id != johnId || clients[0].SessionPhase != 0 {
+		t.Errorf("got %+v, want John without a session", clients[0])
+	}
+	if clients[1].Id != janeId || clients[1].SessionPhase != 2 ||
+		clients[1].ToiletName != "Toilet A" {
+		t.Errorf("got %+v, want Jane in Toilet A", clients[1])
+	}
+
+	client, err := store.Track.GetClient(janeId)
+	if err != nil || client.SessionPhase != 2 || client.Responder != "" {
+		t.Errorf("got %+v (%v), want Jane with a session", client, err)
+	}
+
+	_, err = db.Exec(
+		`UPDATE Sessions
+		SET responder_id = $1
+		WHERE client_id = $2
+		`, otherToId, janeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ = store.Track.GetClient(janeId)
+	if client.Responder != "bob" {
+		t.Errorf("got %+v, want bob on the way to Jane", client)
+	}
+
+	tos, err := store.Track.ListTOs(johnId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tos) != 2 || tos[0].Id != toId || tos[1].Id != otherToId {
+		t.Errorf("got %+v, want both TOs", tos)
+	}
+
+	assignments, err := store.Track.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Assignment{{toId, johnId}, {otherToId, johnId}, {toId, janeId}}
+	if len(assignments) != len(want) {
+		t.Fatalf("got %+v, want %+v", assignments, want)
+	}
+	for i := range want {
+		if assignments[i] != want[i] {
+			t.Errorf("got %+v, want %+v", assignments, want)
+			break
+		}
+	}
+
+	err = store.Track.Remove(toId, johnId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientIds, err := store.Track.ListClientIds(toId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clientIds) != 1 || clientIds[0] != janeId {
+		t.Errorf("got %v, want only Jane", clientIds)
+	}
+}
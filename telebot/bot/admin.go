@@ -0,0 +1,425 @@
+package bot
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/genekkion/PottySenseShared/store"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Default thresholds of new clients, as on the dashboard
+const (
+	defaultUrination  = 300 // in seconds
+	defaultDefecation = 600 // in seconds
+)
+
+// Buttons to confirm or discard the changes of a command
+func confirmKeyboard(confirmLabel string) *tgbotapi.InlineKeyboardMarkup {
+	return conversationKeyboard([]string{confirmLabel, "Discard"},
+		[]string{"yes", "no"})
+}
+
+// Adds a new client, asking for their details one at a time.
+// Also sent as /newclient, its name before admin commands.
+func (bot *Bot) botCommandAddClient(update tgbotapi.Update) (string,
+	*tgbotapi.InlineKeyboardMarkup) {
+	return bot.startConversation(update, "addclient", "firstName",
+		"What is the first name of the new client?")
+}
+
+// Changes the thresholds of the client with the id or name
+// supplied, asking for the client if left out
+func (bot *Bot) botCommandEditClient(update tgbotapi.Update) (string,
+	*tgbotapi.InlineKeyboardMarkup) {
+	return bot.startConversation(update, "editclient", "client",
+		"Which client do you want to change the thresholds of? Send their id or name.")
+}
+
+// Assigns the client to the officer, who then tracks the
+// client, e.g. "/assign 3 alice". Both are asked for if
+// left out.
+func (bot *Bot) botCommandAssign(update tgbotapi.Update) (string,
+	*tgbotapi.InlineKeyboardMarkup) {
+	return bot.startConversation(update, "assign", "client",
+		"Which client do you want to assign? Send their id or name.")
+}
+
+// Sends the message supplied to all officers on telegram,
+// once confirmed
+func (bot *Bot) botCommandBroadcast(update tgbotapi.Update) (string,
+	*tgbotapi.InlineKeyboardMarkup) {
+	return bot.startConversation(update, "broadcast", "message",
+		"What message do you want to send to all officers?")
+}
+
+// Lists all officers, along with whether they have
+// registered their telegram account with the bot
+func (bot *Bot) botCommandGetOfficers(update tgbotapi.Update) string {
+	tos, err := bot.store.TOfficers.List()
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE
+	}
+
+	message := "<b>List of officers</b>\n"
+	for _, to := range tos {
+		message += fmt.Sprintf("[%d] %s - %s %s (%s)",
+			to.Id,
+			html.EscapeString(to.Username),
+			html.EscapeString(to.FirstName),
+			html.EscapeString(to.LastName),
+			to.UserType,
+		)
+		if to.TelegramChatId == "" {
+			message += " - not on telegram"
+		}
+		message += "\n"
+	}
+	return message
+}
+
+// /addclient and /newclient, asking for the details of the client one at a time
+func (bot *Bot) conversationAddClient(to store.TO, conv *conversation,
+	input string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	switch conv.Step {
+	case "firstName":
+		if input == "" {
+			return "Please send the first name of the client.", nil
+		}
+		conv.Values["firstName"] = input
+		conv.Step = "lastName"
+		return "What is the last name of the client?", nil
+	case "lastName":
+		if input == "" {
+			return "Please send the last name of the client.", nil
+		}
+		conv.Values["lastName"] = input
+		conv.Step = "gender"
+		return "What is the gender of the client?",
+			conversationKeyboard([]string{"Male", "Female"}, []string{"male", "female"})
+	case "gender":
+		input = strings.ToLower(input)
+		if input != "male" && input != "female" {
+			return "Please pick the gender of the client.",
+				conversationKeyboard([]string{"Male", "Female"}, []string{"male", "female"})
+		}
+		conv.Values["gender"] = input
+		conv.Step = "urination"
+		return "How long may the client take to urinate, in seconds?",
+			conversationKeyboard([]string{fmt.Sprintf("Default (%d)", defaultUrination)},
+				[]string{strconv.Itoa(defaultUrination)})
+	case "urination", "defecation":
+		seconds, err := strconv.Atoi(input)
+		if err != nil || seconds <= 0 {
+			return "Please send the number of seconds.", nil
+		}
+		conv.Values[conv.Step] = input
+		if conv.Step == "urination" {
+			conv.Step = "defecation"
+			return "How long may the client take to defecate, in seconds?",
+				conversationKeyboard([]string{fmt.Sprintf("Default (%d)", defaultDefecation)},
+					[]string{strconv.Itoa(defaultDefecation)})
+		}
+		conv.Step = "confirm"
+		message := "<b>New client</b>\n"
+		message += "First name: " + html.EscapeString(conv.Values["firstName"]) + "\n"
+		message += "Last name: " + html.EscapeString(conv.Values["lastName"]) + "\n"
+		message += "Gender: " + conv.Values["gender"] + "\n"
+		message += "Urination: " + conv.Values["urination"] + "s\n"
+		message += "Defecation: " + conv.Values["defecation"] + "s\n"
+		message += "Add this client?"
+		return message, confirmKeyboard("Add")
+	case "confirm":
+		switch strings.ToLower(input) {
+		case "yes":
+		case "no":
+			conv.Step = ""
+			return "The client was not added.", nil
+		default:
+			return "Please press Add or Discard.", nil
+		}
+	}
+
+	conv.Step = ""
+	urination, _ := strconv.Atoi(conv.Values["urination"])
+	defecation, _ := strconv.Atoi(conv.Values["defecation"])
+	client := store.Client{
+		FirstName:  conv.Values["firstName"],
+		LastName:   conv.Values["lastName"],
+		Gender:     conv.Values["gender"],
+		Urination:  urination,
+		Defecation: defecation,
+	}
+	clientId, err := bot.store.Clients.Create(client)
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE, nil
+	}
+	client.Id = clientId
+	bot.recordAudit(to, store.AuditActionCreate,
+		store.AuditTargetClient, clientId, nil, client)
+	return fmt.Sprintf("Successfully added the client [%d]! Use /track %d to track them.",
+		clientId, clientId), nil
+}
+
+// /editclient, asking for the new thresholds of the client
+func (bot *Bot) conversationEditClient(to store.TO, conv *conversation,
+	input string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	switch conv.Step {
+	case "client":
+		client, message, keyboard := bot.pickClient(input)
+		if client.Id == 0 {
+			return message, keyboard
+		}
+		conv.Values["clientId"] = strconv.Itoa(client.Id)
+		conv.Values["name"] = client.FirstName + " " + client.LastName
+		conv.Values["currentUrination"] = strconv.Itoa(client.Urination)
+		conv.Values["currentDefecation"] = strconv.Itoa(client.Defecation)
+		conv.Step = "urination"
+		return "How long may " + html.EscapeString(conv.Values["name"]) +
+				" take to urinate, in seconds?",
+			conversationKeyboard([]string{fmt.Sprintf("Keep (%d)", client.Urination)},
+				[]string{conv.Values["currentUrination"]})
+	case "urination", "defecation":
+		seconds, err := strconv.Atoi(input)
+		if err != nil || seconds <= 0 {
+			return "Please send the number of seconds.", nil
+		}
+		conv.Values[conv.Step] = input
+		if conv.Step == "urination" {
+			conv.Step = "defecation"
+			return "How long may " + html.EscapeString(conv.Values["name"]) +
+					" take to defecate, in seconds?",
+				conversationKeyboard([]string{"Keep (" + conv.Values["currentDefecation"] + ")"},
+					[]string{conv.Values["currentDefecation"]})
+		}
+		conv.Step = "confirm"
+		message := "<b>Thresholds of " + html.EscapeString(conv.Values["name"]) + "</b>\n"
+		message += "Urination: " + conv.Values["currentUrination"] + "s → " +
+			conv.Values["urination"] + "s\n"
+		message += "Defecation: " + conv.Values["currentDefecation"] + "s → " +
+			conv.Values["defecation"] + "s\n"
+		message += "Save these thresholds?"
+		return message, confirmKeyboard("Save")
+	case "confirm":
+		switch strings.ToLower(input) {
+		case "yes":
+		case "no":
+			conv.Step = ""
+			return "The thresholds were not changed.", nil
+		default:
+			return "Please press Save or Discard.", nil
+		}
+	}
+
+	conv.Step = ""
+	clientId, _ := strconv.Atoi(conv.Values["clientId"])
+	client, err := bot.store.Clients.Get(clientId)
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE, nil
+	}
+	before := client
+	client.Urination, _ = strconv.Atoi(conv.Values["urination"])
+	client.Defecation, _ = strconv.Atoi(conv.Values["defecation"])
+	err = bot.store.Clients.Update(client)
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE, nil
+	}
+	bot.recordAudit(to, store.AuditActionUpdate,
+		store.AuditTargetClient, client.Id, before, client)
+
+	message := "Successfully changed the thresholds of " +
+		html.EscapeString(conv.Values["name"]) + "!"
+	if client.AutoThreshold {
+		message += " Note that suggested thresholds are applied automatically for this client, which may replace them."
+	}
+	return message, nil
+}
+
+// Finds the officer from the input, which is either the id,
+// the username, or the start of the name of the officer.
+// Returns an officer with id 0 along with the reply to send
+// if the officer is not found, or one of several officers
+// needs to be picked.
+func (bot *Bot) pickOfficer(input string) (store.TO, string,
+	*tgbotapi.InlineKeyboardMarkup) {
+	var tos []store.TO
+	var err error
+	if input == "" {
+		tos, err = bot.store.TOfficers.List()
+	} else if toId, atoiErr := strconv.Atoi(input); atoiErr == nil {
+		to, err := bot.store.TOfficers.Get(toId)
+		if err != nil {
+			return store.TO{}, "No officer found with the id [" + input +
+				"]. Please send the username of the officer.", nil
+		}
+		return to, "", nil
+	} else if to, getErr := bot.store.TOfficers.GetByUsername(input); getErr == nil {
+		return to, "", nil
+	} else {
+		tos, err = bot.store.TOfficers.Search(input, 0)
+	}
+	if err != nil {
+		log.Println(err)
+		return store.TO{}, GENERIC_ERROR_MESSAGE, nil
+	}
+
+	switch {
+	case len(tos) == 0:
+		return store.TO{}, "No officers found with the name \"" +
+			html.EscapeString(input) + "\". Please try another name.", nil
+	case len(tos) == 1 && input != "":
+		return tos[0], "", nil
+	case len(tos) > pickLimit:
+		return store.TO{}, "Please send the username of the officer.", nil
+	}
+
+	var labels, values []string
+	for _, to := range tos {
+		labels = append(labels, fmt.Sprintf("%s - %s %s",
+			to.Username, to.FirstName, to.LastName))
+		values = append(values, strconv.Itoa(to.Id))
+	}
+	return store.TO{}, "Please pick the officer.", conversationKeyboard(labels, values)
+}
+
+// /assign, asking for the client and the officer. The
+// username of the officer may follow the client.
+func (bot *Bot) conversationAssign(to store.TO, conv *conversation,
+	input string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	switch conv.Step {
+	case "client":
+		clientInput := input
+		officerInput := ""
+		if fields := strings.Fields(input); len(fields) > 1 {
+			username := fields[len(fields)-1]
+			_, err := bot.store.TOfficers.GetByUsername(username)
+			if err == nil {
+				clientInput = strings.Join(fields[:len(fields)-1], " ")
+				officerInput = username
+			}
+		}
+
+		client, message, keyboard := bot.pickClient(clientInput)
+		if client.Id == 0 {
+			return message, keyboard
+		}
+		conv.Values["clientId"] = strconv.Itoa(client.Id)
+		conv.Values["name"] = client.FirstName + " " + client.LastName
+		conv.Step = "officer"
+		if officerInput == "" {
+			_, message, keyboard := bot.pickOfficer("")
+			return "Which officer should " + html.EscapeString(conv.Values["name"]) +
+				" be assigned to? " + message, keyboard
+		}
+		input = officerInput
+		fallthrough
+	case "officer":
+		officer, message, keyboard := bot.pickOfficer(input)
+		if officer.Id == 0 {
+			return message, keyboard
+		}
+		if !store.HasPermission(officer.UserType, store.PermissionTrack) {
+			return html.EscapeString(officer.Username) +
+				" cannot track clients. Which officer should " +
+				html.EscapeString(conv.Values["name"]) + " be assigned to?", nil
+		}
+		conv.Step = ""
+
+		clientId, _ := strconv.Atoi(conv.Values["clientId"])
+		err := bot.store.Track.Add(officer.Id, clientId)
+		if err != nil {
+			log.Println(err)
+			return GENERIC_ERROR_MESSAGE, nil
+		}
+		bot.recordAudit(to, store.AuditActionTrack, store.AuditTargetClient,
+			clientId, nil, map[string]int{"toId": officer.Id})
+
+		name := html.EscapeString(conv.Values["name"])
+		if officer.Id != to.Id {
+			bot.sendToOfficer(officer, "You have been assigned "+name+" by "+
+				html.EscapeString(to.Username)+". Use /current to see your tracked clients.")
+		}
+		return "Successfully assigned " + name + " to " +
+			html.EscapeString(officer.Username) + "!", nil
+	}
+	return GENERIC_ERROR_MESSAGE, nil
+}
+
+// /broadcast, asking for the message and confirming
+// before sending it
+func (bot *Bot) conversationBroadcast(to store.TO, conv *conversation,
+	input string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	tos, err := bot.store.TOfficers.List()
+	if err != nil {
+		log.Println(err)
+		conv.Step = ""
+		return GENERIC_ERROR_MESSAGE, nil
+	}
+	// Everyone on telegram other than the sender
+	var recipients []store.TO
+	for _, recipient := range tos {
+		if recipient.TelegramChatId != "" && recipient.Id != to.Id {
+			recipients = append(recipients, recipient)
+		}
+	}
+
+	switch conv.Step {
+	case "message":
+		if input == "" {
+			return "Please send the message.", nil
+		}
+		conv.Values["message"] = input
+		conv.Step = "confirm"
+		return fmt.Sprintf("<b>Broadcast</b>\n%s\nSend this message to %d officers?",
+			html.EscapeString(input), len(recipients)), confirmKeyboard("Send")
+	case "confirm":
+		switch strings.ToLower(input) {
+		case "yes":
+		case "no":
+			conv.Step = ""
+			return "The message was not sent.", nil
+		default:
+			return "Please press Send or Discard.", nil
+		}
+	}
+
+	conv.Step = ""
+	text := "📢 <b>Message from " + html.EscapeString(to.Username) + "</b>\n" +
+		html.EscapeString(conv.Values["message"])
+	sent := 0
+	for _, recipient := range recipients {
+		if bot.sendToOfficer(recipient, text) {
+			sent++
+		}
+	}
+	return fmt.Sprintf("Sent the message to %d of %d officers.", sent, len(recipients)), nil
+}
+
+// Sends the message to the officer, if they have registered
+// their telegram account, returning whether it was sent
+func (bot *Bot) sendToOfficer(to store.TO, text string) bool {
+	if to.TelegramChatId == "" {
+		return false
+	}
+	chatId, err := strconv.ParseInt(to.TelegramChatId, 10, 64)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	message := tgbotapi.NewMessage(chatId, text)
+	message.ParseMode = tgbotapi.ModeHTML
+	_, err = bot.bot.Send(message)
+	if err != nil {
+		log.Println("Error sending message")
+		log.Println(err)
+		return false
+	}
+	return true
+}
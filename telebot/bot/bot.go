@@ -0,0 +1,258 @@
+package bot
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/genekkion/PottySenseShared/store"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const GENERIC_ERROR_MESSAGE = "Error processing your request right now. Please try again later!"
+
+type Bot struct {
+	bot        *tgbotapi.BotAPI
+	store      *store.Store
+	redisCache *redis.Client
+	server     ServerClient
+}
+
+func NewBot(telegramBotToken string, db *sql.DB,
+	redisCache *redis.Client, server ServerClient) *Bot {
+	bot, err := tgbotapi.NewBotAPI(telegramBotToken)
+	if err != nil {
+		log.Println("Error creating bot.")
+		log.Fatalln(err)
+	}
+
+	bot.Debug = os.Getenv("IS_PROD") == "false"
+
+	return &Bot{
+		bot:        bot,
+		store:      store.New(db),
+		redisCache: redisCache,
+		server:     server,
+	}
+}
+
+// Wraps all commands requiring the user to be
+// authorised on the platform. All except
+// "/start" will use this wrapper.
+func (bot *Bot) authWrapper(function botCommandFunc) botCommandFunc {
+	return func(update tgbotapi.Update) string {
+		_, err := bot.getTO(update)
+		if err != nil {
+			log.Println(err)
+			return "Unauthorized user."
+		}
+		return function(update)
+	}
+}
+
+// authWrapper for commands replying with buttons
+func (bot *Bot) authKeyboardWrapper(function botKeyboardCommandFunc) botKeyboardCommandFunc {
+	return func(update tgbotapi.Update) (string, *tgbotapi.InlineKeyboardMarkup) {
+		_, err := bot.getTO(update)
+		if err != nil {
+			log.Println(err)
+			return "Unauthorized user.", nil
+		}
+		return function(update)
+	}
+}
+
+// Wraps the commands which need the permission supplied,
+// replying to other TOs as authWrapper does
+func (bot *Bot) permissionWrapper(permission string,
+	function botCommandFunc) botCommandFunc {
+	return func(update tgbotapi.Update) string {
+		to, err := bot.getTO(update)
+		if err != nil {
+			log.Println(err)
+			return "Unauthorized user."
+		}
+		if !store.HasPermission(to.UserType, permission) {
+			return "You do not have permission to use this command."
+		}
+		return function(update)
+	}
+}
+
+// permissionWrapper for commands replying with buttons
+func (bot *Bot) permissionKeyboardWrapper(permission string,
+	function botKeyboardCommandFunc) botKeyboardCommandFunc {
+	return func(update tgbotapi.Update) (string, *tgbotapi.InlineKeyboardMarkup) {
+		to, err := bot.getTO(update)
+		if err != nil {
+			log.Println(err)
+			return "Unauthorized user.", nil
+		}
+		if !store.HasPermission(to.UserType, permission) {
+			return "You do not have permission to use this command.", nil
+		}
+		return function(update)
+	}
+}
+
+// Records the change made by the TO through the bot in the
+// audit log. Failures are only logged, as the change has
+// already been made.
+func (bot *Bot) recordAudit(to store.TO, action string,
+	targetType string, targetId int, before any, after any) {
+	_, err := bot.store.Audit.Record(store.AuditEntry{
+		ActorId:    to.Id,
+		Actor:      to.Username,
+		Source:     store.AuditSourceBot,
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   targetId,
+		Before:     store.AuditValue(before),
+		After:      store.AuditValue(after),
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Starts running the bot, polling telegram for updates
+func (bot *Bot) Run() {
+	// Updates cannot be polled while a webhook is set
+	_, err := bot.bot.Request(tgbotapi.DeleteWebhookConfig{})
+	if err != nil {
+		log.Println("Error removing webhook.")
+		log.Fatalln(err)
+	}
+	log.Println(bot.bot.Self.UserName + " has started polling.")
+
+	updateConfig := tgbotapi.NewUpdate(0)
+	updateConfig.Timeout = 60
+
+	bot.handleUpdates(bot.bot.GetUpdatesChan(updateConfig))
+}
+
+// Replies to the updates received, one at a time
+func (bot *Bot) handleUpdates(updatesChannel tgbotapi.UpdatesChannel) {
+	for update := range updatesChannel {
+		if update.CallbackQuery != nil {
+			bot.handleCallbackQuery(update.CallbackQuery)
+			continue
+		}
+		if update.Message == nil {
+			continue
+		}
+
+		message := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+		//message.ParseMode = tgbotapi.ModeMarkdownV2
+		message.ParseMode = tgbotapi.ModeHTML
+		if !update.Message.IsCommand() {
+			// Replies to the command waiting for them, if any
+			message.Text, message.ReplyMarkup = bot.continueConversation(update)
+			if message.Text == "" {
+				continue
+			}
+		} else {
+			// Any command of the TO stops their command waiting for replies
+			stopped := bot.stopConversation(update)
+
+			switch strings.ToLower(update.Message.Command()) {
+			case "start":
+				message.Text = bot.botCommandStart(update)
+			case "clients":
+				message.Text = bot.authWrapper(bot.botCommandGetAllClients)(update)
+			case "current":
+				message.Text, message.ReplyMarkup = bot.permissionKeyboardWrapper(store.PermissionTrack, bot.botCommandGetCurrentClients)(update)
+			case "search":
+				message.Text = bot.authWrapper(bot.botCommandSearchName)(update)
+			case "id":
+				message.Text = bot.authWrapper(bot.botCommandGetClient)(update)
+			case "track":
+				message.Text, message.ReplyMarkup = bot.permissionKeyboardWrapper(store.PermissionTrack, bot.botCommandTrackClient)(update)
+			case "untrack":
+				message.Text = bot.permissionWrapper(store.PermissionTrack, bot.botCommandUnTrackClient)(update)
+			case "help":
+				message.Text = bot.authWrapper(bot.botCommandHelp)(update)
+			case "session":
+				message.Text, message.ReplyMarkup = bot.permissionKeyboardWrapper(store.PermissionTrack, bot.botCommandSessionStart)(update)
+			case "cancel":
+				message.Text = bot.permissionWrapper(store.PermissionTrack, bot.botCommandSessionCancel)(update)
+			case "toilets":
+				message.Text = bot.authWrapper(bot.botCommandGetToilets)(update)
+			case "sessions":
+				message.Text = bot.authWrapper(bot.botCommandGetSessions)(update)
+			case "addclient", "newclient":
+				message.Text, message.ReplyMarkup = bot.permissionKeyboardWrapper(store.PermissionEditClients, bot.botCommandAddClient)(update)
+			case "editclient":
+				message.Text, message.ReplyMarkup = bot.permissionKeyboardWrapper(store.PermissionEditClients, bot.botCommandEditClient)(update)
+			case "assign":
+				message.Text, message.ReplyMarkup = bot.permissionKeyboardWrapper(store.PermissionAssignClients, bot.botCommandAssign)(update)
+			case "officers":
+				message.Text = bot.permissionWrapper(store.PermissionAssignClients, bot.botCommandGetOfficers)(update)
+			case "broadcast":
+				message.Text, message.ReplyMarkup = bot.permissionKeyboardWrapper(store.PermissionBroadcast, bot.botCommandBroadcast)(update)
+			case "stop":
+				if stopped {
+					message.Text = "Stopped the command."
+				} else {
+					message.Text = "There is no command to stop."
+				}
+			default:
+				message.Text = "Error, command not found. Please use /help to get the list of available commands."
+
+			}
+		}
+
+		_, err := bot.bot.Send(message)
+		if err != nil {
+			log.Println("Error sending message")
+			log.Println(err)
+		}
+
+	}
+}
+
+// Commands listed by /help, along with the
+// permission needed to use them, if any
+var helpCommands = []struct {
+	command     string
+	description string
+	permission  string
+}{
+	{"start", "Register Telegram account", ""},
+	{"clients", "Get all clients", ""},
+	{"current", "Get all currently tracked clients, with buttons to start or cancel their sessions", store.PermissionTrack},
+	{"id", "Get the client with the id supplied", ""},
+	{"track", "Start tracking the client with the id or name supplied, or pick the client", store.PermissionTrack},
+	{"untrack", "Stop tracking the client with the id supplied", store.PermissionTrack},
+	{"session", "Start a session for the client with the id or name supplied, or pick the client and toilet", store.PermissionTrack},
+	{"cancel", "Cancel the session for the client with the id supplied, optionally followed by the reason", store.PermissionTrack},
+	{"sessions", "Get all active sessions", ""},
+	{"toilets", "Get all toilets", ""},
+	{"search", "Get the clients with the name supplied", ""},
+	{"addclient", "Add a new client, also /newclient", store.PermissionEditClients},
+	{"editclient", "Change the thresholds of the client with the id or name supplied, or pick the client", store.PermissionEditClients},
+	{"assign", "Assign the client to the officer, e.g. /assign 3 alice, or pick both", store.PermissionAssignClients},
+	{"officers", "Get all officers", store.PermissionAssignClients},
+	{"broadcast", "Send the message supplied to all officers", store.PermissionBroadcast},
+	{"stop", "Stop the command waiting for your reply", ""},
+	{"help", "List all available commands", ""},
+}
+
+// Lists the commands the TO has the permission to use
+func (bot *Bot) botCommandHelp(update tgbotapi.Update) string {
+	to, _ := bot.getTO(update)
+
+	message := "<b>List of supported commands:</b>\n"
+	number := 0
+	for _, help := range helpCommands {
+		if help.permission != "" && !store.HasPermission(to.UserType, help.permission) {
+			continue
+		}
+		number++
+		message += fmt.Sprintf("<b>%d.</b> /%s - %s\n", number, help.command, help.description)
+	}
+	return message
+}
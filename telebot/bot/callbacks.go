@@ -0,0 +1,276 @@
+package bot
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/genekkion/PottySenseShared/store"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Actions of the session buttons. The callback data of a
+// button is "session:<action>:<client id>:<toilet id>", with
+// the toilet id 0 if not chosen yet.
+const (
+	sessionCallbackPrefix = "session:"
+	sessionActionStart    = "start"
+	sessionActionCancel   = "cancel"
+	// Goes back to the list of tracked clients
+	sessionActionList = "list"
+)
+
+// Gets the callback data of the session button
+func sessionCallbackData(action string, clientId int, toiletId int) string {
+	return fmt.Sprintf("%s%s:%d:%d", sessionCallbackPrefix, action, clientId, toiletId)
+}
+
+// Gets the action, client id and toilet id from the callback data
+// of the button, returning false if it is not a session button
+func parseSessionCallbackData(data string) (string, int, int, bool) {
+	if !strings.HasPrefix(data, sessionCallbackPrefix) {
+		return "", 0, 0, false
+	}
+	parts := strings.Split(strings.TrimPrefix(data, sessionCallbackPrefix), ":")
+	if len(parts) != 3 {
+		return "", 0, 0, false
+	}
+	clientId, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, 0, false
+	}
+	toiletId, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return "", 0, 0, false
+	}
+	return parts[0], clientId, toiletId, true
+}
+
+// Handles the presses of the inline buttons, answering
+// the query with a short notice shown to the user
+func (bot *Bot) handleCallbackQuery(query *tgbotapi.CallbackQuery) {
+	var text string
+	if action, alertId, ok := store.ParseAlertCallbackData(query.Data); ok {
+		if action == store.AlertActionAcknowledge {
+			text = bot.callbackAcknowledgeAlert(query, alertId)
+		} else {
+			text = bot.callbackRespondAlert(query, action, alertId)
+		}
+	} else if action, clientId, toiletId, ok := parseSessionCallbackData(query.Data); ok {
+		text = bot.callbackSession(query, action, clientId, toiletId)
+	} else if value, ok := strings.CutPrefix(query.Data, conversationCallbackPrefix); ok {
+		text = bot.callbackConversation(query, value)
+	} else {
+		text = "Unknown action."
+	}
+
+	_, err := bot.bot.Request(tgbotapi.NewCallback(query.ID, text))
+	if err != nil {
+		log.Println("Error answering callback query")
+		log.Println(err)
+	}
+}
+
+// Gets the TO who pressed the button. Buttons can also be
+// pressed in group chats, e.g. the fallback chat of alerts,
+// so the TO is found by their user id rather than the chat.
+func (bot *Bot) getCallbackTO(query *tgbotapi.CallbackQuery) (store.TO, error) {
+	return bot.store.TOfficers.GetByTelegramChatId(
+		strconv.FormatInt(query.From.ID, 10))
+}
+
+// Acknowledges the alert on behalf of the TO who pressed the
+// button, which stops the alert from being escalated
+func (bot *Bot) callbackAcknowledgeAlert(query *tgbotapi.CallbackQuery,
+	alertId int) string {
+	to, err := bot.getCallbackTO(query)
+	if err != nil {
+		log.Println(err)
+		return "Unauthorized user."
+	}
+
+	acknowledgedAt := time.Now()
+	err = bot.store.Alerts.Acknowledge(alertId, to.Id, acknowledgedAt.UTC())
+	if err == store.ErrAlertAcknowledged {
+		return "This alert has already been acknowledged."
+	} else if err == sql.ErrNoRows {
+		return "Alert not found."
+	} else if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE
+	}
+	bot.recordAudit(to, store.AuditActionRespond, store.AuditTargetAlert,
+		alertId, nil, map[string]string{"response": "ack"})
+
+	// The other TOs can still respond to the alert
+	bot.updateAlertMessages(alertId, "✅ <b>Alert acknowledged</b> ✅",
+		"Acknowledged by "+to.Username+" at "+acknowledgedAt.Format("15:04")+".",
+		alertResponseKeyboard(alertId, store.AlertActionOnMyWay, store.AlertActionResolved))
+	return "Alert acknowledged!"
+}
+
+// Records the response of the TO who pressed the button on the
+// server, which also acknowledges the alert, and updates the
+// alert messages so that the other TOs know of the response
+func (bot *Bot) callbackRespondAlert(query *tgbotapi.CallbackQuery,
+	action string, alertId int) string {
+	to, err := bot.getCallbackTO(query)
+	if err != nil {
+		log.Println(err)
+		return "Unauthorized user."
+	}
+
+	err = bot.server.RespondAlert(alertId, to.Id, action)
+	if err != nil {
+		return serverErrorMessage(err)
+	}
+
+	respondedAt := time.Now().Format("15:04")
+	if action == store.AlertActionOnMyWay {
+		bot.updateAlertMessages(alertId,
+			"🏃 <b>"+html.EscapeString(to.Username)+" is on the way</b> 🏃",
+			"On the way since "+respondedAt+".",
+			alertResponseKeyboard(alertId, store.AlertActionResolved))
+		return "The other TOs have been told you are on the way."
+	}
+	bot.updateAlertMessages(alertId, "✅ <b>Alert resolved</b> ✅",
+		"Resolved by "+to.Username+" at "+respondedAt+".", nil)
+	return "Alert resolved!"
+}
+
+// Buttons to respond to the alert with the actions supplied
+func alertResponseKeyboard(alertId int,
+	actions ...string) *tgbotapi.InlineKeyboardMarkup {
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, action := range actions {
+		text := "On my way"
+		if action == store.AlertActionResolved {
+			text = "Resolved"
+		}
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(
+			text, store.AlertCallbackData(action, alertId)))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(buttons)
+	return &keyboard
+}
+
+// Replaces all the messages sent for the alert with the header
+// and the note in italics, along with the buttons supplied,
+// which are removed if nil
+func (bot *Bot) updateAlertMessages(alertId int, header string,
+	note string, keyboard *tgbotapi.InlineKeyboardMarkup) {
+	alert, err := bot.store.Alerts.Get(alertId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	messages, err := bot.store.Alerts.ListMessages(alertId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	text := header + "\n" + html.EscapeString(alert.Message) + "\n"
+	text += "<i>" + html.EscapeString(note) + "</i>"
+	for _, message := range messages {
+		chatId, err := strconv.ParseInt(message.ChatId, 10, 64)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		edit := tgbotapi.NewEditMessageText(chatId, message.MessageId, text)
+		edit.ParseMode = tgbotapi.ModeHTML
+		edit.ReplyMarkup = keyboard
+		_, err = bot.bot.Request(edit)
+		if err != nil {
+			log.Println("Error updating alert message")
+			log.Println(err)
+		}
+	}
+}
+
+// Starts or cancels the session of the client on behalf of the
+// TO who pressed the button, then refreshes the list of tracked
+// clients. If the toilet needs to be chosen, the list is replaced
+// with the toilets to choose from.
+func (bot *Bot) callbackSession(query *tgbotapi.CallbackQuery,
+	action string, clientId int, toiletId int) string {
+	to, err := bot.getCallbackTO(query)
+	if err != nil {
+		log.Println(err)
+		return "Unauthorized user."
+	}
+	if !store.HasPermission(to.UserType, store.PermissionTrack) {
+		return "You do not have permission to start or cancel sessions."
+	}
+
+	var text string
+	switch action {
+	case sessionActionStart:
+		var toiletRequired bool
+		text, toiletRequired = bot.startSession(clientId, toiletId, to.Id)
+		if toiletRequired {
+			bot.showToiletChoice(query, clientId)
+			return "Please choose the toilet."
+		}
+	case sessionActionCancel:
+		text = bot.cancelSession(clientId, to.Id, "")
+	case sessionActionList:
+		text = "Refreshed."
+	default:
+		return "Unknown action."
+	}
+
+	if query.Message != nil {
+		message, keyboard := bot.currentClientsReply(to)
+		edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID,
+			query.Message.MessageID, message)
+		edit.ParseMode = tgbotapi.ModeHTML
+		edit.ReplyMarkup = keyboard
+		_, err = bot.bot.Request(edit)
+		if err != nil {
+			log.Println("Error updating current clients message")
+			log.Println(err)
+		}
+	}
+	return text
+}
+
+// Replaces the message of the button with the toilets
+// to start the session of the client at
+func (bot *Bot) showToiletChoice(query *tgbotapi.CallbackQuery, clientId int) {
+	if query.Message == nil {
+		return
+	}
+	toilets, err := bot.store.Toilets.List()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, toilet := range toilets {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("[%d] %s - %s", toilet.Id, toilet.Name, toilet.Location),
+				sessionCallbackData(sessionActionStart, clientId, toilet.Id))))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Back",
+			sessionCallbackData(sessionActionList, clientId, 0))))
+
+	edit := tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID,
+		query.Message.MessageID,
+		fmt.Sprintf("<b>Choose the toilet for client [%d]</b>", clientId),
+		tgbotapi.NewInlineKeyboardMarkup(rows...))
+	edit.ParseMode = tgbotapi.ModeHTML
+	_, err = bot.bot.Request(edit)
+	if err != nil {
+		log.Println("Error showing toilet choice")
+		log.Println(err)
+	}
+}
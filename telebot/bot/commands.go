@@ -0,0 +1,420 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/genekkion/PottySenseShared/store"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type botCommandFunc func(tgbotapi.Update) string
+
+// Command replying with buttons under the message,
+// nil if there are no buttons
+type botKeyboardCommandFunc func(tgbotapi.Update) (string, *tgbotapi.InlineKeyboardMarkup)
+
+// Gets the TO who registered the chat with the bot
+func (bot *Bot) getTO(update tgbotapi.Update) (store.TO, error) {
+	return bot.store.TOfficers.GetByTelegramChatId(
+		strconv.FormatInt(update.Message.Chat.ID, 10))
+}
+
+// Gets the TO who sent the message, which differs from the
+// TO of the chat in group chats
+func (bot *Bot) getSenderTO(update tgbotapi.Update) (store.TO, error) {
+	return bot.store.TOfficers.GetByTelegramChatId(
+		strconv.FormatInt(update.SentFrom().ID, 10))
+}
+
+// Registers user if authorised.
+func (bot *Bot) botCommandStart(update tgbotapi.Update) string {
+	_, err := bot.getTO(update)
+	if err == nil {
+		return "Your account has already been registered with PottySense!"
+	} else if err != sql.ErrNoRows {
+		// Problems connecting to DB
+		log.Fatalln(err)
+	}
+
+	username := strings.ToLower(update.SentFrom().UserName)
+
+	toIDStr, err := bot.redisCache.Get(context.Background(), username).Result()
+	// Only errors here when the cache does not have
+	// the info. Means user needs to go on platform
+	// to register first
+	if err != nil {
+		log.Println("botCommandStart(), redis get")
+		log.Println(err)
+		return "Unauthorized user"
+	}
+
+	toID, err := strconv.Atoi(toIDStr)
+	if err != nil {
+		log.Println("botCommandStart(), atoi")
+		log.Println(err)
+		return "Error processing your request right now, please try again later!"
+	}
+
+	err = bot.store.TOfficers.SetTelegramChatId(toID,
+		strconv.FormatInt(update.Message.Chat.ID, 10))
+	if err != nil {
+		log.Println("botCommandStart(), update sql")
+		log.Println(err)
+		return "Error processing your request right now, please try again later!"
+	}
+
+	err = bot.redisCache.Del(context.Background(), username).Err()
+	if err != nil {
+		log.Println("Error deleting key from cache. Rectify immediately!")
+		// Needs to be rectified immediately to prevent
+		// odd behaviours
+	}
+	return "Your account has been registered with PottySense!"
+}
+
+func (bot *Bot) botCommandGetAllClients(update tgbotapi.Update) string {
+	clients, err := bot.store.Clients.List()
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE
+	}
+
+	if len(clients) == 0 {
+		return "No clients found in the database."
+	}
+	message := "<b>List of clients</b>\n"
+	for _, client := range clients {
+		message += fmt.Sprintf("[%d] %s %s\n",
+			client.Id, client.FirstName, client.LastName,
+		)
+	}
+
+	return message
+}
+
+// Lists the tracked clients, with buttons to start
+// or cancel the session of each client
+func (bot *Bot) botCommandGetCurrentClients(update tgbotapi.Update) (string,
+	*tgbotapi.InlineKeyboardMarkup) {
+	to, err := bot.getTO(update)
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE, nil
+	}
+	return bot.currentClientsReply(to)
+}
+
+// Reply to /current for the TO, which is also
+// used to refresh the list after a button is pressed
+func (bot *Bot) currentClientsReply(to store.TO) (string,
+	*tgbotapi.InlineKeyboardMarkup) {
+	clients, err := bot.store.Track.ListClients(to.Id)
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE, nil
+	}
+	if len(clients) == 0 {
+		return "You are currently not tracking any clients.", nil
+	}
+
+	message := "<b>Currently tracking</b>\n"
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, client := range clients {
+		message += fmt.Sprintf("[%d] %s %s - %s",
+			client.Id,
+			client.FirstName,
+			client.LastName,
+			getTimeElapsedPretty(client.LastRecord),
+		)
+		var button tgbotapi.InlineKeyboardButton
+		if client.SessionPhase != 0 {
+			message += " - " + sessionPhasePretty(client.SessionPhase)
+			if client.Responder != "" {
+				message += " - " + client.Responder + " on the way"
+			}
+			button = tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("Cancel [%d] %s", client.Id, client.FirstName),
+				sessionCallbackData(sessionActionCancel, client.Id, 0))
+		} else {
+			button = tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("Start session [%d] %s", client.Id, client.FirstName),
+				sessionCallbackData(sessionActionStart, client.Id, 0))
+		}
+		message += "\n"
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return message, &keyboard
+}
+
+func getTimeElapsedPretty(timeRecord time.Time) string {
+	elapsedTime := time.Since(timeRecord)
+	return fmt.Sprintf("%02d:%02d",
+		int(elapsedTime.Hours()),
+		int(elapsedTime.Minutes())%60,
+	)
+}
+
+// Searches for clients by the start of their first, last
+// or full name, e.g. "/search John Tan"
+func (bot *Bot) botCommandSearchName(update tgbotapi.Update) string {
+	query := strings.Join(strings.Fields(update.Message.CommandArguments()), " ")
+	if query == "" {
+		return "Please use the /search command with a name after the command."
+	}
+
+	clients, err := bot.store.Clients.Search(query)
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE
+	}
+	if len(clients) == 0 {
+		return "No clients found with the name \"" + query + "\"."
+	}
+	message := "<b>List of clients with the name \"" + query + "\"</b>\n"
+	for _, client := range clients {
+		message += fmt.Sprintf("[%d] %s %s\n",
+			client.Id, client.FirstName, client.LastName,
+		)
+	}
+	return message
+}
+
+func (bot *Bot) botCommandGetClient(update tgbotapi.Update) string {
+	queries := strings.Split(update.Message.Text, " ")
+	// Only accept 1 name query at a time
+	if len(queries) != 2 {
+		return "Please use the /id command with exactly 1 id after the command."
+	}
+	query := queries[1]
+
+	clientId, err := strconv.Atoi(query)
+	if err != nil {
+		return "Please use the /id command with the numeric id of the client."
+	}
+
+	client, err := bot.store.Clients.Get(clientId)
+	if err == sql.ErrNoRows {
+		return "No client found with the id [" + query + "]."
+	} else if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE
+	}
+
+	message := "<b>Client [" + query + "]</b>\n"
+	message += fmt.Sprintf("First name: %s\n", client.FirstName)
+	message += fmt.Sprintf("Last name: %s\n", client.LastName)
+	message += fmt.Sprintf("Urination: %s\n", secondsTimeString(client.Urination))
+	message += fmt.Sprintf("Defecation: %s\n", secondsTimeString(client.Defecation))
+	message += fmt.Sprintf("Last record: %s\n", getTimeElapsedPretty(client.LastRecord))
+	return message
+}
+
+func secondsTimeString(seconds int) string {
+	duration := time.Duration(seconds) * time.Second
+	return time.Time{}.Add(duration).Format("04:05")
+}
+
+// Tracks the client with the id or name supplied, asking
+// for the client if left out
+func (bot *Bot) botCommandTrackClient(update tgbotapi.Update) (string,
+	*tgbotapi.InlineKeyboardMarkup) {
+	return bot.startConversation(update, "track", "client",
+		"Which client do you want to track? Send their id or name.")
+}
+
+func (bot *Bot) botCommandUnTrackClient(update tgbotapi.Update) string {
+	queries := strings.Split(update.Message.Text, " ")
+	// Only accept 1 name query at a time
+	if len(queries) != 2 {
+		return "Please use the /untrack command with exactly 1 id after the command."
+	}
+	query := queries[1]
+
+	clientId, err := strconv.Atoi(query)
+	if err != nil {
+		return "Please use the /untrack command with the numeric id of the client."
+	}
+
+	to, err := bot.getTO(update)
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE
+	}
+
+	err = bot.store.Track.Remove(to.Id, clientId)
+	if err != nil {
+		return GENERIC_ERROR_MESSAGE
+	}
+	bot.recordAudit(to, store.AuditActionUntrack,
+		store.AuditTargetClient, clientId, nil, nil)
+	return "Successfully removed from your tracking list!"
+}
+
+// Starts a session for the client, optionally at the toilet
+// supplied, e.g. "/session 3 1". The client can also be found
+// by name, and is asked for if left out, as is the toilet if
+// there are multiple toilets.
+func (bot *Bot) botCommandSessionStart(update tgbotapi.Update) (string,
+	*tgbotapi.InlineKeyboardMarkup) {
+	queries := strings.Fields(update.Message.CommandArguments())
+	if len(queries) == 2 {
+		clientId, clientErr := strconv.Atoi(queries[0])
+		toiletId, toiletErr := strconv.Atoi(queries[1])
+		if clientErr == nil && toiletErr == nil {
+			to, err := bot.getTO(update)
+			if err != nil {
+				log.Println(err)
+				return GENERIC_ERROR_MESSAGE, nil
+			}
+			message, _ := bot.startSession(clientId, toiletId, to.Id)
+			return message, nil
+		}
+	}
+	return bot.startConversation(update, "session", "client",
+		"Which client should the session be started for? Send their id or name.")
+}
+
+// Asks the server to start a session for the client, returning
+// the reply to the TO and whether the toilet needs to be chosen
+// as there are multiple toilets
+func (bot *Bot) startSession(clientId int, toiletId int,
+	toId int) (string, bool) {
+	err := bot.server.StartSession(clientId, toiletId, toId)
+	var serverErr ServerError
+	if err == nil {
+		return "Successfully started the session!", false
+	} else if errors.As(err, &serverErr) {
+		return serverErr.Message, serverErr.Status == http.StatusBadRequest
+	}
+	log.Println(err)
+	return GENERIC_ERROR_MESSAGE, false
+}
+
+// Cancels the active session of the client. Anything
+// after the client id is recorded as the reason.
+func (bot *Bot) botCommandSessionCancel(update tgbotapi.Update) string {
+
+	queries := strings.SplitN(update.Message.Text, " ", 3)
+	if len(queries) < 2 {
+		return "Please use the /cancel command with the client id, optionally followed by the reason."
+	}
+	query := queries[1]
+
+	clientId, err := strconv.Atoi(query)
+	if err != nil {
+		return "Please use the /cancel command with the numeric id of the client."
+	}
+
+	reason := ""
+	if len(queries) == 3 {
+		reason = strings.TrimSpace(queries[2])
+	}
+
+	to, err := bot.getTO(update)
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE
+	}
+	return bot.cancelSession(clientId, to.Id, reason)
+}
+
+// Asks the server to cancel the active session of the
+// client on behalf of the TO, returning the reply to the TO
+func (bot *Bot) cancelSession(clientId int, toId int, reason string) string {
+	err := bot.server.CancelSession(clientId, toId, reason)
+	var serverErr ServerError
+	if err == nil {
+		return "Successfully deleted the session!"
+	} else if errors.As(err, &serverErr) && serverErr.Status == http.StatusNotFound {
+		return "No session found for client with id " + fmt.Sprint(clientId) + "."
+	}
+	return serverErrorMessage(err)
+}
+
+// Lists all the active sessions
+func (bot *Bot) botCommandGetSessions(update tgbotapi.Update) string {
+	sessions, err := bot.store.Sessions.ListActive()
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE
+	}
+	if len(sessions) == 0 {
+		return "There are no active sessions."
+	}
+
+	clients, err := bot.store.Clients.List()
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE
+	}
+	clientsById := make(map[int]store.Client, len(clients))
+	for _, client := range clients {
+		clientsById[client.Id] = client
+	}
+	toilets, err := bot.store.Toilets.List()
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE
+	}
+	toiletNames := make(map[int]string, len(toilets))
+	for _, toilet := range toilets {
+		toiletNames[toilet.Id] = toilet.Name
+	}
+
+	message := "<b>Active sessions</b>\n"
+	for _, session := range sessions {
+		client := clientsById[session.ClientId]
+		message += fmt.Sprintf("[%d] %s %s @ %s - %s (%s)\n",
+			session.ClientId,
+			client.FirstName,
+			client.LastName,
+			toiletNames[session.ToiletId],
+			sessionPhasePretty(session.Phase),
+			getTimeElapsedPretty(session.StartedAt),
+		)
+	}
+	return message
+}
+
+// Human readable description of the phase of a session
+func sessionPhasePretty(phase int) string {
+	switch phase {
+	case store.SessionPhaseWaiting:
+		return "Waiting for client"
+	case store.SessionPhaseEntered:
+		return "In toilet"
+	case store.SessionPhaseFinished:
+		return "Finished business"
+	default:
+		return "nil"
+	}
+}
+
+// Lists all the registered toilets
+func (bot *Bot) botCommandGetToilets(update tgbotapi.Update) string {
+	toilets, err := bot.store.Toilets.List()
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE
+	}
+
+	if len(toilets) == 0 {
+		return "No toilets found in the database."
+	}
+	message := "<b>List of toilets</b>\n"
+	for _, toilet := range toilets {
+		message += fmt.Sprintf("[%d] %s - %s\n",
+			toilet.Id, toilet.Name, toilet.Location,
+		)
+	}
+	return message
+}
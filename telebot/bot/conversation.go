@@ -0,0 +1,396 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/genekkion/PottySenseShared/store"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// Commands waiting for replies time out if the TO does
+// not reply within the timeout. Conversations are kept a
+// while longer, so that late replies are told so.
+const (
+	conversationTimeout = 5 * time.Minute
+	conversationKeep    = time.Hour
+)
+
+// Prefix of the callback data of the buttons offered during a
+// conversation, followed by the value picked, which is handled
+// as if the TO had sent it
+const conversationCallbackPrefix = "conv:"
+
+// State of a command of a chat which is waiting for replies,
+// kept in the redis cache. Only the TO who sent the command
+// may reply, as group chats are shared by several TOs.
+type conversation struct {
+	Command string `json:"command"`
+	ToId    int    `json:"toId"`
+	// Reply the command is waiting for, empty once finished
+	Step string `json:"step"`
+	// Replies so far, by step
+	Values    map[string]string `json:"values"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+// Handles a reply to a conversation, updating its step, and
+// returns the message to send back. Sets the step to empty
+// once the command is finished.
+type conversationStepFunc func(bot *Bot, to store.TO, conv *conversation,
+	input string) (string, *tgbotapi.InlineKeyboardMarkup)
+
+var conversationSteps = map[string]conversationStepFunc{
+	"session":    (*Bot).conversationSession,
+	"track":      (*Bot).conversationTrack,
+	"addclient":  (*Bot).conversationAddClient,
+	"editclient": (*Bot).conversationEditClient,
+	"assign":     (*Bot).conversationAssign,
+	"broadcast":  (*Bot).conversationBroadcast,
+}
+
+// Permission needed by the commands with conversations, checked
+// on every reply as the role of the TO may change meanwhile
+var conversationPermissions = map[string]string{
+	"session":    store.PermissionTrack,
+	"track":      store.PermissionTrack,
+	"addclient":  store.PermissionEditClients,
+	"editclient": store.PermissionEditClients,
+	"assign":     store.PermissionAssignClients,
+	"broadcast":  store.PermissionBroadcast,
+}
+
+func conversationKey(chatId int64) string {
+	return "conversation:" + strconv.FormatInt(chatId, 10)
+}
+
+// Gets the conversation of the chat, returning redis.Nil
+// if there is none
+func (bot *Bot) getConversation(chatId int64) (conversation, error) {
+	var conv conversation
+	value, err := bot.redisCache.Get(context.Background(),
+		conversationKey(chatId)).Result()
+	if err != nil {
+		return conv, err
+	}
+	err = json.Unmarshal([]byte(value), &conv)
+	return conv, err
+}
+
+// Saves the conversation of the chat, restarting its timeout
+func (bot *Bot) setConversation(chatId int64, conv conversation) error {
+	conv.ExpiresAt = time.Now().Add(conversationTimeout)
+	value, err := json.Marshal(conv)
+	if err != nil {
+		return err
+	}
+	return bot.redisCache.Set(context.Background(), conversationKey(chatId),
+		value, conversationTimeout+conversationKeep).Err()
+}
+
+// Ends the conversation of the chat, returning whether
+// there was a conversation waiting for replies
+func (bot *Bot) endConversation(chatId int64) bool {
+	conv, err := bot.getConversation(chatId)
+	if err == redis.Nil {
+		return false
+	} else if err != nil {
+		log.Println(err)
+	}
+	err = bot.redisCache.Del(context.Background(), conversationKey(chatId)).Err()
+	if err != nil {
+		log.Println(err)
+	}
+	return conv.Step != "" && time.Now().Before(conv.ExpiresAt)
+}
+
+// Ends the conversation of the chat when the command is sent by
+// the TO who started it, as any command stops their conversation.
+// Returns whether there was a conversation waiting for replies.
+func (bot *Bot) stopConversation(update tgbotapi.Update) bool {
+	conv, err := bot.getConversation(update.Message.Chat.ID)
+	if err == redis.Nil {
+		return false
+	} else if err != nil {
+		log.Println(err)
+		return false
+	}
+	to, err := bot.getSenderTO(update)
+	if err != nil || to.Id != conv.ToId {
+		return false
+	}
+	return bot.endConversation(update.Message.Chat.ID)
+}
+
+// Starts a conversation for the command. Any arguments of the
+// command are handled as the reply to the first step.
+func (bot *Bot) startConversation(update tgbotapi.Update, command string,
+	firstStep string, prompt string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	to, err := bot.getSenderTO(update)
+	if err != nil {
+		log.Println(err)
+		return "Unauthorized user.", nil
+	}
+	chatId := update.Message.Chat.ID
+	conv := conversation{
+		Command: command,
+		ToId:    to.Id,
+		Step:    firstStep,
+		Values:  map[string]string{},
+	}
+
+	args := strings.TrimSpace(update.Message.CommandArguments())
+	if args == "" {
+		err = bot.setConversation(chatId, conv)
+		if err != nil {
+			log.Println(err)
+			return GENERIC_ERROR_MESSAGE, nil
+		}
+		return prompt + "\n<i>Send /stop to stop.</i>", nil
+	}
+	return bot.stepConversation(chatId, to, conv, args)
+}
+
+// Handles a message which is not a command as the reply to
+// the conversation of the chat. Returns an empty message if
+// the chat has no conversation, or the message is not from
+// the TO who started it, so that nothing is sent.
+func (bot *Bot) continueConversation(update tgbotapi.Update) (string,
+	*tgbotapi.InlineKeyboardMarkup) {
+	chatId := update.Message.Chat.ID
+	conv, err := bot.getConversation(chatId)
+	if err == redis.Nil {
+		return "", nil
+	} else if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE, nil
+	}
+
+	to, err := bot.getSenderTO(update)
+	if err != nil || to.Id != conv.ToId {
+		return "", nil
+	}
+	return bot.replyConversation(chatId, to, conv, update.Message.Text)
+}
+
+// Handles the reply to the conversation, unless it has timed out
+func (bot *Bot) replyConversation(chatId int64, to store.TO,
+	conv conversation, input string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	if conv.Step == "" {
+		return "", nil
+	} else if time.Now().After(conv.ExpiresAt) {
+		bot.endConversation(chatId)
+		return "Your /" + conv.Command + " command timed out. Please send it again.", nil
+	}
+	return bot.stepConversation(chatId, to, conv, strings.TrimSpace(input))
+}
+
+// Handles the input for the current step of the conversation,
+// saving the conversation if it is still waiting for replies
+func (bot *Bot) stepConversation(chatId int64, to store.TO,
+	conv conversation, input string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	step, ok := conversationSteps[conv.Command]
+	if !ok {
+		bot.endConversation(chatId)
+		return GENERIC_ERROR_MESSAGE, nil
+	}
+	if !store.HasPermission(to.UserType, conversationPermissions[conv.Command]) {
+		bot.endConversation(chatId)
+		return "You do not have permission to use this command.", nil
+	}
+
+	message, keyboard := step(bot, to, &conv, input)
+	var err error
+	if conv.Step == "" {
+		bot.endConversation(chatId)
+	} else {
+		err = bot.setConversation(chatId, conv)
+	}
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE, nil
+	}
+	return message, keyboard
+}
+
+// Handles the press of a button offered during a conversation,
+// sending the reply as a new message and removing the buttons
+// so that they cannot be pressed again
+func (bot *Bot) callbackConversation(query *tgbotapi.CallbackQuery,
+	value string) string {
+	if query.Message == nil {
+		return "Unknown action."
+	}
+	chatId := query.Message.Chat.ID
+	to, err := bot.getCallbackTO(query)
+	if err != nil {
+		log.Println(err)
+		return "Unauthorized user."
+	}
+
+	conv, err := bot.getConversation(chatId)
+	if err == redis.Nil {
+		return "This command has already finished."
+	} else if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE
+	}
+	if to.Id != conv.ToId {
+		return "Only the officer who sent the command can reply."
+	}
+
+	_, err = bot.bot.Request(tgbotapi.NewEditMessageReplyMarkup(chatId,
+		query.Message.MessageID, tgbotapi.NewInlineKeyboardMarkup()))
+	if err != nil {
+		log.Println("Error removing conversation buttons")
+		log.Println(err)
+	}
+
+	text, keyboard := bot.replyConversation(chatId, to, conv, value)
+	if text == "" {
+		return "This command has already finished."
+	}
+	message := tgbotapi.NewMessage(chatId, text)
+	message.ParseMode = tgbotapi.ModeHTML
+	message.ReplyMarkup = keyboard
+	_, err = bot.bot.Send(message)
+	if err != nil {
+		log.Println("Error sending message")
+		log.Println(err)
+	}
+	return ""
+}
+
+// Buttons to pick one of the values, one per row
+func conversationKeyboard(labels []string,
+	values []string) *tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, label := range labels {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label,
+				conversationCallbackPrefix+values[i])))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &keyboard
+}
+
+// Most clients or officers offered to pick from
+const pickLimit = 10
+
+// Finds the client from the input, which is either the id or the
+// start of the name of the client. Returns a client with id 0
+// along with the reply to send if the client is not found, or
+// the TO needs to pick one of several clients.
+func (bot *Bot) pickClient(input string) (store.Client, string,
+	*tgbotapi.InlineKeyboardMarkup) {
+	if input == "" {
+		return store.Client{}, "Please send the id or name of the client.", nil
+	}
+
+	if clientId, err := strconv.Atoi(input); err == nil {
+		client, err := bot.store.Clients.Get(clientId)
+		if err != nil {
+			return store.Client{}, "No client found with the id [" + input +
+				"]. Please send the id or name of the client.", nil
+		}
+		return client, "", nil
+	}
+
+	clients, err := bot.store.Clients.Search(input)
+	if err != nil {
+		log.Println(err)
+		return store.Client{}, GENERIC_ERROR_MESSAGE, nil
+	}
+	switch {
+	case len(clients) == 0:
+		return store.Client{}, "No clients found with the name \"" +
+			html.EscapeString(input) + "\". Please try another name.", nil
+	case len(clients) == 1:
+		return clients[0], "", nil
+	case len(clients) > pickLimit:
+		return store.Client{}, fmt.Sprintf("%d clients found with the name \"%s\". "+
+			"Please send more of the name.", len(clients), html.EscapeString(input)), nil
+	}
+
+	var labels, values []string
+	for _, client := range clients {
+		labels = append(labels, fmt.Sprintf("[%d] %s %s",
+			client.Id, client.FirstName, client.LastName))
+		values = append(values, strconv.Itoa(client.Id))
+	}
+	return store.Client{}, "Multiple clients found, please pick one.",
+		conversationKeyboard(labels, values)
+}
+
+// /session, without the ids of the client and toilet
+func (bot *Bot) conversationSession(to store.TO, conv *conversation,
+	input string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	switch conv.Step {
+	case "client":
+		client, message, keyboard := bot.pickClient(input)
+		if client.Id == 0 {
+			return message, keyboard
+		}
+		conv.Values["clientId"] = strconv.Itoa(client.Id)
+
+		toilets, err := bot.store.Toilets.List()
+		if err != nil {
+			log.Println(err)
+			conv.Step = ""
+			return GENERIC_ERROR_MESSAGE, nil
+		}
+		if len(toilets) > 1 {
+			conv.Step = "toilet"
+			var labels, values []string
+			for _, toilet := range toilets {
+				labels = append(labels, fmt.Sprintf("[%d] %s - %s",
+					toilet.Id, toilet.Name, toilet.Location))
+				values = append(values, strconv.Itoa(toilet.Id))
+			}
+			return "Which toilet should the session of " +
+				html.EscapeString(client.FirstName+" "+client.LastName) +
+				" be started at?", conversationKeyboard(labels, values)
+		}
+	case "toilet":
+		if _, err := strconv.Atoi(input); err != nil {
+			return "Please pick one of the toilets, or send the toilet id.", nil
+		}
+		conv.Values["toilet"] = input
+	}
+
+	clientId, _ := strconv.Atoi(conv.Values["clientId"])
+	toiletId, _ := strconv.Atoi(conv.Values["toilet"])
+	message, toiletRequired := bot.startSession(clientId, toiletId, to.Id)
+	if toiletRequired {
+		return "Please pick one of the toilets, or send the toilet id.", nil
+	}
+	conv.Step = ""
+	return message, nil
+}
+
+// /track, without the id of the client
+func (bot *Bot) conversationTrack(to store.TO, conv *conversation,
+	input string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	client, message, keyboard := bot.pickClient(input)
+	if client.Id == 0 {
+		return message, keyboard
+	}
+
+	conv.Step = ""
+	err := bot.store.Track.Add(to.Id, client.Id)
+	if err != nil {
+		log.Println(err)
+		return GENERIC_ERROR_MESSAGE, nil
+	}
+	bot.recordAudit(to, store.AuditActionTrack,
+		store.AuditTargetClient, client.Id, nil, nil)
+	return "Successfully added " + html.EscapeString(client.FirstName+" "+client.LastName) +
+		" to your tracking list!", nil
+}
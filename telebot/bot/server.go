@@ -0,0 +1,108 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/genekkion/PottySenseShared/signing"
+)
+
+// Actions the bot asks of the server, which alone talks to the
+// toilets. The server runs them directly when hosting the bot,
+// otherwise they are sent to the /ext/bot routes, see
+// NewServerClient.
+type ServerClient interface {
+	// toiletId may be 0 if there is only one toilet registered
+	StartSession(clientId int, toiletId int, toId int) error
+	CancelSession(clientId int, toId int, reason string) error
+	RespondAlert(alertId int, toId int, action string) error
+}
+
+// Error of an action refused by the server, with the status
+// of the reply and the message to show the TO
+type ServerError struct {
+	Status  int
+	Message string
+}
+
+func (err ServerError) Error() string {
+	return err.Message
+}
+
+// Gets the message to show the TO for the error of an
+// action, falling back to the generic error message
+func serverErrorMessage(err error) string {
+	var serverErr ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr.Message
+	}
+	log.Println(err)
+	return GENERIC_ERROR_MESSAGE
+}
+
+// Sends the actions of the bot running on its own to the
+// server, signed by the client
+type httpServerClient struct {
+	client *signing.Client
+}
+
+func NewServerClient(client *signing.Client) ServerClient {
+	return httpServerClient{client}
+}
+
+func (server httpServerClient) StartSession(clientId int, toiletId int,
+	toId int) error {
+	return server.send(http.MethodPost, "/ext/bot", map[string]int{
+		"clientId": clientId,
+		"toiletId": toiletId,
+		"toId":     toId,
+	})
+}
+
+func (server httpServerClient) CancelSession(clientId int, toId int,
+	reason string) error {
+	return server.send(http.MethodDelete, "/ext/bot", map[string]interface{}{
+		"clientId": clientId,
+		"toId":     toId,
+		"reason":   reason,
+	})
+}
+
+func (server httpServerClient) RespondAlert(alertId int, toId int,
+	action string) error {
+	return server.send(http.MethodPut, "/ext/bot/alert", map[string]interface{}{
+		"alertId": alertId,
+		"toId":    toId,
+		"action":  action,
+	})
+}
+
+// Sends the value as json to the route, returning the error
+// replied by the server as a ServerError
+func (server httpServerClient) send(method string, path string,
+	value interface{}) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	response, err := server.client.Do(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	log.Println("serverResponse", response.StatusCode)
+
+	if response.StatusCode == http.StatusOK {
+		return nil
+	}
+	var reply struct {
+		Error string `json:"error"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&reply)
+	if err != nil || reply.Error == "" {
+		reply.Error = GENERIC_ERROR_MESSAGE
+	}
+	return ServerError{Status: response.StatusCode, Message: reply.Error}
+}
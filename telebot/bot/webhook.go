@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Header telegram sends the secret token of the webhook in
+const webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Characters and length telegram accepts for the secret token
+var webhookSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// Starts running the bot, receiving updates from telegram
+// at the public url, which the reverse proxy forwards to
+// the address
+func (bot *Bot) RunWebhook(addr string, webhookUrl string, secret string) {
+	path, handler, err := bot.StartWebhook(webhookUrl, secret)
+	if err != nil {
+		log.Println("Error setting webhook.")
+		log.Fatalln(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+	log.Println(bot.bot.Self.UserName + " is receiving updates at " + addr + path)
+	log.Fatalln(http.ListenAndServe(addr, mux))
+}
+
+// Sets the webhook to the public url, returning the path of the
+// url along with the handler receiving the updates, which are
+// replied to in the background. Updates without the secret
+// token are rejected.
+func (bot *Bot) StartWebhook(webhookUrl string,
+	secret string) (string, http.Handler, error) {
+	if !webhookSecretPattern.MatchString(secret) {
+		return "", nil, errors.New("the webhook secret must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+	}
+	link, err := url.Parse(webhookUrl)
+	if err != nil || link.Scheme != "https" || link.Host == "" {
+		return "", nil, errors.New("the webhook url must be a full https url")
+	}
+	path := link.Path
+	if path == "" {
+		path = "/"
+	}
+
+	// The webhook config of the library does not support
+	// the secret token, so the request is made directly
+	params := tgbotapi.Params{
+		"url":          link.String(),
+		"secret_token": secret,
+	}
+	_, err = bot.bot.MakeRequest("setWebhook", params)
+	if err != nil {
+		return "", nil, err
+	}
+
+	updatesChannel := make(chan tgbotapi.Update, bot.bot.Buffer)
+	go bot.handleUpdates(updatesChannel)
+	return path, bot.webhookHandler(secret, updatesChannel), nil
+}
+
+// Receives the updates sent by telegram, checking that
+// the secret token matches the one set for the webhook
+func (bot *Bot) webhookHandler(secret string,
+	updatesChannel chan<- tgbotapi.Update) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if subtle.ConstantTimeCompare(
+			[]byte(request.Header.Get(webhookSecretHeader)), []byte(secret)) != 1 {
+			http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		update, err := bot.bot.HandleUpdate(request)
+		if err != nil {
+			log.Println("Error reading webhook update")
+			log.Println(err)
+			http.Error(writer, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		updatesChannel <- *update
+		writer.WriteHeader(http.StatusOK)
+	})
+}
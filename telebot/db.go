@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"log"
 
+	"github.com/genekkion/PottySenseShared/store"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -17,30 +18,25 @@ func NewSqliteStorage(filepath string) *sql.DB {
 		log.Panic(err)
 	}
 
-	testDB(db)
+	migrateDB(db)
 	log.Println("Sqlite connection successfully created.")
 
 	return db
 }
 
-func testDB(db *sql.DB) {
-	rows, err := db.Query("select * from Tofficers")
+// Applies any pending migrations, so that the schema
+// is up to date without wiping the existing data
+func migrateDB(db *sql.DB) {
+	err := store.Migrate(db)
 	if err != nil {
-		log.Println("query issues")
+		log.Println("db.go - migrateDB()")
 		log.Fatalln(err)
 	}
 
-	for rows.Next() {
-		var id int
-		var first_name string
-		var last_name string
-		var username string
-		var password string
-		var telegram string
-		var userType string
-		err = rows.Scan(&id, &first_name, &last_name,
-			&username, &password, &telegram, &userType)
+	version, err := store.SchemaVersion(db)
+	if err != nil {
+		log.Println("db.go - migrateDB()")
+		log.Fatalln(err)
 	}
-
-	rows.Close()
+	log.Printf("Database schema at version %d.\n", version)
 }
@@ -1,15 +1,18 @@
 module PottySenseTelebot
 
-go 1.22.1
+go 1.21.6
 
 require (
+	github.com/genekkion/PottySenseShared v0.0.0
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 	github.com/joho/godotenv v1.5.1
-	github.com/mattn/go-sqlite3 v1.14.22
-	github.com/redis/go-redis/v9 v9.5.1
+	github.com/mattn/go-sqlite3 v1.14.20
+	github.com/redis/go-redis/v9 v9.4.0
 )
 
 require (
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 )
+
+replace github.com/genekkion/PottySenseShared => ../shared
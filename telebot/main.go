@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"os"
 
+	"PottySenseTelebot/bot"
+
+	"github.com/genekkion/PottySenseShared/signing"
 	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
 )
@@ -22,13 +26,33 @@ var (
 	REQUIRED_ENV = []string{
 		"TELEGRAM_BOT_TOKEN",
 		"REDIS_ADDR",
+		"SERVER_API_KEY",
+		"SERVER_SIGNING_SECRET",
+	}
+)
+
+var (
+	// Env variables required in webhook mode
+	WEBHOOK_REQUIRED_ENV = []string{
+		"TELEGRAM_WEBHOOK_URL",
+		"TELEGRAM_WEBHOOK_SECRET",
 	}
 )
 
 func main() {
 	godotenv.Load("../.env")
 
-	for _, env := range REQUIRED_ENV {
+	modeFlag := flag.String("mode", "polling", "How updates are received from telegram, either \"polling\" or \"webhook\".")
+	flag.Parse()
+	if *modeFlag != "polling" && *modeFlag != "webhook" {
+		log.Fatalln("Unknown mode \"" + *modeFlag + "\", use either \"polling\" or \"webhook\". Exiting.")
+	}
+
+	required := REQUIRED_ENV
+	if *modeFlag == "webhook" {
+		required = append(required, WEBHOOK_REQUIRED_ENV...)
+	}
+	for _, env := range required {
 		if os.Getenv(env) == "" {
 			log.Fatalln("Required env variable\"" + env + "\" not set. Exiting.")
 		}
@@ -44,6 +68,22 @@ func main() {
 	if err != nil {
 		log.Fatalln(err)
 	}
-	bot := NewBot(telegramBotToken, db, redisCache)
-	bot.Run()
+	// Requests to the server are signed with the signing
+	// secret issued along with the API key
+	server := bot.NewServerClient(signing.NewClient(
+		"http://"+os.Getenv("SERVER_ADDR"),
+		os.Getenv("SERVER_API_KEY"),
+		os.Getenv("SERVER_SIGNING_SECRET"),
+	))
+	telegramBot := bot.NewBot(telegramBotToken, db, redisCache, server)
+	if *modeFlag == "webhook" {
+		webhookAddr := os.Getenv("TELEGRAM_WEBHOOK_ADDR")
+		if webhookAddr == "" {
+			webhookAddr = ":3001"
+		}
+		telegramBot.RunWebhook(webhookAddr, os.Getenv("TELEGRAM_WEBHOOK_URL"),
+			os.Getenv("TELEGRAM_WEBHOOK_SECRET"))
+	} else {
+		telegramBot.Run()
+	}
 }